@@ -1,11 +1,72 @@
 // Package api holds the structs used by the api.
 package api
 
-import "strings"
+import (
+	"time"
+
+	"github.com/cdempsie/payments-example/money"
+)
 
 // ListHolder contains the struct used to respond to a list collection response.
 type ListHolder struct {
-	Data []Payment `json:"data"`
+	Data  []Payment `json:"data"`
+	Links Links     `json:"links"`
+	Meta  *ListMeta `json:"meta,omitempty"`
+}
+
+// PaymentHolder contains the struct used to respond with a single payment.
+type PaymentHolder struct {
+	Data  Payment                `json:"data"`
+	Links Links                  `json:"links"`
+	Meta  map[string]interface{} `json:"meta,omitempty"`
+}
+
+// ErrorHolder contains the struct used to respond when a request fails.
+type ErrorHolder struct {
+	Errors []Error `json:"errors"`
+}
+
+// Error describes a single problem with a request.
+type Error struct {
+	// Code is a stable, machine readable, identifier for the kind of problem.
+	Code string `json:"code"`
+	// Title is a short human readable summary of the kind of problem.
+	Title string `json:"title"`
+	// Detail is a human readable explanation specific to this occurrence of the problem.
+	Detail string       `json:"detail,omitempty"`
+	Source *ErrorSource `json:"source,omitempty"`
+	Meta   *ErrorMeta   `json:"meta,omitempty"`
+}
+
+// ErrorMeta holds further machine readable detail about an error.
+type ErrorMeta struct {
+	// Operation is the operation the caller was not permitted to perform.
+	Operation string `json:"operation,omitempty"`
+	// RequiredRoles lists the roles that would permit the operation.
+	RequiredRoles []string `json:"required_roles,omitempty"`
+}
+
+// ErrorSource identifies the part of the request that caused an error.
+type ErrorSource struct {
+	// Pointer is a JSON pointer (RFC 6901) to the field in the request body, for example /attributes/amount.
+	Pointer string `json:"pointer,omitempty"`
+	// Parameter is the name of the query parameter.
+	Parameter string `json:"parameter,omitempty"`
+}
+
+// Links holds the links to a resource and, for collections, to the other pages of the collection.
+type Links struct {
+	Self  string `json:"self"`
+	First string `json:"first,omitempty"`
+	Prev  string `json:"prev,omitempty"`
+	Next  string `json:"next,omitempty"`
+	Last  string `json:"last,omitempty"`
+}
+
+// ListMeta holds information about a list collection response as a whole.
+type ListMeta struct {
+	// TotalCount is the number of payments matching the request across all pages.
+	TotalCount int `json:"total_count"`
 }
 
 // Payment API type.
@@ -14,39 +75,33 @@ type Payment struct {
 	ID             string `json:"id"`
 	Version        int    `json:"version"`
 	OrganisationID string `json:"organisation_id"`
-	Attributes     `json:"attributes"`
+	// CreatedBy is who created the payment, it is set when the payment is created and kept by every change.
+	CreatedBy string `json:"created_by,omitempty"`
+	// Status is where the payment is in its lifecycle, it is changed through the transition endpoints rather than by
+	// updating the payment.
+	Status        Status         `json:"status,omitempty"`
+	StatusHistory []StatusChange `json:"status_history,omitempty"`
+	// DeletedAt and DeletedBy are set when the payment has been soft deleted, it is kept until the retention period
+	// has passed so it can be restored.
+	DeletedAt  *time.Time `json:"deleted_at,omitempty"`
+	DeletedBy  string     `json:"deleted_by,omitempty"`
+	Attributes `json:"attributes"`
 }
 
 // Valid returns true if the payment passes validation. Otherwise it returns false and a message containing the
-// detected errors.
+// detected errors. Use Validate to get the errors for each field.
 func (payment *Payment) Valid() (valid bool, messages string) {
-	valid = true
-	msgBuf := &strings.Builder{}
-
-	// example spot check validation only.
-	if payment.Type == "" {
-		valid = false
-		msgBuf.WriteString("payment type is missing")
-	}
-	if payment.OrganisationID == "" {
-		valid = false
-		if msgBuf.Len() >0 {
-			msgBuf.WriteString(", ")
-		}
-		msgBuf.WriteString( "payment organisation ID is missing")
-	}
-
-	if msgBuf.Len() > 0 {
-		messages = msgBuf.String()
-		strings.TrimSpace(messages)
+	errs := payment.Validate()
+	if len(errs) > 0 {
+		return false, errs.Error()
 	}
 
-	return
+	return true, ""
 }
 
 // Attributes API type.
 type Attributes struct {
-	Amount               string `json:"amount"`
+	Amount               money.Decimal `json:"amount"`
 	BeneficiaryParty     `json:"beneficiary_party"`
 	ChargesInformation   `json:"charges_information"`
 	Currency             string `json:"currency"`
@@ -79,13 +134,16 @@ type BeneficiaryParty struct {
 
 // ChargesInformation API type.
 type ChargesInformation struct {
-	BearerCode    string `json:"bearer_code"`
-	SenderCharges []struct {
-		Amount   string `json:"amount"`
-		Currency string `json:"currency"`
-	} `json:"sender_charges"`
-	ReceiverChargesAmount   string `json:"receiver_charges_amount"`
-	ReceiverChargesCurrency string `json:"receiver_charges_currency"`
+	BearerCode              string         `json:"bearer_code"`
+	SenderCharges           []SenderCharge `json:"sender_charges"`
+	ReceiverChargesAmount   money.Decimal  `json:"receiver_charges_amount"`
+	ReceiverChargesCurrency string         `json:"receiver_charges_currency"`
+}
+
+// SenderCharge API type.
+type SenderCharge struct {
+	Amount   money.Decimal `json:"amount"`
+	Currency string        `json:"currency"`
 }
 
 // DebtorParty API type.
@@ -101,10 +159,10 @@ type DebtorParty struct {
 
 // Fx API type.
 type Fx struct {
-	ContractReference string `json:"contract_reference"`
-	ExchangeRate      string `json:"exchange_rate"`
-	OriginalAmount    string `json:"original_amount"`
-	OriginalCurrency  string `json:"original_currency"`
+	ContractReference string        `json:"contract_reference"`
+	ExchangeRate      money.Decimal `json:"exchange_rate"`
+	OriginalAmount    money.Decimal `json:"original_amount"`
+	OriginalCurrency  string        `json:"original_currency"`
 }
 
 // SponsorParty API type.
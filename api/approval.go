@@ -0,0 +1,48 @@
+package api
+
+import (
+	"time"
+
+	"github.com/cdempsie/payments-example/money"
+)
+
+// ApprovalState is where a request for a second person to approve a payment has got to.
+type ApprovalState string
+
+// The states of an approval. An approval is pending until someone other than the people who made the payment either
+// approves or rejects it.
+const (
+	ApprovalPending  ApprovalState = "pending"
+	ApprovalApproved ApprovalState = "approved"
+	ApprovalRejected ApprovalState = "rejected"
+)
+
+// Approval records a high value payment waiting for, or having had, approval by a second person before it can be
+// submitted.
+type Approval struct {
+	PaymentID string        `json:"payment_id"`
+	State     ApprovalState `json:"state"`
+	// Amount and Currency are the terms of the payment being approved.
+	Amount   money.Decimal `json:"amount"`
+	Currency string        `json:"currency"`
+	// CreatedBy is who created the payment and RequestedBy who made the change that needs approving, neither of them
+	// can approve it.
+	CreatedBy   string    `json:"created_by"`
+	RequestedBy string    `json:"requested_by"`
+	RequestedAt time.Time `json:"requested_at"`
+	// DecidedBy, DecidedAt and Reason are set once the payment has been approved or rejected.
+	DecidedBy string     `json:"decided_by,omitempty"`
+	DecidedAt *time.Time `json:"decided_at,omitempty"`
+	Reason    string     `json:"reason,omitempty"`
+}
+
+// ApprovalHolder contains the struct used to respond with the approval of a payment.
+type ApprovalHolder struct {
+	Data  Approval `json:"data"`
+	Links Links    `json:"links"`
+}
+
+// ApprovalDecisionRequest is the optional body of a request to approve or reject a payment.
+type ApprovalDecisionRequest struct {
+	Reason string `json:"reason"`
+}
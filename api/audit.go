@@ -0,0 +1,49 @@
+package api
+
+import "time"
+
+// AuditAction is the kind of change an audit event records.
+type AuditAction string
+
+// The changes recorded in the audit trail of a payment.
+const (
+	ActionCreated       AuditAction = "created"
+	ActionUpdated       AuditAction = "updated"
+	ActionStatusChanged AuditAction = "status_changed"
+	ActionDeleted       AuditAction = "deleted"
+	ActionRestored      AuditAction = "restored"
+	// ActionPurged records a deleted payment being removed for good once its retention period passed.
+	ActionPurged AuditAction = "purged"
+)
+
+// AuditEvent records a single change made to a payment. Events are never changed once recorded.
+type AuditEvent struct {
+	// Sequence orders the events across all payments, starting at 1.
+	Sequence  int    `json:"sequence"`
+	PaymentID string `json:"payment_id"`
+	// Version is the version of the payment after the change, or the last version for a purge.
+	Version int         `json:"version"`
+	Action  AuditAction `json:"action"`
+	// Actor identifies who made the change.
+	Actor     string    `json:"actor"`
+	RequestID string    `json:"request_id,omitempty"`
+	At        time.Time `json:"at"`
+	// Changes lists each field that changed, for a create every field is listed as changing from nothing.
+	Changes []FieldChange `json:"changes"`
+	// Payment is the whole payment after the change, nil once it has been purged.
+	Payment *Payment `json:"payment,omitempty"`
+}
+
+// FieldChange describes one field of a payment changing value.
+type FieldChange struct {
+	// Pointer is a JSON pointer (RFC 6901) to the field within the payment, for example /attributes/amount.
+	Pointer string      `json:"pointer"`
+	From    interface{} `json:"from,omitempty"`
+	To      interface{} `json:"to,omitempty"`
+}
+
+// HistoryHolder contains the struct used to respond with the audit trail of a payment.
+type HistoryHolder struct {
+	Data  []AuditEvent `json:"data"`
+	Links Links        `json:"links"`
+}
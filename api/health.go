@@ -0,0 +1,15 @@
+package api
+
+// Health reports whether the server, or something it depends on, is usable.
+type Health struct {
+	// Status is ok when usable and unavailable otherwise.
+	Status string `json:"status"`
+}
+
+// BuildInfo describes the build of the server that is running.
+type BuildInfo struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit"`
+	BuildDate string `json:"build_date"`
+	GoVersion string `json:"go_version"`
+}
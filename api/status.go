@@ -0,0 +1,33 @@
+package api
+
+import "time"
+
+// Status is a stage in the lifecycle of a payment.
+type Status string
+
+// The statuses a payment moves through. A payment is created, validated, submitted to the scheme and then either
+// settled or rejected. A settled payment may later be returned and a payment may be cancelled until it is submitted.
+const (
+	StatusCreated   Status = "created"
+	StatusValidated Status = "validated"
+	StatusSubmitted Status = "submitted"
+	StatusSettled   Status = "settled"
+	StatusRejected  Status = "rejected"
+	StatusReturned  Status = "returned"
+	StatusCancelled Status = "cancelled"
+)
+
+// StatusChange records a payment moving from one status to another.
+type StatusChange struct {
+	From Status `json:"from"`
+	To   Status `json:"to"`
+	// Actor identifies who made the change.
+	Actor  string    `json:"actor"`
+	At     time.Time `json:"at"`
+	Reason string    `json:"reason,omitempty"`
+}
+
+// StatusChangeRequest is the optional body of a request to move a payment to a new status.
+type StatusChangeRequest struct {
+	Reason string `json:"reason"`
+}
@@ -0,0 +1,331 @@
+package api
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/cdempsie/payments-example/money"
+)
+
+// FieldError describes a field of a payment that failed validation.
+type FieldError struct {
+	// Pointer is a JSON pointer (RFC 6901) to the field within the payment, for example /attributes/amount.
+	Pointer string
+	Message string
+}
+
+func (err FieldError) Error() string {
+	return err.Pointer + " " + err.Message
+}
+
+// ValidationErrors holds every problem found when validating a payment.
+type ValidationErrors []FieldError
+
+func (errs ValidationErrors) Error() string {
+	messages := make([]string, 0, len(errs))
+	for _, err := range errs {
+		messages = append(messages, err.Error())
+	}
+
+	return strings.Join(messages, ", ")
+}
+
+// Rule checks one aspect of a payment and returns a FieldError for each problem found.
+type Rule func(payment *Payment) []FieldError
+
+// Validator checks payments against a set of rules.
+type Validator struct {
+	rules []Rule
+}
+
+// NewValidator returns a validator that applies the rules in the order given.
+func NewValidator(rules ...Rule) *Validator {
+	return &Validator{rules: rules}
+}
+
+// Validate applies every rule to the payment returning all the problems found, or nil if there were none.
+func (validator *Validator) Validate(payment *Payment) ValidationErrors {
+	var errs ValidationErrors
+	for _, rule := range validator.rules {
+		errs = append(errs, rule(payment)...)
+	}
+
+	return errs
+}
+
+// DefaultValidator holds the rules every payment must pass.
+var DefaultValidator = NewValidator(
+	RequiredFields,
+	IDFormats,
+	Amounts,
+	Currencies,
+	ProcessingDate,
+	AccountNumbers,
+	BankIDCodes,
+	BearerCode,
+	FxConsistency,
+)
+
+// Validate checks the payment against the DefaultValidator returning all the problems found, or nil if there were none.
+func (payment *Payment) Validate() ValidationErrors {
+	return DefaultValidator.Validate(payment)
+}
+
+var (
+	uuidPattern  = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+	ibanPattern  = regexp.MustCompile(`^[A-Z]{2}[0-9]{2}[A-Z0-9]{11,30}$`)
+	bbanPattern  = regexp.MustCompile(`^[A-Z0-9]{1,30}$`)
+	sortCodeBBAN = regexp.MustCompile(`^[0-9]{8}$`)
+)
+
+// bankIDFormats maps the supported bank_id_code values to the format of the bank_id they identify.
+var bankIDFormats = map[string]*regexp.Regexp{
+	// UK sort code
+	"GBDSC": regexp.MustCompile(`^[0-9]{6}$`),
+	// SWIFT BIC
+	"SWBIC": regexp.MustCompile(`^[A-Z]{6}[A-Z0-9]{2}([A-Z0-9]{3})?$`),
+	// German Bankleitzahl
+	"DEBLZ": regexp.MustCompile(`^[0-9]{8}$`),
+	// US ABA routing number
+	"USABA": regexp.MustCompile(`^[0-9]{9}$`),
+}
+
+// bearerCodes are the supported values for who bears the charges of a payment.
+var bearerCodes = map[string]bool{"SHAR": true, "BEAR": true, "CRED": true, "DEBT": true}
+
+// RequiredFields checks the fields every payment must have are present.
+func RequiredFields(payment *Payment) []FieldError {
+	var errs []FieldError
+	required := []struct {
+		pointer string
+		present bool
+	}{
+		{"/type", payment.Type != ""},
+		{"/organisation_id", payment.OrganisationID != ""},
+		{"/attributes/amount", payment.Amount.IsSet()},
+		{"/attributes/currency", payment.Currency != ""},
+		{"/attributes/processing_date", payment.ProcessingDate != ""},
+	}
+	for _, field := range required {
+		if !field.present {
+			errs = append(errs, FieldError{field.pointer, "is missing"})
+		}
+	}
+
+	return errs
+}
+
+// IDFormats checks the payment and organisation IDs, when present, are UUIDs.
+func IDFormats(payment *Payment) []FieldError {
+	var errs []FieldError
+	if payment.ID != "" && !uuidPattern.MatchString(payment.ID) {
+		errs = append(errs, FieldError{"/id", "must be a UUID"})
+	}
+	if payment.OrganisationID != "" && !uuidPattern.MatchString(payment.OrganisationID) {
+		errs = append(errs, FieldError{"/organisation_id", "must be a UUID"})
+	}
+
+	return errs
+}
+
+// Amounts checks every amount, when present, is not negative and is a whole number of the minor unit of its currency,
+// and that the payment amount and exchange rate are positive.
+func Amounts(payment *Payment) []FieldError {
+	var errs []FieldError
+	check := func(pointer string, value money.Decimal, currency string, positive bool) {
+		if !value.IsSet() {
+			return
+		}
+		switch {
+		case positive && value.Sign() <= 0:
+			errs = append(errs, FieldError{pointer, "must be greater than zero"})
+		case value.Sign() < 0:
+			errs = append(errs, FieldError{pointer, "must not be negative"})
+		case currency != "" && money.ValidCurrency(currency) && !value.FitsCurrency(currency):
+			places, _ := money.MinorUnits(currency)
+			errs = append(errs, FieldError{pointer,
+				fmt.Sprintf("must have no more than %d decimal places for %s", places, currency)})
+		}
+	}
+
+	check("/attributes/amount", payment.Amount, payment.Currency, true)
+	for i, charge := range payment.ChargesInformation.SenderCharges {
+		check(fmt.Sprintf("/attributes/charges_information/sender_charges/%d/amount", i), charge.Amount,
+			charge.Currency, false)
+	}
+	check("/attributes/charges_information/receiver_charges_amount",
+		payment.ChargesInformation.ReceiverChargesAmount, payment.ChargesInformation.ReceiverChargesCurrency, false)
+	check("/attributes/fx/original_amount", payment.Fx.OriginalAmount, payment.Fx.OriginalCurrency, true)
+	check("/attributes/fx/exchange_rate", payment.Fx.ExchangeRate, "", true)
+
+	return errs
+}
+
+// Currencies checks every currency, when present, is an ISO 4217 currency code.
+func Currencies(payment *Payment) []FieldError {
+	var errs []FieldError
+	check := func(pointer, code string) {
+		if code != "" && !money.ValidCurrency(code) {
+			errs = append(errs, FieldError{pointer, "must be an ISO 4217 currency code, for example GBP"})
+		}
+	}
+
+	check("/attributes/currency", payment.Currency)
+	for i, charge := range payment.ChargesInformation.SenderCharges {
+		check(fmt.Sprintf("/attributes/charges_information/sender_charges/%d/currency", i), charge.Currency)
+	}
+	check("/attributes/charges_information/receiver_charges_currency",
+		payment.ChargesInformation.ReceiverChargesCurrency)
+	check("/attributes/fx/original_currency", payment.Fx.OriginalCurrency)
+
+	return errs
+}
+
+// ProcessingDate checks the processing date, when present, is an ISO 8601 date.
+func ProcessingDate(payment *Payment) []FieldError {
+	if payment.ProcessingDate == "" {
+		return nil
+	}
+	if _, err := time.Parse("2006-01-02", payment.ProcessingDate); err != nil {
+		return []FieldError{{"/attributes/processing_date", "must be a date in the form YYYY-MM-DD"}}
+	}
+
+	return nil
+}
+
+// AccountNumbers checks the beneficiary and debtor account numbers match their account_number_code.
+// IBANs must have a valid checksum and BBANs held at a UK sort code must be 8 digits.
+func AccountNumbers(payment *Payment) []FieldError {
+	var errs []FieldError
+	check := func(party, number, code, bankIDCode string) {
+		if number == "" && code == "" {
+			return
+		}
+		switch code {
+		case "IBAN":
+			if !validIBAN(number) {
+				errs = append(errs, FieldError{party + "/account_number", "must be an IBAN with a valid checksum"})
+			}
+		case "BBAN":
+			if bankIDCode == "GBDSC" && !sortCodeBBAN.MatchString(number) {
+				errs = append(errs, FieldError{party + "/account_number", "must be 8 digits for a GBDSC bank"})
+			} else if !bbanPattern.MatchString(number) {
+				errs = append(errs, FieldError{party + "/account_number", "must be up to 30 letters and digits"})
+			}
+		default:
+			errs = append(errs, FieldError{party + "/account_number_code", "must be IBAN or BBAN"})
+		}
+	}
+
+	beneficiary := payment.BeneficiaryParty
+	check("/attributes/beneficiary_party", beneficiary.AccountNumber, beneficiary.AccountNumberCode,
+		beneficiary.BankIDCode)
+	debtor := payment.DebtorParty
+	check("/attributes/debtor_party", debtor.AccountNumber, debtor.AccountNumberCode, debtor.BankIDCode)
+
+	return errs
+}
+
+// validIBAN checks the format and the ISO 7064 mod 97-10 checksum of the IBAN.
+func validIBAN(iban string) bool {
+	if !ibanPattern.MatchString(iban) {
+		return false
+	}
+
+	// move the country code and check digits to the end then read letters as numbers, A = 10 to Z = 35
+	rearranged := iban[4:] + iban[:4]
+	remainder := 0
+	for _, r := range rearranged {
+		value := int(r - '0')
+		if r >= 'A' {
+			value = int(r-'A') + 10
+		}
+		if value >= 10 {
+			remainder = (remainder*100 + value) % 97
+		} else {
+			remainder = (remainder*10 + value) % 97
+		}
+	}
+
+	return remainder == 1
+}
+
+// BankIDCodes checks each party's bank_id_code is supported and its bank_id has the matching format.
+func BankIDCodes(payment *Payment) []FieldError {
+	var errs []FieldError
+	check := func(party, bankID, bankIDCode string) {
+		if bankID == "" && bankIDCode == "" {
+			return
+		}
+		format, ok := bankIDFormats[bankIDCode]
+		if !ok {
+			errs = append(errs, FieldError{party + "/bank_id_code", "must be one of GBDSC, SWBIC, DEBLZ or USABA"})
+			return
+		}
+		if !format.MatchString(bankID) {
+			errs = append(errs, FieldError{party + "/bank_id", "is not a valid " + bankIDCode + " bank ID"})
+		}
+	}
+
+	check("/attributes/beneficiary_party", payment.BeneficiaryParty.BankID, payment.BeneficiaryParty.BankIDCode)
+	check("/attributes/debtor_party", payment.DebtorParty.BankID, payment.DebtorParty.BankIDCode)
+	check("/attributes/sponsor_party", payment.SponsorParty.BankID, payment.SponsorParty.BankIDCode)
+
+	return errs
+}
+
+// BearerCode checks the charges bearer code, when present, is one of SHAR, BEAR, CRED or DEBT.
+func BearerCode(payment *Payment) []FieldError {
+	code := payment.ChargesInformation.BearerCode
+	if code != "" && !bearerCodes[code] {
+		return []FieldError{{"/attributes/charges_information/bearer_code", "must be one of SHAR, BEAR, CRED or DEBT"}}
+	}
+
+	return nil
+}
+
+// FxConsistency checks that when any foreign exchange detail is given they all are and that the amount multiplied by
+// the exchange rate is the original amount, to the nearest minor unit of the original currency.
+func FxConsistency(payment *Payment) []FieldError {
+	fx := payment.Fx
+	if !fx.ExchangeRate.IsSet() && !fx.OriginalAmount.IsSet() && fx.OriginalCurrency == "" {
+		return nil
+	}
+
+	var errs []FieldError
+	for _, field := range []struct {
+		pointer string
+		present bool
+	}{
+		{"/attributes/fx/exchange_rate", fx.ExchangeRate.IsSet()},
+		{"/attributes/fx/original_amount", fx.OriginalAmount.IsSet()},
+		{"/attributes/fx/original_currency", fx.OriginalCurrency != ""},
+	} {
+		if !field.present {
+			errs = append(errs, FieldError{field.pointer, "is missing, all the fx details must be given together"})
+		}
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+
+	minorUnits, ok := money.MinorUnits(fx.OriginalCurrency)
+	if !ok {
+		// reported by the currency rule
+		return nil
+	}
+	converted, err := payment.Amount.Mul(fx.ExchangeRate)
+	if err != nil {
+		return []FieldError{{"/attributes/fx/exchange_rate", "is too large or too precise to convert the amount"}}
+	}
+
+	converted = converted.Round(minorUnits)
+	if converted.Cmp(fx.OriginalAmount) != 0 {
+		return []FieldError{{"/attributes/fx/original_amount",
+			fmt.Sprintf("must be the amount multiplied by the exchange rate, %s", converted)}}
+	}
+
+	return nil
+}
@@ -0,0 +1,107 @@
+package api_test
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/cdempsie/payments-example/api"
+	"github.com/cdempsie/payments-example/money"
+	api_test "github.com/cdempsie/payments-example/test"
+)
+
+// TestValidateSample tests that the sample payment passes every rule.
+func TestValidateSample(t *testing.T) {
+	payment := samplePayment(t)
+	if errs := payment.Validate(); len(errs) > 0 {
+		t.Fatalf("Expected sample payment to be valid but got: %v", errs)
+	}
+	if ok, msg := payment.Valid(); !ok {
+		t.Fatalf("Expected sample payment to be valid but got: %s", msg)
+	}
+}
+
+// TestValidateRules tests that each rule reports a problem against the right field.
+func TestValidateRules(t *testing.T) {
+	tests := []struct {
+		name    string
+		change  func(payment *api.Payment)
+		pointer string
+	}{
+		{"missing type", func(p *api.Payment) { p.Type = "" }, "/type"},
+		{"missing organisation", func(p *api.Payment) { p.OrganisationID = "" }, "/organisation_id"},
+		{"id not a uuid", func(p *api.Payment) { p.ID = "1234" }, "/id"},
+		{"missing amount", func(p *api.Payment) { p.Amount = money.Decimal{} }, "/attributes/amount"},
+		{"amount zero", func(p *api.Payment) { p.Amount = money.MustParse("0.00") }, "/attributes/amount"},
+		{"amount negative", func(p *api.Payment) { p.Amount = money.MustParse("-5.00") }, "/attributes/amount"},
+		{"amount precision", func(p *api.Payment) { p.Amount = money.MustParse("100.211") }, "/attributes/amount"},
+		{"amount precision for currency", func(p *api.Payment) { p.Currency = "JPY" }, "/attributes/amount"},
+		{"sender charge precision", func(p *api.Payment) {
+			p.ChargesInformation.SenderCharges[0].Amount = money.MustParse("5.001")
+		}, "/attributes/charges_information/sender_charges/0/amount"},
+		{"unknown currency", func(p *api.Payment) { p.Currency = "ABC" }, "/attributes/currency"},
+		{"sender charge currency", func(p *api.Payment) { p.ChargesInformation.SenderCharges[1].Currency = "usd" },
+			"/attributes/charges_information/sender_charges/1/currency"},
+		{"processing date format", func(p *api.Payment) { p.ProcessingDate = "18/01/2017" },
+			"/attributes/processing_date"},
+		{"processing date invalid", func(p *api.Payment) { p.ProcessingDate = "2017-02-30" },
+			"/attributes/processing_date"},
+		{"iban checksum", func(p *api.Payment) { p.DebtorParty.AccountNumber = "GB29XABC10161234567801" },
+			"/attributes/debtor_party/account_number"},
+		{"bban length", func(p *api.Payment) { p.BeneficiaryParty.AccountNumber = "3192681" },
+			"/attributes/beneficiary_party/account_number"},
+		{"account number code", func(p *api.Payment) { p.BeneficiaryParty.AccountNumberCode = "SWIFT" },
+			"/attributes/beneficiary_party/account_number_code"},
+		{"bank id code", func(p *api.Payment) { p.SponsorParty.BankIDCode = "GBXYZ" },
+			"/attributes/sponsor_party/bank_id_code"},
+		{"sort code", func(p *api.Payment) { p.DebtorParty.BankID = "2033" }, "/attributes/debtor_party/bank_id"},
+		{"bearer code", func(p *api.Payment) { p.ChargesInformation.BearerCode = "OURS" },
+			"/attributes/charges_information/bearer_code"},
+		{"fx incomplete", func(p *api.Payment) { p.Fx.ExchangeRate = money.Decimal{} }, "/attributes/fx/exchange_rate"},
+		{"fx inconsistent", func(p *api.Payment) { p.Fx.OriginalAmount = money.MustParse("210.00") }, "/attributes/fx/original_amount"},
+	}
+
+	for _, tc := range tests {
+		payment := samplePayment(t)
+		tc.change(payment)
+
+		errs := payment.Validate()
+		found := false
+		for _, err := range errs {
+			found = found || err.Pointer == tc.pointer
+		}
+		if !found {
+			t.Errorf("%s: expected an error for %s but got: %v", tc.name, tc.pointer, errs)
+		}
+	}
+}
+
+// TestValidatorRules tests that a validator only applies the rules it is given.
+func TestValidatorRules(t *testing.T) {
+	payment := samplePayment(t)
+	payment.Currency = "ABC"
+	payment.ChargesInformation.BearerCode = "OURS"
+
+	errs := api.NewValidator(api.BearerCode).Validate(payment)
+	if len(errs) != 1 || errs[0].Pointer != "/attributes/charges_information/bearer_code" {
+		t.Fatalf("Expected only the bearer code error but got: %v", errs)
+	}
+}
+
+// TestDecodeRejectsBadAmount tests that amounts which are not decimal numbers are rejected when decoding.
+func TestDecodeRejectsBadAmount(t *testing.T) {
+	payment := &api.Payment{}
+	err := json.Unmarshal([]byte(`{"attributes": {"amount": "1,000.00"}}`), payment)
+	if err == nil {
+		t.Fatalf("Expected an error decoding the amount but got: %v", payment.Amount)
+	}
+}
+
+func samplePayment(t *testing.T) *api.Payment {
+	payment := &api.Payment{}
+	if err := json.NewDecoder(strings.NewReader(api_test.Payment)).Decode(payment); err != nil {
+		t.Fatalf("Failed to decode JSON: %v", err)
+	}
+
+	return payment
+}
@@ -0,0 +1,54 @@
+// Package approval keeps the approvals high value payments need from a second person before they can be submitted.
+package approval
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/cdempsie/payments-example/api"
+	"github.com/cdempsie/payments-example/money"
+)
+
+// Store keeps the latest approval of each payment. Implementations must be safe for concurrent use.
+type Store interface {
+	// Save records the approval, replacing any earlier approval of the payment.
+	Save(approval *api.Approval) error
+	// Load returns the approval of the payment, or nil if there is none.
+	Load(paymentID string) (*api.Approval, error)
+	// Delete removes the approval of the payment, if there is one.
+	Delete(paymentID string) error
+}
+
+// Thresholds maps currency codes to the amount above which a payment in the currency needs approval.
+// Payments in currencies without a threshold never need approval.
+type Thresholds map[string]money.Decimal
+
+// LoadThresholds reads the thresholds from the JSON file at the path, which maps currency codes to amounts sent as
+// strings, for example {"GBP": "10000.00", "JPY": "1500000"}.
+func LoadThresholds(path string) (Thresholds, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read approval thresholds %s: %v", path, err)
+	}
+	var thresholds Thresholds
+	if err := json.Unmarshal(data, &thresholds); err != nil {
+		return nil, fmt.Errorf("failed to decode approval thresholds %s: %v", path, err)
+	}
+	for currency, threshold := range thresholds {
+		if !money.ValidCurrency(currency) {
+			return nil, fmt.Errorf("approval thresholds %s: %s is not an ISO 4217 currency code", path, currency)
+		}
+		if threshold.Sign() < 0 {
+			return nil, fmt.Errorf("approval thresholds %s: the threshold for %s is negative", path, currency)
+		}
+	}
+
+	return thresholds, nil
+}
+
+// Requires reports whether the payment is above the threshold for its currency.
+func (thresholds Thresholds) Requires(payment *api.Payment) bool {
+	threshold, ok := thresholds[payment.Currency]
+	return ok && payment.Amount.Cmp(threshold) > 0
+}
@@ -0,0 +1,87 @@
+package approval_test
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/cdempsie/payments-example/api"
+	"github.com/cdempsie/payments-example/approval"
+	"github.com/cdempsie/payments-example/money"
+)
+
+func TestLoadThresholds(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "thresholds.json")
+	if err := ioutil.WriteFile(path, []byte(`{"GBP": "10000.00", "JPY": "1500000"}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	thresholds, err := approval.LoadThresholds(path)
+	if err != nil {
+		t.Fatalf("Failed to load thresholds: %v", err)
+	}
+
+	tests := []struct {
+		amount   string
+		currency string
+		requires bool
+	}{
+		{"10000.00", "GBP", false},
+		{"10000.01", "GBP", true},
+		{"1500001", "JPY", true},
+		{"99999999.00", "USD", false},
+	}
+	for _, tt := range tests {
+		payment := &api.Payment{Attributes: api.Attributes{Amount: money.MustParse(tt.amount), Currency: tt.currency}}
+		if requires := thresholds.Requires(payment); requires != tt.requires {
+			t.Errorf("Expected %s %s to need approval to be %v", tt.amount, tt.currency, tt.requires)
+		}
+	}
+
+	for name, content := range map[string]string{
+		"unknown currency": `{"XXY": "1.00"}`,
+		"negative":         `{"GBP": "-1.00"}`,
+		"number":           `{"GBP": 100}`,
+	} {
+		invalid := filepath.Join(dir, "invalid.json")
+		if err := ioutil.WriteFile(invalid, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := approval.LoadThresholds(invalid); err == nil {
+			t.Errorf("Expected %s threshold to be rejected", name)
+		}
+	}
+}
+
+func TestFileStoreSurvivesRestart(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "approvals.json")
+	store, err := approval.NewFileStore(path)
+	if err != nil {
+		t.Fatalf("Failed to open store: %v", err)
+	}
+	for _, paymentID := range []string{"kept", "removed"} {
+		if err := store.Save(&api.Approval{PaymentID: paymentID, State: api.ApprovalPending, Currency: "GBP",
+			Amount: money.MustParse("20000.00"), CreatedBy: "alice", RequestedAt: time.Now()}); err != nil {
+			t.Fatalf("Failed to save approval: %v", err)
+		}
+	}
+	if err := store.Delete("removed"); err != nil {
+		t.Fatalf("Failed to delete approval: %v", err)
+	}
+
+	reopened, err := approval.NewFileStore(path)
+	if err != nil {
+		t.Fatalf("Failed to reopen store: %v", err)
+	}
+	kept, err := reopened.Load("kept")
+	if err != nil || kept == nil {
+		t.Fatalf("Expected approval to survive a restart but got: %+v, %v", kept, err)
+	}
+	if kept.State != api.ApprovalPending || kept.CreatedBy != "alice" || kept.Amount.String() != "20000.00" {
+		t.Errorf("Approval changed across a restart: %+v", kept)
+	}
+	if removed, err := reopened.Load("removed"); err != nil || removed != nil {
+		t.Errorf("Expected deleted approval to stay deleted but got: %+v, %v", removed, err)
+	}
+}
@@ -0,0 +1,143 @@
+package approval
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/cdempsie/payments-example/api"
+)
+
+// InMemoryStore keeps approvals in memory, they won't survive server restarts!
+type InMemoryStore struct {
+	lock      sync.RWMutex
+	approvals map[string]api.Approval
+}
+
+// NewInMemoryStore returns an empty in memory store.
+func NewInMemoryStore() *InMemoryStore {
+	return &InMemoryStore{approvals: make(map[string]api.Approval)}
+}
+
+// Save records the approval, replacing any earlier approval of the payment.
+func (store *InMemoryStore) Save(approval *api.Approval) error {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+
+	store.approvals[approval.PaymentID] = *approval
+	return nil
+}
+
+// Load returns a copy of the approval of the payment, or nil if there is none.
+func (store *InMemoryStore) Load(paymentID string) (*api.Approval, error) {
+	store.lock.RLock()
+	defer store.lock.RUnlock()
+
+	approval, ok := store.approvals[paymentID]
+	if !ok {
+		return nil, nil
+	}
+
+	return &approval, nil
+}
+
+// Delete removes the approval of the payment, if there is one.
+func (store *InMemoryStore) Delete(paymentID string) error {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+
+	delete(store.approvals, paymentID)
+	return nil
+}
+
+// FileStore keeps approvals in a JSON file as well as in memory. The whole file is rewritten on every change, by
+// writing a new file and renaming it over the old one, so it is never left half written.
+type FileStore struct {
+	InMemoryStore
+	path string
+}
+
+// NewFileStore opens the approvals file at the path, starting empty if there is no file yet.
+func NewFileStore(path string) (*FileStore, error) {
+	store := &FileStore{InMemoryStore: InMemoryStore{approvals: make(map[string]api.Approval)}, path: path}
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return store, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read approvals %s: %v", path, err)
+	}
+	if err := json.Unmarshal(data, &store.approvals); err != nil {
+		return nil, fmt.Errorf("failed to decode approvals %s: %v", path, err)
+	}
+
+	return store, nil
+}
+
+// Save records the approval and writes the file.
+func (store *FileStore) Save(approval *api.Approval) error {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+
+	previous, existed := store.approvals[approval.PaymentID]
+	store.approvals[approval.PaymentID] = *approval
+	if err := store.write(); err != nil {
+		if existed {
+			store.approvals[approval.PaymentID] = previous
+		} else {
+			delete(store.approvals, approval.PaymentID)
+		}
+		return err
+	}
+
+	return nil
+}
+
+// Delete removes the approval of the payment and writes the file.
+func (store *FileStore) Delete(paymentID string) error {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+
+	previous, existed := store.approvals[paymentID]
+	if !existed {
+		return nil
+	}
+	delete(store.approvals, paymentID)
+	if err := store.write(); err != nil {
+		store.approvals[paymentID] = previous
+		return err
+	}
+
+	return nil
+}
+
+// write replaces the file with the approvals held in memory. The lock must be held.
+func (store *FileStore) write() error {
+	data, err := json.Marshal(store.approvals)
+	if err != nil {
+		return fmt.Errorf("failed to encode approvals: %v", err)
+	}
+
+	temp, err := ioutil.TempFile(filepath.Dir(store.path), filepath.Base(store.path)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to write approvals %s: %v", store.path, err)
+	}
+	defer os.Remove(temp.Name())
+	if _, err = temp.Write(data); err == nil {
+		err = temp.Sync()
+	}
+	if closeErr := temp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(temp.Name(), store.path)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write approvals %s: %v", store.path, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,127 @@
+// Package audit records an append-only trail of the changes made to payments.
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/cdempsie/payments-example/api"
+)
+
+// Log is an append-only record of audit events. Implementations must be safe for concurrent use.
+type Log interface {
+	// Append records the event, assigning its sequence number. Recorded events are never changed or removed.
+	// Appending is not abandoned when the context is done as the change the event records has already been made.
+	Append(ctx context.Context, event *api.AuditEvent) error
+	// Events returns the events recorded for the payment in the order they were appended. An error wrapping the
+	// context's error is returned if it is done.
+	Events(ctx context.Context, paymentID string) ([]api.AuditEvent, error)
+}
+
+type contextKey int
+
+const (
+	actorKey contextKey = iota
+	requestIDKey
+)
+
+// WithActor returns a context recording who is making the changes.
+func WithActor(ctx context.Context, actor string) context.Context {
+	return context.WithValue(ctx, actorKey, actor)
+}
+
+// Actor returns who is making the changes, or an empty string if the context does not say.
+func Actor(ctx context.Context) string {
+	actor, _ := ctx.Value(actorKey).(string)
+	return actor
+}
+
+// WithRequestID returns a context recording the ID of the request the changes are made for.
+func WithRequestID(ctx context.Context, requestID string) context.Context {
+	return context.WithValue(ctx, requestIDKey, requestID)
+}
+
+// RequestID returns the ID of the request the changes are made for, or an empty string if the context does not say.
+func RequestID(ctx context.Context) string {
+	requestID, _ := ctx.Value(requestIDKey).(string)
+	return requestID
+}
+
+// Diff returns the fields that differ between two versions of a payment, either of which may be nil.
+// Objects are compared field by field and arrays element by element when they are the same length, otherwise the
+// whole array is reported as changed.
+func Diff(before, after *api.Payment) ([]api.FieldChange, error) {
+	beforeTree, err := tree(before)
+	if err != nil {
+		return nil, err
+	}
+	afterTree, err := tree(after)
+	if err != nil {
+		return nil, err
+	}
+
+	changes := []api.FieldChange{}
+	diff("", beforeTree, afterTree, &changes)
+
+	return changes, nil
+}
+
+func diff(pointer string, before, after interface{}, changes *[]api.FieldChange) {
+	beforeObject, beforeIsObject := before.(map[string]interface{})
+	afterObject, afterIsObject := after.(map[string]interface{})
+	if beforeIsObject && afterIsObject {
+		names := make([]string, 0, len(beforeObject)+len(afterObject))
+		for name := range beforeObject {
+			names = append(names, name)
+		}
+		for name := range afterObject {
+			if _, ok := beforeObject[name]; !ok {
+				names = append(names, name)
+			}
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			diff(pointer+"/"+escape(name), beforeObject[name], afterObject[name], changes)
+		}
+		return
+	}
+
+	beforeArray, beforeIsArray := before.([]interface{})
+	afterArray, afterIsArray := after.([]interface{})
+	if beforeIsArray && afterIsArray && len(beforeArray) == len(afterArray) {
+		for i := range beforeArray {
+			diff(fmt.Sprintf("%s/%d", pointer, i), beforeArray[i], afterArray[i], changes)
+		}
+		return
+	}
+
+	if !reflect.DeepEqual(before, after) {
+		*changes = append(*changes, api.FieldChange{Pointer: pointer, From: before, To: after})
+	}
+}
+
+// tree returns the payment as generic JSON values, a missing payment is an empty object so every field is listed.
+func tree(payment *api.Payment) (interface{}, error) {
+	if payment == nil {
+		return map[string]interface{}{}, nil
+	}
+
+	data, err := json.Marshal(payment)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode payment with ID: %s: %v", payment.ID, err)
+	}
+	var value interface{}
+	if err := json.Unmarshal(data, &value); err != nil {
+		return nil, fmt.Errorf("failed to decode payment with ID: %s: %v", payment.ID, err)
+	}
+
+	return value, nil
+}
+
+func escape(name string) string {
+	return strings.ReplaceAll(strings.ReplaceAll(name, "~", "~0"), "/", "~1")
+}
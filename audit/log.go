@@ -0,0 +1,165 @@
+package audit
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+
+	"github.com/cdempsie/payments-example/api"
+	"github.com/cdempsie/payments-example/logging"
+)
+
+// InMemoryLog keeps the audit trail in memory, it won't survive server restarts!
+// Events are held encoded so that nothing outside the log can change them once appended.
+type InMemoryLog struct {
+	lock      sync.RWMutex
+	sequence  int
+	byPayment map[string][][]byte
+}
+
+// NewInMemoryLog returns an empty in memory log.
+func NewInMemoryLog() *InMemoryLog {
+	return &InMemoryLog{byPayment: make(map[string][][]byte)}
+}
+
+// Append records the event, assigning its sequence number.
+func (auditLog *InMemoryLog) Append(ctx context.Context, event *api.AuditEvent) error {
+	auditLog.lock.Lock()
+	defer auditLog.lock.Unlock()
+
+	_, err := auditLog.append(event)
+	return err
+}
+
+// append assigns the event its sequence number and records it, returning its encoding. The lock must be held.
+func (auditLog *InMemoryLog) append(event *api.AuditEvent) ([]byte, error) {
+	event.Sequence = auditLog.sequence + 1
+	data, err := json.Marshal(event)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode audit event for payment with ID: %s: %v", event.PaymentID, err)
+	}
+
+	auditLog.sequence = event.Sequence
+	auditLog.byPayment[event.PaymentID] = append(auditLog.byPayment[event.PaymentID], data)
+
+	return data, nil
+}
+
+// Events returns the events recorded for the payment in the order they were appended.
+func (auditLog *InMemoryLog) Events(ctx context.Context, paymentID string) ([]api.AuditEvent, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read audit events for payment with ID: %s: %w", paymentID, err)
+	}
+
+	auditLog.lock.RLock()
+	defer auditLog.lock.RUnlock()
+
+	events := make([]api.AuditEvent, 0, len(auditLog.byPayment[paymentID]))
+	for _, data := range auditLog.byPayment[paymentID] {
+		var event api.AuditEvent
+		if err := json.Unmarshal(data, &event); err != nil {
+			return nil, fmt.Errorf("failed to decode audit event for payment with ID: %s: %v", paymentID, err)
+		}
+		events = append(events, event)
+	}
+
+	return events, nil
+}
+
+// FileLog keeps the audit trail in a file of JSON lines, one per event, that is only ever appended to.
+// Every event is synced to disk before Append returns. The whole trail is also held in memory to answer Events.
+type FileLog struct {
+	InMemoryLog
+	file *os.File
+}
+
+// NewFileLog opens, creating if needed, the audit log file at the path and reads the events already recorded.
+// A final event that was only partly written when the server stopped is dropped and logged with the logger carried by
+// the context.
+func NewFileLog(ctx context.Context, path string) (*FileLog, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open audit log %s: %v", path, err)
+	}
+
+	auditLog := &FileLog{InMemoryLog: InMemoryLog{byPayment: make(map[string][][]byte)}, file: file}
+	if err := auditLog.replay(ctx); err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	return auditLog, nil
+}
+
+// replay reads the recorded events and leaves the file positioned to append after the last complete one.
+func (auditLog *FileLog) replay(ctx context.Context) error {
+	reader := bufio.NewReader(auditLog.file)
+	var offset int64
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err == io.EOF {
+			if len(line) > 0 {
+				logging.FromContext(ctx).Warn("Dropping partly written event at the end of audit log",
+					"path", auditLog.file.Name(), "offset", offset, "bytes", len(line))
+			}
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read audit log %s: %v", auditLog.file.Name(), err)
+		}
+
+		var event api.AuditEvent
+		if err := json.Unmarshal(line, &event); err != nil {
+			return fmt.Errorf("failed to decode audit log %s at offset %d: %v", auditLog.file.Name(), offset, err)
+		}
+		auditLog.sequence = event.Sequence
+		data := line[:len(line)-1]
+		auditLog.byPayment[event.PaymentID] = append(auditLog.byPayment[event.PaymentID], data)
+		offset += int64(len(line))
+	}
+
+	if err := auditLog.file.Truncate(offset); err != nil {
+		return fmt.Errorf("failed to truncate audit log %s: %v", auditLog.file.Name(), err)
+	}
+	_, err := auditLog.file.Seek(offset, io.SeekStart)
+
+	return err
+}
+
+// Append records the event, assigning its sequence number, and syncs it to disk.
+func (auditLog *FileLog) Append(ctx context.Context, event *api.AuditEvent) error {
+	auditLog.lock.Lock()
+	defer auditLog.lock.Unlock()
+
+	offset, err := auditLog.file.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return fmt.Errorf("failed to find the end of audit log %s: %v", auditLog.file.Name(), err)
+	}
+	sequence, byPayment := auditLog.sequence, auditLog.byPayment[event.PaymentID]
+	data, err := auditLog.append(event)
+	if err != nil {
+		return err
+	}
+	if _, err = auditLog.file.Write(append(data, '\n')); err == nil {
+		err = auditLog.file.Sync()
+	}
+	if err != nil {
+		// forget the event, and anything partly written, so memory matches what is on disk
+		auditLog.sequence, auditLog.byPayment[event.PaymentID] = sequence, byPayment
+		if truncateErr := auditLog.file.Truncate(offset); truncateErr == nil {
+			auditLog.file.Seek(offset, io.SeekStart)
+		}
+		return fmt.Errorf("failed to write audit event for payment with ID: %s: %v", event.PaymentID, err)
+	}
+
+	return nil
+}
+
+// Close closes the audit log file.
+func (auditLog *FileLog) Close() error {
+	return auditLog.file.Close()
+}
@@ -0,0 +1,112 @@
+package audit_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/cdempsie/payments-example/api"
+	"github.com/cdempsie/payments-example/audit"
+	"github.com/cdempsie/payments-example/logging"
+)
+
+// TestFileLog tests events survive reopening the log and a partly written event is dropped.
+func TestFileLog(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	auditLog, err := audit.NewFileLog(context.Background(), path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, paymentID := range []string{"a", "b", "a"} {
+		event := &api.AuditEvent{PaymentID: paymentID, Action: api.ActionUpdated, At: time.Now().UTC()}
+		if err := auditLog.Append(context.Background(), event); err != nil {
+			t.Fatalf("Failed to append event: %v", err)
+		}
+	}
+	auditLog.Close()
+
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	partial := `{"sequence": 4, "payment_id": "a"`
+	file.WriteString(partial)
+	file.Close()
+
+	var logged bytes.Buffer
+	auditLog, err = audit.NewFileLog(logging.WithLogger(context.Background(), logging.NewJSON(&logged)), path)
+	if err != nil {
+		t.Fatalf("Failed to reopen audit log: %v", err)
+	}
+	defer auditLog.Close()
+	var warning struct {
+		Path   string `json:"path"`
+		Offset int64  `json:"offset"`
+		Bytes  int    `json:"bytes"`
+	}
+	if err := json.Unmarshal(logged.Bytes(), &warning); err != nil || warning.Path != path || warning.Offset == 0 ||
+		warning.Bytes != len(partial) {
+		t.Errorf("Expected the dropped event to be logged with its path, offset and size but got: %s", &logged)
+	}
+	events, err := auditLog.Events(context.Background(), "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 2 || events[0].Sequence != 1 || events[1].Sequence != 3 {
+		t.Fatalf("Expected events 1 and 3 but got: %+v", events)
+	}
+
+	event := &api.AuditEvent{PaymentID: "b"}
+	if err := auditLog.Append(context.Background(), event); err != nil {
+		t.Fatal(err)
+	}
+	if event.Sequence != 4 {
+		t.Fatalf("Expected the sequence to carry on from 4 but got: %d", event.Sequence)
+	}
+}
+
+// TestEventsCancelled tests reading events gives up once the context is done.
+func TestEventsCancelled(t *testing.T) {
+	auditLog := audit.NewInMemoryLog()
+	if err := auditLog.Append(context.Background(), &api.AuditEvent{PaymentID: "a"}); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := auditLog.Events(ctx, "a"); !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected reading events to be cancelled but got: %v", err)
+	}
+}
+
+// TestDiff tests only the fields that changed are reported.
+func TestDiff(t *testing.T) {
+	before := &api.Payment{ID: "a"}
+	before.Reference = "old"
+	before.ChargesInformation.SenderCharges = []api.SenderCharge{{Currency: "GBP"}}
+	after := *before
+	after.Reference = "new"
+	after.ChargesInformation.SenderCharges = []api.SenderCharge{{Currency: "USD"}}
+
+	changes, err := audit.Diff(before, &after)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []api.FieldChange{
+		{Pointer: "/attributes/charges_information/sender_charges/0/currency", From: "GBP", To: "USD"},
+		{Pointer: "/attributes/reference", From: "old", To: "new"},
+	}
+	if len(changes) != len(expected) {
+		t.Fatalf("Expected %+v but got: %+v", expected, changes)
+	}
+	for i := range expected {
+		if changes[i] != expected[i] {
+			t.Errorf("Expected %+v but got: %+v", expected[i], changes[i])
+		}
+	}
+}
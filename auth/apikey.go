@@ -0,0 +1,93 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+)
+
+// APIKeyHeader carries the API key of the caller.
+const APIKeyHeader = "X-API-Key"
+
+// APIKey describes a caller that authenticates with a static API key. Only the SHA-256 hash of the key is kept so
+// the file the keys are loaded from does not give the keys away.
+type APIKey struct {
+	// KeySHA256 is the hex encoded SHA-256 hash of the key.
+	KeySHA256      string   `json:"key_sha256"`
+	Subject        string   `json:"subject"`
+	OrganisationID string   `json:"organisation_id,omitempty"`
+	Admin          bool     `json:"admin,omitempty"`
+	Roles          []string `json:"roles"`
+}
+
+// APIKeys authenticates callers by the API key in the X-API-Key header.
+type APIKeys struct {
+	byHash map[[sha256.Size]byte]Principal
+}
+
+// LoadAPIKeys reads the API keys from the JSON file at the path, which holds an array of APIKey.
+func LoadAPIKeys(path string) (*APIKeys, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read API keys %s: %v", path, err)
+	}
+	var keys []APIKey
+	if err := json.Unmarshal(data, &keys); err != nil {
+		return nil, fmt.Errorf("failed to decode API keys %s: %v", path, err)
+	}
+
+	apiKeys, err := NewAPIKeys(keys)
+	if err != nil {
+		return nil, fmt.Errorf("invalid API keys %s: %v", path, err)
+	}
+
+	return apiKeys, nil
+}
+
+// NewAPIKeys returns an authenticator accepting the keys. Every key must have a subject and belong to an
+// organisation unless it is an admin key.
+func NewAPIKeys(keys []APIKey) (*APIKeys, error) {
+	apiKeys := &APIKeys{byHash: make(map[[sha256.Size]byte]Principal, len(keys))}
+	for i, key := range keys {
+		decoded, err := hex.DecodeString(key.KeySHA256)
+		if err != nil || len(decoded) != sha256.Size {
+			return nil, fmt.Errorf("key %d: key_sha256 must be a hex encoded SHA-256 hash", i)
+		}
+		if key.Subject == "" {
+			return nil, fmt.Errorf("key %d: subject is missing", i)
+		}
+		if key.OrganisationID == "" && !key.Admin {
+			return nil, fmt.Errorf("key %d: organisation_id is missing for a key that is not an admin key", i)
+		}
+
+		var hash [sha256.Size]byte
+		copy(hash[:], decoded)
+		if _, ok := apiKeys.byHash[hash]; ok {
+			return nil, fmt.Errorf("key %d: the key is listed more than once", i)
+		}
+		apiKeys.byHash[hash] = Principal{Subject: key.Subject, OrganisationID: key.OrganisationID, Admin: key.Admin,
+			Roles: key.Roles}
+	}
+
+	return apiKeys, nil
+}
+
+// Authenticate returns the caller the API key in the request belongs to.
+func (apiKeys *APIKeys) Authenticate(request *http.Request) (Principal, error) {
+	key := strings.TrimSpace(request.Header.Get(APIKeyHeader))
+	if key == "" {
+		return Principal{}, fmt.Errorf("no %s header: %w", APIKeyHeader, ErrNoCredentials)
+	}
+
+	// only the hash is looked up so how long it takes says nothing about the keys
+	principal, ok := apiKeys.byHash[sha256.Sum256([]byte(key))]
+	if !ok {
+		return Principal{}, fmt.Errorf("unknown API key: %w", ErrInvalidCredentials)
+	}
+
+	return principal, nil
+}
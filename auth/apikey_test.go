@@ -0,0 +1,106 @@
+package auth_test
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/cdempsie/payments-example/auth"
+)
+
+func TestAPIKeys(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keys.json")
+	keys := `[
+		{"key_sha256": "` + hash("org-key") + `", "subject": "alice", "organisation_id": "org-1", "roles": ["viewer"]},
+		{"key_sha256": "` + hash("admin-key") + `", "subject": "ops", "admin": true}
+	]`
+	if err := ioutil.WriteFile(path, []byte(keys), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	apiKeys, err := auth.LoadAPIKeys(path)
+	if err != nil {
+		t.Fatalf("Failed to load API keys: %v", err)
+	}
+
+	tests := []struct {
+		name      string
+		key       string
+		principal auth.Principal
+		err       error
+	}{
+		{"organisation key", "org-key",
+			auth.Principal{Subject: "alice", OrganisationID: "org-1", Roles: []string{"viewer"}}, nil},
+		{"admin key", "admin-key", auth.Principal{Subject: "ops", Admin: true}, nil},
+		{"unknown key", "guess", auth.Principal{}, auth.ErrInvalidCredentials},
+		{"no key", "", auth.Principal{}, auth.ErrNoCredentials},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			request, _ := http.NewRequest(http.MethodGet, "/", nil)
+			if tt.key != "" {
+				request.Header.Set("X-API-Key", tt.key)
+			}
+
+			principal, err := apiKeys.Authenticate(request)
+			if !errors.Is(err, tt.err) || (tt.err == nil && err != nil) {
+				t.Fatalf("Expected error %v but got: %v", tt.err, err)
+			}
+			if !reflect.DeepEqual(principal, tt.principal) {
+				t.Errorf("Expected principal %+v but got: %+v", tt.principal, principal)
+			}
+		})
+	}
+}
+
+func TestNewAPIKeysInvalid(t *testing.T) {
+	keys := map[string][]auth.APIKey{
+		"bad hash":        {{KeySHA256: "abc", Subject: "alice", OrganisationID: "org-1"}},
+		"no subject":      {{KeySHA256: hash("key"), OrganisationID: "org-1"}},
+		"no organisation": {{KeySHA256: hash("key"), Subject: "alice"}},
+		"duplicate": {
+			{KeySHA256: hash("key"), Subject: "alice", OrganisationID: "org-1"},
+			{KeySHA256: hash("key"), Subject: "bob", OrganisationID: "org-2"},
+		},
+	}
+	for name, keys := range keys {
+		if _, err := auth.NewAPIKeys(keys); err == nil {
+			t.Errorf("Expected %s to be rejected", name)
+		}
+	}
+}
+
+func TestChain(t *testing.T) {
+	apiKeys, err := auth.NewAPIKeys([]auth.APIKey{{KeySHA256: hash("key"), Subject: "alice", Admin: true}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	chain := auth.Chain{apiKeys, auth.Headers{}}
+
+	request, _ := http.NewRequest(http.MethodGet, "/", nil)
+	request.Header.Set("X-Actor", "mallory")
+	if principal, err := chain.Authenticate(request); err != nil || principal.Subject != "mallory" {
+		t.Errorf("Expected the headers to be used without a key but got: %+v, %v", principal, err)
+	}
+	request.Header.Set("X-API-Key", "key")
+	if principal, err := chain.Authenticate(request); err != nil || principal.Subject != "alice" {
+		t.Errorf("Expected the key to be used but got: %+v, %v", principal, err)
+	}
+	request.Header.Set("X-API-Key", "wrong")
+	if _, err := chain.Authenticate(request); !errors.Is(err, auth.ErrInvalidCredentials) {
+		t.Errorf("Expected a wrong key to be rejected but got: %v", err)
+	}
+	if _, err := (auth.Chain{}).Authenticate(request); !errors.Is(err, auth.ErrNoCredentials) {
+		t.Errorf("Expected an empty chain to find no credentials but got: %v", err)
+	}
+}
+
+// hash returns the hex encoded SHA-256 hash of the key as kept in an API keys file.
+func hash(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
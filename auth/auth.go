@@ -0,0 +1,71 @@
+// Package auth identifies who is making a request and which payments they may reach.
+package auth
+
+import (
+	"context"
+	"errors"
+	"net/http"
+)
+
+// Errors returned by authenticators. They are wrapped with detail so callers should test for them with errors.Is.
+var (
+	// ErrNoCredentials is returned when the request carries no credentials the authenticator understands.
+	ErrNoCredentials = errors.New("no credentials")
+	// ErrInvalidCredentials is returned when the request carries credentials that are unknown, expired or forged.
+	ErrInvalidCredentials = errors.New("invalid credentials")
+)
+
+// Principal is the authenticated caller of the API.
+type Principal struct {
+	// Subject identifies the caller, it is recorded as the actor of the changes they make.
+	Subject string
+	// OrganisationID is the organisation the caller belongs to, they can only reach its payments.
+	OrganisationID string
+	// Admin callers can reach the payments of every organisation.
+	Admin bool
+	// Roles decide what the caller can do to the payments they can reach, see Policy.
+	Roles []string
+}
+
+// CanAccess reports whether the principal can reach the payments of the organisation.
+func (principal Principal) CanAccess(organisationID string) bool {
+	return principal.Admin || (principal.OrganisationID != "" && principal.OrganisationID == organisationID)
+}
+
+// Authenticator identifies the caller of a request from the credentials it carries.
+// Implementations must be safe for concurrent use.
+type Authenticator interface {
+	// Authenticate returns the caller of the request. An error wrapping ErrNoCredentials is returned if the request
+	// has no credentials for the authenticator and one wrapping ErrInvalidCredentials if they are not valid.
+	Authenticate(request *http.Request) (Principal, error)
+}
+
+// Chain tries each authenticator in turn, the first to find credentials in the request decides who the caller is.
+type Chain []Authenticator
+
+// Authenticate returns the caller identified by the first authenticator that finds credentials in the request.
+func (chain Chain) Authenticate(request *http.Request) (Principal, error) {
+	for _, authenticator := range chain {
+		principal, err := authenticator.Authenticate(request)
+		if !errors.Is(err, ErrNoCredentials) {
+			return principal, err
+		}
+	}
+
+	return Principal{}, ErrNoCredentials
+}
+
+type contextKey int
+
+const principalKey contextKey = iota
+
+// WithPrincipal returns a context recording the principal making the request.
+func WithPrincipal(ctx context.Context, principal Principal) context.Context {
+	return context.WithValue(ctx, principalKey, principal)
+}
+
+// PrincipalFrom returns the principal making the request and whether the context records one.
+func PrincipalFrom(ctx context.Context) (Principal, bool) {
+	principal, ok := ctx.Value(principalKey).(Principal)
+	return principal, ok
+}
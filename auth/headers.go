@@ -0,0 +1,40 @@
+package auth
+
+import (
+	"net/http"
+	"strings"
+)
+
+// The headers a trusted gateway uses to name the caller.
+const (
+	// ActorHeader names who is making a request.
+	ActorHeader = "X-Actor"
+	// OrganisationHeader names the organisation of the caller.
+	OrganisationHeader = "X-Organisation-ID"
+	// RolesHeader lists the roles of the caller separated by commas.
+	RolesHeader = "X-Roles"
+	// AdminHeader is true for admin callers, who can reach the payments of every organisation.
+	AdminHeader = "X-Admin"
+)
+
+// Headers trusts the X-Actor, X-Organisation-ID, X-Roles and X-Admin headers to name the caller. It is only safe behind
+// a gateway that authenticates callers and sets the headers itself, so it is never used unless asked for. Every
+// request is accepted, a caller is only an admin when X-Admin is true and one without an organisation or roles can
+// reach no payments.
+type Headers struct{}
+
+// Authenticate returns the caller named by the request headers.
+func (Headers) Authenticate(request *http.Request) (Principal, error) {
+	principal := Principal{
+		Subject:        strings.TrimSpace(request.Header.Get(ActorHeader)),
+		OrganisationID: strings.TrimSpace(request.Header.Get(OrganisationHeader)),
+	}
+	principal.Admin = strings.EqualFold(strings.TrimSpace(request.Header.Get(AdminHeader)), "true")
+	for _, role := range strings.Split(request.Header.Get(RolesHeader), ",") {
+		if role = strings.TrimSpace(role); role != "" {
+			principal.Roles = append(principal.Roles, role)
+		}
+	}
+
+	return principal, nil
+}
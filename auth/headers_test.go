@@ -0,0 +1,43 @@
+package auth_test
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+
+	"github.com/cdempsie/payments-example/auth"
+)
+
+func TestHeaders(t *testing.T) {
+	tests := []struct {
+		name     string
+		headers  map[string]string
+		expected auth.Principal
+	}{
+		{"organisation", map[string]string{"X-Actor": "alice", "X-Organisation-ID": "org", "X-Roles": "viewer, approver"},
+			auth.Principal{Subject: "alice", OrganisationID: "org", Roles: []string{"viewer", "approver"}}},
+		{"no organisation is not an admin", map[string]string{"X-Actor": "mallory", "X-Roles": "admin"},
+			auth.Principal{Subject: "mallory", Roles: []string{"admin"}}},
+		{"admin", map[string]string{"X-Actor": "ops", "X-Admin": "true"},
+			auth.Principal{Subject: "ops", Admin: true}},
+		{"admin not true", map[string]string{"X-Actor": "ops", "X-Admin": "yes"}, auth.Principal{Subject: "ops"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			request, _ := http.NewRequest(http.MethodGet, "/", nil)
+			for name, value := range tt.headers {
+				request.Header.Set(name, value)
+			}
+			principal, err := auth.Headers{}.Authenticate(request)
+			if err != nil {
+				t.Fatalf("Expected the headers to be trusted but got: %v", err)
+			}
+			if !reflect.DeepEqual(principal, tt.expected) {
+				t.Errorf("Expected %+v but got %+v", tt.expected, principal)
+			}
+			if !principal.Admin && principal.CanAccess("other") {
+				t.Errorf("Expected %+v not to reach another organisation", principal)
+			}
+		})
+	}
+}
@@ -0,0 +1,299 @@
+package auth
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/hmac"
+	"crypto/rsa"
+	_ "crypto/sha256" // registers SHA-256 for RS256
+	_ "crypto/sha512" // registers SHA-384 and SHA-512 for RS384 and RS512
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// clockSkew is how far the clocks of the token issuer and the server are allowed to differ.
+const clockSkew = time.Minute
+
+// algorithms maps the JWS algorithms accepted to the key type they need and the hash they sign.
+var algorithms = map[string]struct {
+	keyType string
+	hash    crypto.Hash
+}{
+	"HS256": {"oct", crypto.SHA256},
+	"HS384": {"oct", crypto.SHA384},
+	"HS512": {"oct", crypto.SHA512},
+	"RS256": {"RSA", crypto.SHA256},
+	"RS384": {"RSA", crypto.SHA384},
+	"RS512": {"RSA", crypto.SHA512},
+}
+
+// JWTVerifier authenticates callers by the JSON web token (RFC 7519) sent as a bearer token in the Authorization
+// header. Tokens must be signed with HMAC (HS256, HS384 or HS512) or RSA (RS256, RS384 or RS512) by one of the keys
+// of a JSON web key set (RFC 7517), have an expiry and, when the verifier is given them, the expected issuer and
+// audience.
+//
+// The caller is the sub claim of the token, their organisation the organisation_id claim, their roles the roles claim
+// and the admin claim, when true, makes them an admin.
+type JWTVerifier struct {
+	keys     []jsonWebKey
+	issuer   string
+	audience string
+}
+
+// jsonWebKey is a key of a JSON web key set, decoded ready for use.
+type jsonWebKey struct {
+	id        string
+	keyType   string
+	algorithm string
+	secret    []byte
+	publicKey *rsa.PublicKey
+}
+
+// LoadJWTVerifier returns a verifier accepting tokens signed by the keys in the JSON web key set file at the path.
+// When the issuer or audience are not empty tokens must have a matching iss or aud claim.
+func LoadJWTVerifier(path, issuer, audience string) (*JWTVerifier, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read JSON web key set %s: %v", path, err)
+	}
+
+	verifier, err := NewJWTVerifier(data, issuer, audience)
+	if err != nil {
+		return nil, fmt.Errorf("invalid JSON web key set %s: %v", path, err)
+	}
+
+	return verifier, nil
+}
+
+// NewJWTVerifier returns a verifier accepting tokens signed by the keys in the encoded JSON web key set.
+// Only oct and RSA keys are used, keys meant for encryption are ignored.
+func NewJWTVerifier(keySet []byte, issuer, audience string) (*JWTVerifier, error) {
+	var set struct {
+		Keys []struct {
+			KeyID     string `json:"kid"`
+			KeyType   string `json:"kty"`
+			Algorithm string `json:"alg"`
+			Use       string `json:"use"`
+			K         string `json:"k"`
+			N         string `json:"n"`
+			E         string `json:"e"`
+		} `json:"keys"`
+	}
+	if err := json.Unmarshal(keySet, &set); err != nil {
+		return nil, fmt.Errorf("failed to decode key set: %v", err)
+	}
+
+	verifier := &JWTVerifier{issuer: issuer, audience: audience}
+	for i, key := range set.Keys {
+		if key.Use == "enc" {
+			continue
+		}
+		if key.Algorithm != "" && algorithms[key.Algorithm].keyType != key.KeyType {
+			return nil, fmt.Errorf("key %d: algorithm %s can not be used with a %s key", i, key.Algorithm,
+				key.KeyType)
+		}
+
+		decoded := jsonWebKey{id: key.KeyID, keyType: key.KeyType, algorithm: key.Algorithm}
+		var err error
+		switch key.KeyType {
+		case "oct":
+			decoded.secret, err = base64.RawURLEncoding.DecodeString(key.K)
+			if err == nil && len(decoded.secret) == 0 {
+				err = fmt.Errorf("secret is missing")
+			}
+		case "RSA":
+			decoded.publicKey, err = rsaPublicKey(key.N, key.E)
+		default:
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("key %d: %v", i, err)
+		}
+		verifier.keys = append(verifier.keys, decoded)
+	}
+	if len(verifier.keys) == 0 {
+		return nil, fmt.Errorf("there are no oct or RSA signing keys")
+	}
+
+	return verifier, nil
+}
+
+// rsaPublicKey returns the RSA public key with the base64url encoded modulus and exponent.
+func rsaPublicKey(modulus, exponent string) (*rsa.PublicKey, error) {
+	n, err := base64.RawURLEncoding.DecodeString(modulus)
+	if err != nil || len(n) == 0 {
+		return nil, fmt.Errorf("modulus n is not base64url encoded")
+	}
+	e, err := base64.RawURLEncoding.DecodeString(exponent)
+	if err != nil || len(e) == 0 || len(e) > 4 {
+		return nil, fmt.Errorf("exponent e is not a base64url encoded number of at most 4 bytes")
+	}
+
+	publicKey := &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+	if publicKey.N.BitLen() < 2048 {
+		return nil, fmt.Errorf("RSA keys must be at least 2048 bits")
+	}
+
+	return publicKey, nil
+}
+
+// claims are the JWT claims read by the verifier.
+type claims struct {
+	Subject        string   `json:"sub"`
+	Issuer         string   `json:"iss"`
+	Audience       audience `json:"aud"`
+	ExpiresAt      *float64 `json:"exp"`
+	NotBefore      *float64 `json:"nbf"`
+	OrganisationID string   `json:"organisation_id"`
+	Admin          bool     `json:"admin"`
+	Roles          []string `json:"roles"`
+}
+
+// audience is the aud claim, which may be a single string or an array of them.
+type audience []string
+
+func (aud *audience) UnmarshalJSON(data []byte) error {
+	if bytes.HasPrefix(bytes.TrimSpace(data), []byte("[")) {
+		return json.Unmarshal(data, (*[]string)(aud))
+	}
+	var single string
+	if err := json.Unmarshal(data, &single); err != nil {
+		return err
+	}
+	*aud = audience{single}
+
+	return nil
+}
+
+func (aud audience) contains(value string) bool {
+	for _, each := range aud {
+		if each == value {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Authenticate returns the caller named by the bearer token in the request.
+func (verifier *JWTVerifier) Authenticate(request *http.Request) (Principal, error) {
+	authorization := strings.SplitN(request.Header.Get("Authorization"), " ", 2)
+	if len(authorization) != 2 || !strings.EqualFold(authorization[0], "Bearer") {
+		return Principal{}, fmt.Errorf("no bearer token: %w", ErrNoCredentials)
+	}
+
+	claims, err := verifier.verify(strings.TrimSpace(authorization[1]))
+	if err != nil {
+		return Principal{}, fmt.Errorf("bearer token rejected: %v: %w", err, ErrInvalidCredentials)
+	}
+
+	return Principal{Subject: claims.Subject, OrganisationID: claims.OrganisationID, Admin: claims.Admin,
+		Roles: claims.Roles}, nil
+}
+
+// verify checks the signature and claims of the token, returning the claims if it is valid.
+func (verifier *JWTVerifier) verify(token string) (*claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("not a signed JWT")
+	}
+
+	var header struct {
+		Algorithm string `json:"alg"`
+		KeyID     string `json:"kid"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, fmt.Errorf("bad header: %v", err)
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("bad signature encoding: %v", err)
+	}
+	if err := verifier.checkSignature(header.Algorithm, header.KeyID, parts[0]+"."+parts[1],
+		signature); err != nil {
+		return nil, err
+	}
+
+	tokenClaims := &claims{}
+	if err := decodeSegment(parts[1], tokenClaims); err != nil {
+		return nil, fmt.Errorf("bad claims: %v", err)
+	}
+	now := time.Now()
+	switch {
+	case tokenClaims.Subject == "":
+		return nil, fmt.Errorf("sub claim is missing")
+	case tokenClaims.ExpiresAt == nil:
+		return nil, fmt.Errorf("exp claim is missing")
+	case now.Add(-clockSkew).After(unixTime(*tokenClaims.ExpiresAt)):
+		return nil, fmt.Errorf("token has expired")
+	case tokenClaims.NotBefore != nil && now.Add(clockSkew).Before(unixTime(*tokenClaims.NotBefore)):
+		return nil, fmt.Errorf("token is not valid yet")
+	case verifier.issuer != "" && tokenClaims.Issuer != verifier.issuer:
+		return nil, fmt.Errorf("token was not issued by %s", verifier.issuer)
+	case verifier.audience != "" && !tokenClaims.Audience.contains(verifier.audience):
+		return nil, fmt.Errorf("token is not meant for %s", verifier.audience)
+	}
+
+	return tokenClaims, nil
+}
+
+// checkSignature checks the signature of the signed content with the key named by the key ID, or when there is no
+// key ID each key that can be used with the algorithm in turn.
+func (verifier *JWTVerifier) checkSignature(algorithm, keyID, signed string, signature []byte) error {
+	accepted, ok := algorithms[algorithm]
+	if !ok {
+		return fmt.Errorf("algorithm %q is not accepted", algorithm)
+	}
+	digest := accepted.hash.New()
+	digest.Write([]byte(signed))
+	hashed := digest.Sum(nil)
+
+	tried := false
+	for _, key := range verifier.keys {
+		// a key is only used with algorithms for its type so an RSA public key is never taken as an HMAC secret
+		if (keyID != "" && key.id != keyID) || key.keyType != accepted.keyType ||
+			(key.algorithm != "" && key.algorithm != algorithm) {
+			continue
+		}
+		tried = true
+
+		switch key.keyType {
+		case "oct":
+			mac := hmac.New(accepted.hash.New, key.secret)
+			mac.Write([]byte(signed))
+			if hmac.Equal(mac.Sum(nil), signature) {
+				return nil
+			}
+		case "RSA":
+			if rsa.VerifyPKCS1v15(key.publicKey, accepted.hash, hashed, signature) == nil {
+				return nil
+			}
+		}
+	}
+	if !tried {
+		return fmt.Errorf("no key for algorithm %s and key ID %q", algorithm, keyID)
+	}
+
+	return fmt.Errorf("signature does not match")
+}
+
+// decodeSegment decodes a base64url encoded JSON segment of a token into the value.
+func decodeSegment(segment string, value interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(data, value)
+}
+
+// unixTime returns the time of a JWT NumericDate, seconds since the epoch.
+func unixTime(seconds float64) time.Time {
+	return time.Unix(int64(seconds), 0)
+}
@@ -0,0 +1,161 @@
+package auth_test
+
+import (
+	"crypto"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cdempsie/payments-example/auth"
+)
+
+var secret = []byte("a secret shared with the token issuer")
+
+func TestJWTVerifier(t *testing.T) {
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keySet, _ := json.Marshal(map[string]interface{}{"keys": []map[string]string{
+		{"kty": "oct", "kid": "hmac", "alg": "HS256", "k": encode(secret)},
+		{"kty": "RSA", "kid": "rsa", "alg": "RS256", "n": encode(privateKey.N.Bytes()),
+			"e": encode(big.NewInt(int64(privateKey.E)).Bytes())},
+	}})
+	verifier, err := auth.NewJWTVerifier(keySet, "https://issuer.example", "payments")
+	if err != nil {
+		t.Fatalf("Failed to create verifier: %v", err)
+	}
+
+	now := time.Now().Unix()
+	valid := map[string]interface{}{"sub": "alice", "organisation_id": "org-1", "roles": []string{"approver"},
+		"iss": "https://issuer.example",
+		"aud": []string{"payments", "other"}, "exp": now + 60, "nbf": now}
+	with := func(name string, value interface{}) map[string]interface{} {
+		changed := map[string]interface{}{}
+		for claim, each := range valid {
+			changed[claim] = each
+		}
+		if value == nil {
+			delete(changed, name)
+		} else {
+			changed[name] = value
+		}
+		return changed
+	}
+	rsaSign := func(signed string) []byte {
+		digest := sha256.Sum256([]byte(signed))
+		signature, err := rsa.SignPKCS1v15(rand.Reader, privateKey, crypto.SHA256, digest[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		return signature
+	}
+	publicKeyAsSecret := func(signed string) []byte {
+		mac := hmac.New(sha256.New, privateKey.PublicKey.N.Bytes())
+		mac.Write([]byte(signed))
+		return mac.Sum(nil)
+	}
+
+	tests := []struct {
+		name  string
+		token string
+		err   error
+	}{
+		{"hmac", token("HS256", "hmac", valid, hmacSign), nil},
+		{"rsa", token("RS256", "rsa", valid, rsaSign), nil},
+		{"no key ID", token("RS256", "", valid, rsaSign), nil},
+		{"single audience", token("HS256", "hmac", with("aud", "payments"), hmacSign), nil},
+		{"expired", token("HS256", "hmac", with("exp", now-120), hmacSign), auth.ErrInvalidCredentials},
+		{"no expiry", token("HS256", "hmac", with("exp", nil), hmacSign), auth.ErrInvalidCredentials},
+		{"not valid yet", token("HS256", "hmac", with("nbf", now+120), hmacSign), auth.ErrInvalidCredentials},
+		{"wrong issuer", token("HS256", "hmac", with("iss", "someone"), hmacSign), auth.ErrInvalidCredentials},
+		{"wrong audience", token("HS256", "hmac", with("aud", "other"), hmacSign), auth.ErrInvalidCredentials},
+		{"no subject", token("HS256", "hmac", with("sub", nil), hmacSign), auth.ErrInvalidCredentials},
+		{"tampered", tamper(token("HS256", "hmac", valid, hmacSign), with("admin", true)), auth.ErrInvalidCredentials},
+		{"unknown key", token("HS256", "other", valid, hmacSign), auth.ErrInvalidCredentials},
+		{"unsigned", token("none", "", valid, func(string) []byte { return nil }), auth.ErrInvalidCredentials},
+		{"public key as secret", token("HS256", "rsa", valid, publicKeyAsSecret), auth.ErrInvalidCredentials},
+		{"not a token", "garbage", auth.ErrInvalidCredentials},
+		{"no token", "", auth.ErrNoCredentials},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			request, _ := http.NewRequest(http.MethodGet, "/", nil)
+			if tt.token != "" {
+				request.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+
+			principal, err := verifier.Authenticate(request)
+			if tt.err != nil {
+				if !errors.Is(err, tt.err) {
+					t.Errorf("Expected error %v but got: %v", tt.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Failed to authenticate: %v", err)
+			}
+			want := auth.Principal{Subject: "alice", OrganisationID: "org-1", Roles: []string{"approver"}}
+			if !reflect.DeepEqual(principal, want) {
+				t.Errorf("Authenticated the wrong principal: %+v", principal)
+			}
+		})
+	}
+}
+
+func TestNewJWTVerifierInvalid(t *testing.T) {
+	keySets := map[string]string{
+		"not JSON":        `keys`,
+		"no keys":         `{"keys": []}`,
+		"empty secret":    `{"keys": [{"kty": "oct", "k": ""}]}`,
+		"mismatched alg":  `{"keys": [{"kty": "oct", "alg": "RS256", "k": "c2VjcmV0"}]}`,
+		"small RSA key":   `{"keys": [{"kty": "RSA", "n": "AQAB", "e": "AQAB"}]}`,
+		"encryption only": `{"keys": [{"kty": "oct", "use": "enc", "k": "c2VjcmV0"}]}`,
+	}
+	for name, keySet := range keySets {
+		if _, err := auth.NewJWTVerifier([]byte(keySet), "", ""); err == nil {
+			t.Errorf("Expected %s to be rejected", name)
+		}
+	}
+}
+
+// token returns a signed JWT with the claims.
+func token(algorithm, keyID string, claims map[string]interface{}, sign func(signed string) []byte) string {
+	header := map[string]string{"alg": algorithm, "typ": "JWT"}
+	if keyID != "" {
+		header["kid"] = keyID
+	}
+	encodedHeader, _ := json.Marshal(header)
+	encodedClaims, _ := json.Marshal(claims)
+	signed := encode(encodedHeader) + "." + encode(encodedClaims)
+
+	return signed + "." + encode(sign(signed))
+}
+
+// tamper returns the token with its claims replaced but the original signature.
+func tamper(signed string, claims map[string]interface{}) string {
+	parts := strings.Split(signed, ".")
+	encodedClaims, _ := json.Marshal(claims)
+
+	return parts[0] + "." + encode(encodedClaims) + "." + parts[2]
+}
+
+func hmacSign(signed string) []byte {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(signed))
+	return mac.Sum(nil)
+}
+
+func encode(data []byte) string {
+	return strings.TrimRight(base64.URLEncoding.EncodeToString(data), "=")
+}
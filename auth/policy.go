@@ -0,0 +1,129 @@
+package auth
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"sort"
+)
+
+// Operation is something a caller can do to payments, the policy decides which roles may do it.
+type Operation string
+
+// The operations on payments that are checked against the policy.
+const (
+	// OperationRead covers getting and listing payments along with their history.
+	OperationRead Operation = "read"
+	// OperationCreate covers creating payments.
+	OperationCreate Operation = "create"
+	// OperationUpdate covers updating and patching payments.
+	OperationUpdate Operation = "update"
+	// OperationTransition covers moving payments through their lifecycle.
+	OperationTransition Operation = "transition"
+	// OperationApprove covers approving or rejecting high value payments before they are submitted.
+	OperationApprove Operation = "approve"
+	// OperationDelete covers deleting payments.
+	OperationDelete Operation = "delete"
+	// OperationRestore covers restoring deleted payments.
+	OperationRestore Operation = "restore"
+)
+
+// operations lists every operation a policy can grant.
+var operations = map[Operation]bool{
+	OperationRead:       true,
+	OperationCreate:     true,
+	OperationUpdate:     true,
+	OperationTransition: true,
+	OperationApprove:    true,
+	OperationDelete:     true,
+	OperationRestore:    true,
+}
+
+// Policy maps roles to the operations callers holding them may perform. A caller may perform an operation if any of
+// their roles grants it, there is no way to deny an operation granted by another role.
+type Policy struct {
+	roles map[string]map[Operation]bool
+}
+
+// DefaultPolicy returns the policy used when none is configured. Viewers can read payments, operators can also
+// create and update them, approvers can read, approve and move them through their lifecycle and admins can do
+// anything, including deleting and restoring them.
+func DefaultPolicy() *Policy {
+	policy, _ := NewPolicy(map[string][]Operation{
+		"viewer":   {OperationRead},
+		"operator": {OperationRead, OperationCreate, OperationUpdate},
+		"approver": {OperationRead, OperationTransition, OperationApprove},
+		"admin": {OperationRead, OperationCreate, OperationUpdate, OperationTransition, OperationApprove,
+			OperationDelete, OperationRestore},
+	})
+
+	return policy
+}
+
+// LoadPolicy reads the policy from the JSON file at the path, which maps each role to the operations it grants:
+//
+//	{"roles": {"viewer": ["read"], "operator": ["read", "create", "update"]}}
+func LoadPolicy(path string) (*Policy, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read policy %s: %v", path, err)
+	}
+	var config struct {
+		Roles map[string][]Operation `json:"roles"`
+	}
+	if err := json.Unmarshal(data, &config); err != nil {
+		return nil, fmt.Errorf("failed to decode policy %s: %v", path, err)
+	}
+
+	policy, err := NewPolicy(config.Roles)
+	if err != nil {
+		return nil, fmt.Errorf("invalid policy %s: %v", path, err)
+	}
+
+	return policy, nil
+}
+
+// NewPolicy returns a policy granting each role the operations listed for it. An error is returned for an operation
+// that is not known so that a typo does not silently leave a role without it.
+func NewPolicy(roles map[string][]Operation) (*Policy, error) {
+	if len(roles) == 0 {
+		return nil, fmt.Errorf("no roles are defined")
+	}
+
+	policy := &Policy{roles: make(map[string]map[Operation]bool, len(roles))}
+	for role, granted := range roles {
+		policy.roles[role] = make(map[Operation]bool, len(granted))
+		for _, operation := range granted {
+			if !operations[operation] {
+				return nil, fmt.Errorf("role %s is granted unknown operation %q", role, operation)
+			}
+			policy.roles[role][operation] = true
+		}
+	}
+
+	return policy, nil
+}
+
+// Allows reports whether any of the roles of the principal grants the operation.
+func (policy *Policy) Allows(principal Principal, operation Operation) bool {
+	for _, role := range principal.Roles {
+		if policy.roles[role][operation] {
+			return true
+		}
+	}
+
+	return false
+}
+
+// RolesAllowing returns the roles that grant the operation, in order, to tell callers what they are missing.
+func (policy *Policy) RolesAllowing(operation Operation) []string {
+	var roles []string
+	for role, granted := range policy.roles {
+		if granted[operation] {
+			roles = append(roles, role)
+		}
+	}
+	sort.Strings(roles)
+
+	return roles
+}
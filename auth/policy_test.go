@@ -0,0 +1,65 @@
+package auth_test
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/cdempsie/payments-example/auth"
+)
+
+func TestDefaultPolicy(t *testing.T) {
+	policy := auth.DefaultPolicy()
+	tests := []struct {
+		roles     []string
+		operation auth.Operation
+		allowed   bool
+	}{
+		{[]string{"viewer"}, auth.OperationRead, true},
+		{[]string{"viewer"}, auth.OperationCreate, false},
+		{[]string{"operator"}, auth.OperationUpdate, true},
+		{[]string{"operator"}, auth.OperationTransition, false},
+		{[]string{"approver"}, auth.OperationTransition, true},
+		{[]string{"approver"}, auth.OperationDelete, false},
+		{[]string{"admin"}, auth.OperationDelete, true},
+		{[]string{"viewer", "approver"}, auth.OperationTransition, true},
+		{[]string{"unknown"}, auth.OperationRead, false},
+		{nil, auth.OperationRead, false},
+	}
+	for _, tt := range tests {
+		if allowed := policy.Allows(auth.Principal{Roles: tt.roles}, tt.operation); allowed != tt.allowed {
+			t.Errorf("Expected roles %v allowed to %s to be %v", tt.roles, tt.operation, tt.allowed)
+		}
+	}
+	if roles := policy.RolesAllowing(auth.OperationDelete); !reflect.DeepEqual(roles, []string{"admin"}) {
+		t.Errorf("Expected only admin to be allowed to delete but got: %v", roles)
+	}
+}
+
+func TestLoadPolicy(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "policy.json")
+	if err := ioutil.WriteFile(path, []byte(`{"roles": {"auditor": ["read"], "clerk": ["read", "create"]}}`),
+		0o600); err != nil {
+		t.Fatal(err)
+	}
+	policy, err := auth.LoadPolicy(path)
+	if err != nil {
+		t.Fatalf("Failed to load policy: %v", err)
+	}
+	if !policy.Allows(auth.Principal{Roles: []string{"clerk"}}, auth.OperationCreate) {
+		t.Errorf("Expected clerk to be allowed to create")
+	}
+	if policy.Allows(auth.Principal{Roles: []string{"admin"}}, auth.OperationDelete) {
+		t.Errorf("Expected the default roles not to be used")
+	}
+
+	invalid := filepath.Join(dir, "invalid.json")
+	if err := ioutil.WriteFile(invalid, []byte(`{"roles": {"clerk": ["raed"]}}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := auth.LoadPolicy(invalid); err == nil {
+		t.Errorf("Expected an unknown operation to be rejected")
+	}
+}
@@ -0,0 +1,300 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/cdempsie/payments-example/api"
+	"github.com/cdempsie/payments-example/approval"
+	"github.com/cdempsie/payments-example/audit"
+	"github.com/cdempsie/payments-example/auth"
+	"github.com/cdempsie/payments-example/idempotency"
+	"github.com/cdempsie/payments-example/logging"
+	"github.com/cdempsie/payments-example/persist"
+	"github.com/cdempsie/payments-example/server"
+	"github.com/cdempsie/payments-example/tracing"
+)
+
+// Build metadata reported at /version, set at link time with
+//
+//	go build -ldflags "-X main.version=1.2.0 -X main.commit=$(git rev-parse HEAD) -X main.buildDate=$(date -u +%FT%TZ)"
+var (
+	version   = "dev"
+	commit    = "unknown"
+	buildDate = "unknown"
+)
+
+var (
+	port              int
+	store             string
+	dataDir           string
+	compactAfter      int
+	sqlDriver         string
+	dsn               string
+	idempotencyTTL    time.Duration
+	auditLogPath      string
+	retention         time.Duration
+	purgeInterval     time.Duration
+	apiKeysPath       string
+	jwksPath          string
+	jwtIssuer         string
+	jwtAudience       string
+	trustGateway      bool
+	policyPath        string
+	thresholdsPath    string
+	approvalsPath     string
+	readTimeout       time.Duration
+	readHeaderTimeout time.Duration
+	writeTimeout      time.Duration
+	idleTimeout       time.Duration
+	shutdownTimeout   time.Duration
+	requestTimeout    time.Duration
+	maxHeaderBytes    int
+	maxBodyBytes      int64
+	traceExporter     string
+	otlpEndpoint      string
+)
+
+func init() {
+	flag.StringVar(&store, "store", "in-memory", "The persitance store to use, one of in-memory (the default), file or sql")
+	flag.StringVar(&dataDir, "data-dir", "data", "The directory the file store keeps its data in, defaults to data")
+	flag.IntVar(&compactAfter, "compact-after", persist.DefaultCompactAfter, "The number of log entries the file store writes before compacting them into a snapshot")
+	flag.StringVar(&sqlDriver, "sql-driver", "sqlite3", "The database/sql driver the sql store uses, either sqlite3 (the default) or postgres")
+	flag.StringVar(&dsn, "dsn", "", "The data source name the sql store connects to, for example payments.db or postgres://user@host/payments")
+	flag.DurationVar(&idempotencyTTL, "idempotency-ttl", idempotency.DefaultTTL, "How long the response to a create with an Idempotency-Key is replayed for, defaults to 24h")
+	flag.StringVar(&auditLogPath, "audit-log", "", "The file the audit trail is appended to, defaults to audit.log in the data directory for the file store and memory otherwise")
+	flag.DurationVar(&retention, "retention", 7*365*24*time.Hour, "How long a deleted payment is kept, so it can be restored, before it is purged, defaults to 7 years")
+	flag.DurationVar(&purgeInterval, "purge-interval", time.Hour, "How often deleted payments past the retention period are purged, defaults to 1h")
+	flag.StringVar(&apiKeysPath, "api-keys", "", "A JSON file of the API keys callers can authenticate with")
+	flag.StringVar(&jwksPath, "jwks", "", "A JSON web key set file of the keys bearer tokens can be signed with")
+	flag.StringVar(&jwtIssuer, "jwt-issuer", "", "The iss claim bearer tokens must have, any issuer is accepted when empty")
+	flag.StringVar(&jwtAudience, "jwt-audience", "", "The aud claim bearer tokens must have, any audience is accepted when empty")
+	flag.BoolVar(&trustGateway, "trust-gateway-headers", false, "Trust the X-Actor, X-Organisation-ID, X-Roles and X-Admin headers to name the caller, only safe behind a gateway that authenticates callers and sets them itself")
+	flag.StringVar(&policyPath, "policy", "", "A JSON file mapping roles to the operations they can perform, defaults to the viewer, operator, approver and admin roles")
+	flag.StringVar(&thresholdsPath, "approval-thresholds", "", "A JSON file mapping currencies to the amount above which payments need a second person to approve them")
+	flag.StringVar(&approvalsPath, "approvals", "", "The file approvals are kept in, defaults to approvals.json in the data directory for the file store and memory otherwise")
+	flag.DurationVar(&readTimeout, "read-timeout", 30*time.Second, "How long a client has to send a whole request, defaults to 30s")
+	flag.DurationVar(&readHeaderTimeout, "read-header-timeout", 10*time.Second, "How long a client has to send the headers of a request, defaults to 10s")
+	flag.DurationVar(&writeTimeout, "write-timeout", 30*time.Second, "How long a request has to be handled and its response written, defaults to 30s")
+	flag.DurationVar(&idleTimeout, "idle-timeout", 2*time.Minute, "How long an idle keep-alive connection is kept open, defaults to 2m")
+	flag.DurationVar(&shutdownTimeout, "shutdown-timeout", 30*time.Second, "How long in-flight requests are given to finish on SIGTERM or SIGINT, defaults to 30s")
+	flag.DurationVar(&requestTimeout, "request-timeout", 25*time.Second, "How long the store is given to do the work for a request before it is abandoned with a 503, defaults to 25s")
+	flag.IntVar(&maxHeaderBytes, "max-header-bytes", http.DefaultMaxHeaderBytes, "The largest request headers accepted, defaults to 1MB")
+	flag.Int64Var(&maxBodyBytes, "max-body-bytes", server.DefaultMaxBodyBytes, "The largest request body accepted, defaults to 1MB")
+	flag.StringVar(&traceExporter, "trace-exporter", tracing.ExporterNone, "Where request traces are sent, one of none (the default), stdout or otlp")
+	flag.StringVar(&otlpEndpoint, "otlp-endpoint", "", "The URL of the OTLP/HTTP collector traces are sent to, for example http://localhost:4318, defaults to the OTEL_EXPORTER_OTLP_ENDPOINT environment variable")
+	flag.IntVar(&port, "port", 8000, "The port number to start the server on, defaults to 8000")
+}
+
+func main() {
+	srv, err := configure()
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, syscall.SIGINT)
+	defer stop()
+	purged := make(chan struct{})
+	go func() {
+		defer close(purged)
+		srv.PurgeDeleted(ctx, purgeInterval, retention)
+	}()
+
+	// start the server, defaults to :8000
+	httpServer := &http.Server{
+		Addr:              fmt.Sprintf(":%d", port),
+		Handler:           srv,
+		ReadTimeout:       readTimeout,
+		ReadHeaderTimeout: readHeaderTimeout,
+		WriteTimeout:      writeTimeout,
+		IdleTimeout:       idleTimeout,
+		MaxHeaderBytes:    maxHeaderBytes,
+	}
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- httpServer.ListenAndServe()
+	}()
+
+	select {
+	case err := <-serveErr:
+		slog.Error("Failed to serve", "error", err)
+		srv.Close()
+		os.Exit(1)
+	case <-ctx.Done():
+	}
+	stop()
+
+	// stop accepting connections and give in-flight requests until the deadline to finish
+	slog.Info("Shutting down, draining requests", "timeout", shutdownTimeout.String())
+	drainCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := httpServer.Shutdown(drainCtx); err != nil {
+		slog.Error("Failed to drain requests", "error", err)
+	}
+	// the stores are only closed once nothing can be using them
+	<-purged
+	if err := srv.Close(); err != nil {
+		os.Exit(1)
+	}
+}
+
+// configure will parse the command line flags and setup the server with the requested persistent store type.
+func configure() (*server.Server, error) {
+	if err := parseFlags(); err != nil {
+		return nil, err
+	}
+	// anything logged through the log package is written as JSON too
+	slog.SetDefault(logging.NewJSON(os.Stderr))
+
+	options := []server.Option{
+		server.WithIdempotencyTTL(idempotencyTTL),
+		server.WithMaxBodyBytes(maxBodyBytes),
+		server.WithRequestTimeout(requestTimeout),
+		server.WithBuildInfo(api.BuildInfo{Version: version, Commit: commit, BuildDate: buildDate}),
+	}
+
+	if traceExporter != tracing.ExporterNone {
+		provider, err := tracing.NewProvider(context.Background(), traceExporter, otlpEndpoint, version, os.Stdout)
+		if err != nil {
+			return nil, err
+		}
+		// closed last so that the spans of everything closed before it are exported
+		options = append(options, server.WithTracerProvider(provider), server.WithCloser(provider))
+	}
+
+	var authenticators auth.Chain
+	if apiKeysPath != "" {
+		apiKeys, err := auth.LoadAPIKeys(apiKeysPath)
+		if err != nil {
+			return nil, err
+		}
+		authenticators = append(authenticators, apiKeys)
+	}
+	if jwksPath != "" {
+		verifier, err := auth.LoadJWTVerifier(jwksPath, jwtIssuer, jwtAudience)
+		if err != nil {
+			return nil, err
+		}
+		authenticators = append(authenticators, verifier)
+	}
+	if trustGateway {
+		authenticators = append(authenticators, auth.Headers{})
+	}
+	options = append(options, server.WithAuthenticator(authenticators))
+	if policyPath != "" {
+		policy, err := auth.LoadPolicy(policyPath)
+		if err != nil {
+			return nil, err
+		}
+		options = append(options, server.WithPolicy(policy))
+	}
+
+	if auditLogPath == "" && store == "file" {
+		auditLogPath = filepath.Join(dataDir, "audit.log")
+	}
+	if auditLogPath != "" {
+		auditLog, err := audit.NewFileLog(context.Background(), auditLogPath)
+		if err != nil {
+			return nil, err
+		}
+		options = append(options, server.WithAuditLog(auditLog), server.WithCloser(auditLog))
+	}
+
+	if thresholdsPath != "" {
+		thresholds, err := approval.LoadThresholds(thresholdsPath)
+		if err != nil {
+			return nil, err
+		}
+		var approvals approval.Store = approval.NewInMemoryStore()
+		if approvalsPath == "" && store == "file" {
+			approvalsPath = filepath.Join(dataDir, "approvals.json")
+		}
+		if approvalsPath != "" {
+			if approvals, err = approval.NewFileStore(approvalsPath); err != nil {
+				return nil, err
+			}
+		}
+		options = append(options, server.WithApprovals(approvals, thresholds))
+	}
+
+	var paymentStore persist.PaymentStore
+	switch store {
+	case "in-memory":
+		paymentStore = persist.NewInMemoryStore()
+	case "file":
+		fileStore, err := persist.NewFileStore(dataDir, compactAfter)
+		if err != nil {
+			return nil, err
+		}
+		paymentStore = fileStore
+		options = append(options, server.WithCloser(fileStore))
+	case "sql":
+		sqlStore, err := persist.NewSQLStore(sqlDriver, dsn)
+		if err != nil {
+			return nil, err
+		}
+		paymentStore = sqlStore
+		options = append(options, server.WithCloser(sqlStore))
+	default:
+		return nil, fmt.Errorf("unknown store type requested: %s", store)
+	}
+
+	return server.New(paymentStore, slog.Default(), options...), nil
+}
+
+// parseFlags parses the command line flags returning any errors.
+func parseFlags() error {
+	flag.Parse()
+	switch store {
+	case "in-memory":
+	case "file":
+		if dataDir == "" {
+			return fmt.Errorf("a data directory must be given with -data-dir when using the file store")
+		}
+	case "sql":
+		if dsn == "" {
+			return fmt.Errorf("a data source name must be given with -dsn when using the sql store")
+		}
+	default:
+		return fmt.Errorf("invalid store value: %s only \"in-memory\", \"file\" and \"sql\" are currently supported", store)
+	}
+	if idempotencyTTL <= 0 {
+		return fmt.Errorf("the idempotency TTL must be positive")
+	}
+	if retention < 0 || purgeInterval <= 0 {
+		return fmt.Errorf("the retention period can not be negative and the purge interval must be positive")
+	}
+	for name, timeout := range map[string]time.Duration{
+		"read": readTimeout, "read header": readHeaderTimeout, "write": writeTimeout, "idle": idleTimeout,
+		"shutdown": shutdownTimeout, "request": requestTimeout,
+	} {
+		if timeout <= 0 {
+			return fmt.Errorf("the %s timeout must be positive", name)
+		}
+	}
+	if apiKeysPath == "" && jwksPath == "" && !trustGateway {
+		return fmt.Errorf("callers must be authenticated with -api-keys or -jwks, or -trust-gateway-headers given " +
+			"when a gateway in front of the server authenticates them")
+	}
+	if trustGateway && (apiKeysPath != "" || jwksPath != "") {
+		return fmt.Errorf("-trust-gateway-headers can not be used with -api-keys or -jwks as anyone could set the headers")
+	}
+	if maxHeaderBytes <= 0 || maxBodyBytes <= 0 {
+		return fmt.Errorf("the maximum header and body sizes must be positive")
+	}
+	switch traceExporter {
+	case tracing.ExporterNone, tracing.ExporterStdout, tracing.ExporterOTLP:
+	default:
+		return fmt.Errorf("invalid trace exporter: %s only \"none\", \"stdout\" and \"otlp\" are supported", traceExporter)
+	}
+
+	return nil
+}
@@ -0,0 +1,183 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/cdempsie/payments-example/api"
+	"github.com/cdempsie/payments-example/audit"
+	"github.com/cdempsie/payments-example/logging"
+	"github.com/cdempsie/payments-example/persist"
+)
+
+// Errors returned by the four-eyes approval of high value payments.
+var (
+	// ErrApprovalRequired is returned when submitting a payment above its approval threshold that has not been
+	// approved.
+	ErrApprovalRequired = errors.New("approval required")
+	// ErrSelfApproval is returned when someone who created or changed a payment tries to approve or reject it.
+	ErrSelfApproval = errors.New("payment can not be approved by who made it")
+	// ErrNoPendingApproval is returned when approving or rejecting a payment that is not waiting for approval.
+	ErrNoPendingApproval = errors.New("no approval pending")
+)
+
+// Approval returns the approval of the payment with the given ID. An error wrapping persist.ErrNotFound is returned
+// if the payment does not need approval.
+func (handler *PaymentHandler) Approval(ctx context.Context, paymentID string) (*api.Approval, error) {
+	if _, err := handler.Load(ctx, paymentID); err != nil {
+		return nil, err
+	}
+	approval, err := handler.approvals.Load(paymentID)
+	if err != nil {
+		return nil, err
+	}
+	if approval == nil {
+		return nil, fmt.Errorf("payment with ID: %s does not need approval: %w", paymentID, persist.ErrNotFound)
+	}
+
+	return approval, nil
+}
+
+// Approve approves the payment with the given ID so it can be submitted. Who approves it is taken from the context
+// and must not have created or changed the payment, otherwise an error wrapping ErrSelfApproval is returned.
+// An error wrapping ErrNoPendingApproval is returned if the payment is not waiting for approval.
+func (handler *PaymentHandler) Approve(ctx context.Context, paymentID, reason string) (*api.Approval, error) {
+	return handler.decide(ctx, paymentID, api.ApprovalApproved, reason)
+}
+
+// RejectApproval rejects the payment with the given ID so it can not be submitted unless it is changed and approved.
+// The same people that can approve a payment can reject it.
+func (handler *PaymentHandler) RejectApproval(ctx context.Context, paymentID, reason string) (*api.Approval, error) {
+	return handler.decide(ctx, paymentID, api.ApprovalRejected, reason)
+}
+
+// decide records the decision on the pending approval of the payment.
+func (handler *PaymentHandler) decide(ctx context.Context, paymentID string, state api.ApprovalState,
+	reason string) (*api.Approval, error) {
+	if _, err := handler.Load(ctx, paymentID); err != nil {
+		return nil, err
+	}
+
+	handler.approvalLock.Lock()
+	defer handler.approvalLock.Unlock()
+
+	approval, err := handler.approvals.Load(paymentID)
+	if err != nil {
+		return nil, err
+	}
+	if approval == nil || approval.State != api.ApprovalPending {
+		return nil, fmt.Errorf("payment with ID: %s: %w", paymentID, ErrNoPendingApproval)
+	}
+	actor := audit.Actor(ctx)
+	if actor == approval.CreatedBy || actor == approval.RequestedBy {
+		return nil, fmt.Errorf("%s created or changed payment with ID: %s: %w", actor, paymentID, ErrSelfApproval)
+	}
+
+	decidedAt := handler.now().UTC()
+	approval.State, approval.DecidedBy, approval.DecidedAt, approval.Reason = state, actor, &decidedAt, reason
+	if err := handler.approvals.Save(approval); err != nil {
+		return nil, err
+	}
+
+	return approval, nil
+}
+
+// storeWithApproval stores the change to the payment with the function. Approval of the payment is asked for, or
+// removed, before it is stored so that a submission can never go through on the approval of what the payment was
+// before. The earlier approval is put back if the payment can not be stored. The approval lock is held throughout so
+// that nothing is decided in between.
+func (handler *PaymentHandler) storeWithApproval(ctx context.Context, payment *api.Payment, store func() error) error {
+	handler.approvalLock.Lock()
+	defer handler.approvalLock.Unlock()
+
+	previous, err := handler.approvals.Load(payment.ID)
+	if err != nil {
+		return err
+	}
+	if err := handler.requestApproval(ctx, payment); err != nil {
+		return err
+	}
+	if err := store(); err != nil {
+		handler.restoreApproval(ctx, payment.ID, previous)
+		return err
+	}
+
+	return nil
+}
+
+// requestApproval asks for the payment to be approved, replacing any earlier decision, if it is above the threshold
+// for its currency and otherwise removes any approval it had. Who made the change is taken from the context. It must
+// be called with the approval lock held.
+func (handler *PaymentHandler) requestApproval(ctx context.Context, payment *api.Payment) error {
+	if !handler.thresholds.Requires(payment) {
+		return handler.approvals.Delete(payment.ID)
+	}
+
+	return handler.approvals.Save(&api.Approval{
+		PaymentID:   payment.ID,
+		State:       api.ApprovalPending,
+		Amount:      payment.Amount,
+		Currency:    payment.Currency,
+		CreatedBy:   handler.creator(ctx, payment),
+		RequestedBy: audit.Actor(ctx),
+		RequestedAt: handler.now().UTC(),
+	})
+}
+
+// restoreApproval puts back the approval the payment had before a change that could not be stored. If that fails the
+// payment is left waiting for an approval of the change, which can not be submitted on, so it is only logged.
+func (handler *PaymentHandler) restoreApproval(ctx context.Context, paymentID string, previous *api.Approval) {
+	var err error
+	if previous == nil {
+		err = handler.approvals.Delete(paymentID)
+	} else {
+		err = handler.approvals.Save(previous)
+	}
+	if err != nil {
+		logging.FromContext(ctx).Error("Failed to restore approval", "payment_id", paymentID, "error", err)
+	}
+}
+
+// checkApproved returns an error wrapping ErrApprovalRequired if the payment is above the threshold for its currency
+// and has not been approved with its current amount and currency.
+func (handler *PaymentHandler) checkApproved(payment *api.Payment) error {
+	if !handler.thresholds.Requires(payment) {
+		return nil
+	}
+
+	approval, err := handler.approvals.Load(payment.ID)
+	if err != nil {
+		return err
+	}
+	if approval == nil || approval.State != api.ApprovalApproved || approval.Currency != payment.Currency ||
+		approval.Amount.Cmp(payment.Amount) != 0 {
+		return fmt.Errorf("payment with ID: %s is above the approval threshold for %s: %w", payment.ID,
+			payment.Currency, ErrApprovalRequired)
+	}
+
+	return nil
+}
+
+// creator returns who created the payment. Payments created before their creator was recorded on them are looked up
+// in the audit log, if the log has no record of them the creator is left empty rather than failing a change that has
+// already been stored.
+func (handler *PaymentHandler) creator(ctx context.Context, payment *api.Payment) string {
+	if payment.CreatedBy != "" {
+		return payment.CreatedBy
+	}
+
+	events, err := handler.auditLog.Events(ctx, payment.ID)
+	if err != nil {
+		logging.FromContext(ctx).Warn("Failed to look up who created payment", "payment_id", payment.ID,
+			"error", err)
+		return ""
+	}
+	for _, event := range events {
+		if event.Action == api.ActionCreated {
+			return event.Actor
+		}
+	}
+
+	return ""
+}
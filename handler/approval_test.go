@@ -0,0 +1,173 @@
+package handler_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/cdempsie/payments-example/api"
+	"github.com/cdempsie/payments-example/approval"
+	"github.com/cdempsie/payments-example/audit"
+	"github.com/cdempsie/payments-example/handler"
+	"github.com/cdempsie/payments-example/money"
+	"github.com/cdempsie/payments-example/persist"
+)
+
+// TestFourEyesApproval tests a payment above the threshold can only be submitted once someone other than its creator,
+// or whoever last changed it, has approved it.
+func TestFourEyesApproval(t *testing.T) {
+	paymentHandler := handler.NewPaymentHandler(persist.NewInMemoryStore(), handler.WithApprovals(
+		approval.NewInMemoryStore(), approval.Thresholds{"GBP": money.MustParse("100.00")}))
+	payment := createPayment(t, paymentHandler)
+	transition(t, paymentHandler, payment.ID, api.StatusValidated)
+
+	pending, err := paymentHandler.Approval(testContext(), payment.ID)
+	if err != nil {
+		t.Fatalf("Failed to get approval: %v", err)
+	}
+	if pending.State != api.ApprovalPending || pending.CreatedBy != "tester" || pending.Amount.String() != "100.21" {
+		t.Errorf("Expected a pending approval of the payment but got: %+v", pending)
+	}
+	submit := handler.TransitionRequest{To: api.StatusSubmitted}
+	if _, err := paymentHandler.Transition(testContext(), payment.ID, submit); !errors.Is(err,
+		handler.ErrApprovalRequired) {
+		t.Errorf("Expected submitting without approval to fail but got: %v", err)
+	}
+	if _, err := paymentHandler.Approve(testContext(), payment.ID, ""); !errors.Is(err, handler.ErrSelfApproval) {
+		t.Errorf("Expected the creator not to be able to approve but got: %v", err)
+	}
+
+	// a change by someone else needs approving again, and they can not approve it either
+	update := *payment
+	update.Version, update.Status, update.Reference = 1, "", "Changed"
+	if err := paymentHandler.Update(actorContext("bob"), &update); err != nil {
+		t.Fatalf("Failed to update payment: %v", err)
+	}
+	if _, err := paymentHandler.Approve(actorContext("bob"), payment.ID, ""); !errors.Is(err,
+		handler.ErrSelfApproval) {
+		t.Errorf("Expected whoever changed the payment not to be able to approve but got: %v", err)
+	}
+
+	approved, err := paymentHandler.Approve(actorContext("carol"), payment.ID, "checked")
+	if err != nil {
+		t.Fatalf("Failed to approve payment: %v", err)
+	}
+	if approved.State != api.ApprovalApproved || approved.DecidedBy != "carol" || approved.Reason != "checked" {
+		t.Errorf("Expected the approval to be recorded but got: %+v", approved)
+	}
+	if _, err := paymentHandler.RejectApproval(actorContext("dave"), payment.ID, ""); !errors.Is(err,
+		handler.ErrNoPendingApproval) {
+		t.Errorf("Expected a decided approval not to be decided again but got: %v", err)
+	}
+	if _, err := paymentHandler.Transition(testContext(), payment.ID, submit); err != nil {
+		t.Errorf("Failed to submit approved payment: %v", err)
+	}
+}
+
+// TestApprovalBelowThreshold tests payments at or below the threshold, or in other currencies, need no approval.
+func TestApprovalBelowThreshold(t *testing.T) {
+	paymentHandler := handler.NewPaymentHandler(persist.NewInMemoryStore(), handler.WithApprovals(
+		approval.NewInMemoryStore(), approval.Thresholds{"GBP": money.MustParse("100.21")}))
+	payment := createPayment(t, paymentHandler)
+
+	if _, err := paymentHandler.Approval(testContext(), payment.ID); !errors.Is(err, persist.ErrNotFound) {
+		t.Errorf("Expected no approval for a payment at the threshold but got: %v", err)
+	}
+	if _, err := paymentHandler.Approve(actorContext("carol"), payment.ID, ""); !errors.Is(err,
+		handler.ErrNoPendingApproval) {
+		t.Errorf("Expected nothing to approve but got: %v", err)
+	}
+	transition(t, paymentHandler, payment.ID, api.StatusValidated)
+	transition(t, paymentHandler, payment.ID, api.StatusSubmitted)
+}
+
+// TestApprovalAfterRestart tests a payment's creator still can not approve it once the audit log has been lost, as
+// happens when an in-memory log is used with a store that survives a restart.
+func TestApprovalAfterRestart(t *testing.T) {
+	store := persist.NewInMemoryStore()
+	thresholds := approval.Thresholds{"GBP": money.MustParse("100.00")}
+	payment := createPayment(t, handler.NewPaymentHandler(store, handler.WithApprovals(approval.NewInMemoryStore(),
+		thresholds)))
+	if payment.CreatedBy != "tester" {
+		t.Errorf("Expected the creator to be recorded on the payment but got: %q", payment.CreatedBy)
+	}
+
+	restarted := handler.NewPaymentHandler(store, handler.WithApprovals(approval.NewInMemoryStore(), thresholds))
+	update := *payment
+	// claiming to have created the payment changes nothing
+	update.Status, update.Reference, update.CreatedBy = "", "Changed", "bob"
+	if err := restarted.Update(actorContext("bob"), &update); err != nil {
+		t.Fatalf("Failed to update payment: %v", err)
+	}
+	if _, err := restarted.Approve(testContext(), payment.ID, ""); !errors.Is(err, handler.ErrSelfApproval) {
+		t.Errorf("Expected the creator not to be able to approve but got: %v", err)
+	}
+}
+
+// TestAmountFixedOnceSubmitted tests a payment can not be raised above the threshold once it has been submitted, which
+// would settle it without approval.
+func TestAmountFixedOnceSubmitted(t *testing.T) {
+	paymentHandler := handler.NewPaymentHandler(persist.NewInMemoryStore(), handler.WithApprovals(
+		approval.NewInMemoryStore(), approval.Thresholds{"GBP": money.MustParse("1000.00")}))
+	payment := createPayment(t, paymentHandler)
+	transition(t, paymentHandler, payment.ID, api.StatusValidated)
+	transition(t, paymentHandler, payment.ID, api.StatusSubmitted)
+
+	update := *payment
+	update.Version, update.Status, update.Amount = 2, "", money.MustParse("5000.00")
+	if err := paymentHandler.Update(testContext(), &update); !errors.Is(err, handler.ErrIllegalTransition) {
+		t.Fatalf("Expected the amount of a submitted payment not to change but got: %v", err)
+	}
+	update.Amount, update.Currency = payment.Amount, "USD"
+	if err := paymentHandler.Update(testContext(), &update); !errors.Is(err, handler.ErrIllegalTransition) {
+		t.Fatalf("Expected the currency of a submitted payment not to change but got: %v", err)
+	}
+	update.Currency, update.Reference = payment.Currency, "Changed"
+	if err := paymentHandler.Update(testContext(), &update); err != nil {
+		t.Fatalf("Expected other fields of a submitted payment to change but got: %v", err)
+	}
+
+	settled, err := paymentHandler.Transition(testContext(), payment.ID, handler.TransitionRequest{To: api.StatusSettled})
+	if err != nil {
+		t.Fatalf("Failed to settle payment: %v", err)
+	}
+	if settled.Amount.String() != "100.21" {
+		t.Errorf("Expected the submitted amount to be settled but got: %s", settled.Amount)
+	}
+}
+
+// TestApprovalKeptWhenChangeFails tests a change that can not be stored leaves the approval as it was.
+func TestApprovalKeptWhenChangeFails(t *testing.T) {
+	paymentHandler := handler.NewPaymentHandler(persist.NewInMemoryStore(), handler.WithApprovals(
+		approval.NewInMemoryStore(), approval.Thresholds{"GBP": money.MustParse("100.00")}))
+	payment := createPayment(t, paymentHandler)
+	if _, err := paymentHandler.Approve(actorContext("carol"), payment.ID, ""); err != nil {
+		t.Fatalf("Failed to approve payment: %v", err)
+	}
+
+	update := *payment
+	update.Version, update.Status, update.Amount = 5, "", money.MustParse("5000.00")
+	if err := paymentHandler.Update(actorContext("bob"), &update); !errors.Is(err, persist.ErrConflict) {
+		t.Fatalf("Expected a stale update to conflict but got: %v", err)
+	}
+	kept, err := paymentHandler.Approval(testContext(), payment.ID)
+	if err != nil {
+		t.Fatalf("Failed to get approval: %v", err)
+	}
+	if kept.State != api.ApprovalApproved || kept.Amount.String() != "100.21" {
+		t.Errorf("Expected the approval to be kept but got: %+v", kept)
+	}
+}
+
+// transition moves the payment to the status as the tester.
+func transition(t *testing.T, paymentHandler *handler.PaymentHandler, paymentID string, status api.Status) {
+	request := handler.TransitionRequest{To: status}
+	if _, err := paymentHandler.Transition(testContext(), paymentID, request); err != nil {
+		t.Fatalf("Failed to move payment to %s: %v", status, err)
+	}
+}
+
+// actorContext returns a context for changes made by the actor.
+func actorContext(actor string) context.Context {
+	return audit.WithActor(context.Background(), actor)
+}
@@ -1,17 +1,73 @@
 package handler
 
 import (
+	"sync"
+	"time"
+
+	"github.com/cdempsie/payments-example/approval"
+	"github.com/cdempsie/payments-example/audit"
 	"github.com/cdempsie/payments-example/persist"
 )
 
 // PaymentHandler holds a persistent store that can be used to store payments.
-// Calls are simply delegated to the underlying store implementation.
+// Reads are simply delegated to the underlying store implementation. Changes are checked against the payment
+// lifecycle first and recorded in the audit log once made.
 // The Handler exists to allow plugability of different stores.
 type PaymentHandler struct {
 	persist.PaymentStore
+	auditLog audit.Log
+	// approvals keeps the approvals of the payments above the thresholds, decisions are made holding approvalLock.
+	approvals    approval.Store
+	thresholds   approval.Thresholds
+	approvalLock *sync.Mutex
+	// organisationID, when not empty, is the only organisation whose payments the handler can see.
+	organisationID string
+	// now returns the current time, replaced in tests.
+	now func() time.Time
+}
+
+// Option configures a PaymentHandler.
+type Option func(handler *PaymentHandler)
+
+// WithAuditLog records the changes made through the handler in the audit log, by default they are kept in memory.
+func WithAuditLog(auditLog audit.Log) Option {
+	return func(handler *PaymentHandler) {
+		handler.auditLog = auditLog
+	}
+}
+
+// WithApprovals requires payments above the threshold for their currency to be approved by someone other than the
+// people who made them before they can be submitted, keeping the approvals in the store. By default no payment needs
+// approval.
+func WithApprovals(approvals approval.Store, thresholds approval.Thresholds) Option {
+	return func(handler *PaymentHandler) {
+		handler.approvals = approvals
+		handler.thresholds = thresholds
+	}
 }
 
 // NewPaymentHandler returns a new handler configured to use the given PaymentStore.
-func NewPaymentHandler(store persist.PaymentStore) *PaymentHandler {
-	return &PaymentHandler{store}
+func NewPaymentHandler(store persist.PaymentStore, options ...Option) *PaymentHandler {
+	handler := &PaymentHandler{
+		PaymentStore: store,
+		auditLog:     audit.NewInMemoryLog(),
+		approvals:    approval.NewInMemoryStore(),
+		approvalLock: &sync.Mutex{},
+		now:          time.Now,
+	}
+	for _, option := range options {
+		option(handler)
+	}
+
+	return handler
+}
+
+// ForOrganisation returns a handler that can only read and change the payments of the organisation, along with their
+// history. It shares the store and audit log of the handler.
+func (handler *PaymentHandler) ForOrganisation(organisationID string) *PaymentHandler {
+	scoped := *handler
+	scoped.PaymentStore = persist.NewScopedStore(handler.PaymentStore, organisationID)
+	scoped.organisationID = organisationID
+
+	return &scoped
 }
@@ -0,0 +1,96 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/cdempsie/payments-example/api"
+	"github.com/cdempsie/payments-example/audit"
+	"github.com/cdempsie/payments-example/logging"
+	"github.com/cdempsie/payments-example/persist"
+)
+
+// History returns the audit trail of the payment with the given ID, oldest first. The trail outlives the payment so
+// the history of a deleted payment can still be read. An error wrapping persist.ErrNotFound is returned if there is no
+// record of the payment.
+func (handler *PaymentHandler) History(ctx context.Context, paymentID string) ([]api.AuditEvent, error) {
+	events, err := handler.events(ctx, paymentID)
+	if err != nil {
+		return nil, err
+	}
+	if len(events) == 0 {
+		return nil, fmt.Errorf("no history for payment with ID: %s: %w", paymentID, persist.ErrNotFound)
+	}
+
+	return events, nil
+}
+
+// LoadVersion returns the payment with the given ID as it was at the version.
+// An error wrapping persist.ErrNotFound is returned if the audit log has no record of that version.
+func (handler *PaymentHandler) LoadVersion(ctx context.Context, paymentID string, version int) (*api.Payment, error) {
+	events, err := handler.events(ctx, paymentID)
+	if err != nil {
+		return nil, err
+	}
+	for i := len(events) - 1; i >= 0; i-- {
+		if payment := events[i].Payment; payment != nil && payment.Version == version {
+			return payment, nil
+		}
+	}
+
+	return nil, fmt.Errorf("payment with ID: %s has no version %d: %w", paymentID, version, persist.ErrNotFound)
+}
+
+// events returns the audit trail of the payment with the given ID. When the handler is for an organisation the trail
+// of a payment that last belonged to another organisation is left out as if there were no record of it.
+func (handler *PaymentHandler) events(ctx context.Context, paymentID string) ([]api.AuditEvent, error) {
+	events, err := handler.auditLog.Events(ctx, paymentID)
+	if err != nil || handler.organisationID == "" {
+		return events, err
+	}
+	for i := len(events) - 1; i >= 0; i-- {
+		if payment := events[i].Payment; payment != nil {
+			if payment.OrganisationID != handler.organisationID {
+				return nil, nil
+			}
+			break
+		}
+	}
+
+	return events, nil
+}
+
+// record appends an event for the change from before to after, either of which may be nil, to the audit log.
+// The actor and request ID are taken from the context. It is called once the change has been stored, so a failure
+// is logged rather than returned, the caller would otherwise be told a change failed that has been made and could
+// make it again when retrying.
+func (handler *PaymentHandler) record(ctx context.Context, action api.AuditAction, before, after *api.Payment) {
+	latest := after
+	if latest == nil {
+		latest = before
+	}
+	changes, err := audit.Diff(before, after)
+	if err != nil {
+		logging.FromContext(ctx).Error("Failed to record payment change in the audit log", "action", action,
+			"payment_id", latest.ID, "version", latest.Version, "error", err)
+		return
+	}
+
+	event := &api.AuditEvent{
+		Action:    action,
+		Actor:     audit.Actor(ctx),
+		RequestID: audit.RequestID(ctx),
+		At:        handler.now().UTC(),
+		Changes:   changes,
+		Payment:   after,
+	}
+	event.PaymentID, event.Version = latest.ID, latest.Version
+
+	if err := handler.auditLog.Append(ctx, event); err != nil {
+		logging.FromContext(ctx).Error("Failed to record payment change in the audit log", "action", action,
+			"payment_id", latest.ID, "version", latest.Version, "error", err)
+		return
+	}
+	logging.FromContext(ctx).Info("Recorded payment change", "action", action, "payment_id", latest.ID,
+		"version", latest.Version, "actor", event.Actor)
+}
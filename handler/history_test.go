@@ -0,0 +1,124 @@
+package handler_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/cdempsie/payments-example/api"
+	"github.com/cdempsie/payments-example/audit"
+	"github.com/cdempsie/payments-example/handler"
+	"github.com/cdempsie/payments-example/persist"
+	"github.com/google/uuid"
+)
+
+// TestHistory tests every change to a payment is recorded and earlier versions can be loaded, even after a delete.
+func TestHistory(t *testing.T) {
+	paymentHandler := handler.NewPaymentHandler(persist.NewInMemoryStore())
+	payment := createPayment(t, paymentHandler)
+
+	update := *payment
+	update.Reference = "Changed"
+	if err := paymentHandler.Update(testContext(), &update); err != nil {
+		t.Fatalf("Failed to update payment: %v", err)
+	}
+	if err := paymentHandler.Delete(testContext(), payment.ID); err != nil {
+		t.Fatalf("Failed to delete payment: %v", err)
+	}
+
+	events, err := paymentHandler.History(testContext(), payment.ID)
+	if err != nil {
+		t.Fatalf("Failed to get history: %v", err)
+	}
+	if len(events) != 3 {
+		t.Fatalf("Expected 3 events but got: %+v", events)
+	}
+	for i, action := range []api.AuditAction{api.ActionCreated, api.ActionUpdated, api.ActionDeleted} {
+		event := events[i]
+		if event.Action != action || event.Actor != "tester" || event.RequestID != "test-request" {
+			t.Errorf("Event %d recorded wrongly: %+v", i, event)
+		}
+	}
+	updated := events[1]
+	if len(updated.Changes) != 2 {
+		t.Fatalf("Expected the reference and version to change but got: %+v", updated.Changes)
+	}
+	if change := updated.Changes[0]; change.Pointer != "/attributes/reference" || change.To != "Changed" {
+		t.Errorf("Expected the reference to change but got: %+v", change)
+	}
+
+	original, err := paymentHandler.LoadVersion(testContext(), payment.ID, 0)
+	if err != nil {
+		t.Fatalf("Failed to load version 0: %v", err)
+	}
+	if original.Reference == "Changed" || original.Version != 0 {
+		t.Errorf("Loaded the wrong version: %+v", original)
+	}
+	if _, err := paymentHandler.LoadVersion(testContext(), payment.ID, 5); !errors.Is(err, persist.ErrNotFound) {
+		t.Errorf("Expected version 5 not to be found but got: %v", err)
+	}
+}
+
+// TestHistoryForOrganisation tests the history of a payment can only be read by its own organisation.
+func TestHistoryForOrganisation(t *testing.T) {
+	paymentHandler := handler.NewPaymentHandler(persist.NewInMemoryStore())
+	payment := createPayment(t, paymentHandler)
+
+	own := paymentHandler.ForOrganisation(payment.OrganisationID)
+	if _, err := own.History(testContext(), payment.ID); err != nil {
+		t.Errorf("Failed to get history for the organisation: %v", err)
+	}
+	if _, err := own.LoadVersion(testContext(), payment.ID, 0); err != nil {
+		t.Errorf("Failed to load version 0 for the organisation: %v", err)
+	}
+
+	other := paymentHandler.ForOrganisation(uuid.New().String())
+	if _, err := other.History(testContext(), payment.ID); !errors.Is(err, persist.ErrNotFound) {
+		t.Errorf("Expected history not to be found for another organisation but got: %v", err)
+	}
+	if _, err := other.LoadVersion(testContext(), payment.ID, 0); !errors.Is(err, persist.ErrNotFound) {
+		t.Errorf("Expected version 0 not to be found for another organisation but got: %v", err)
+	}
+	if err := other.Delete(testContext(), payment.ID); !errors.Is(err, persist.ErrNotFound) {
+		t.Errorf("Expected payment not to be found for another organisation but got: %v", err)
+	}
+}
+
+// failingLog is an audit log that fails to record any event.
+type failingLog struct {
+	*audit.InMemoryLog
+}
+
+// Append fails.
+func (failingLog) Append(ctx context.Context, event *api.AuditEvent) error {
+	return errors.New("disk full")
+}
+
+// TestAuditFailureAfterChange tests a change that has been stored is not reported as failed when it can not be
+// recorded in the audit log, so that retrying it does not make it twice.
+func TestAuditFailureAfterChange(t *testing.T) {
+	store := persist.NewInMemoryStore()
+	paymentHandler := handler.NewPaymentHandler(store, handler.WithAuditLog(failingLog{audit.NewInMemoryLog()}))
+	payment := createPayment(t, paymentHandler)
+
+	update := *payment
+	update.Reference = "Changed"
+	if err := paymentHandler.Update(testContext(), &update); err != nil {
+		t.Fatalf("Expected the update to succeed but got: %v", err)
+	}
+	if _, err := paymentHandler.Transition(testContext(), payment.ID,
+		handler.TransitionRequest{To: api.StatusValidated}); err != nil {
+		t.Fatalf("Expected the transition to succeed but got: %v", err)
+	}
+	if err := paymentHandler.Delete(testContext(), payment.ID); err != nil {
+		t.Fatalf("Expected the delete to succeed but got: %v", err)
+	}
+
+	stored, err := store.Load(testContext(), payment.ID)
+	if err != nil {
+		t.Fatalf("Failed to load payment: %v", err)
+	}
+	if stored.Reference != "Changed" || stored.Status != api.StatusValidated || stored.DeletedAt == nil {
+		t.Errorf("Expected every change to be stored but got: %+v", stored)
+	}
+}
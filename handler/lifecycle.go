@@ -0,0 +1,163 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/cdempsie/payments-example/api"
+	"github.com/cdempsie/payments-example/audit"
+	"github.com/google/uuid"
+)
+
+// ErrIllegalTransition is returned when a payment can not move from its current status to the one requested.
+var ErrIllegalTransition = errors.New("illegal status transition")
+
+// transitions maps each status to the statuses a payment can move to from it. Settled payments can only be returned
+// and rejected, returned and cancelled payments are final.
+var transitions = map[api.Status][]api.Status{
+	api.StatusCreated:   {api.StatusValidated, api.StatusCancelled},
+	api.StatusValidated: {api.StatusSubmitted, api.StatusRejected, api.StatusCancelled},
+	api.StatusSubmitted: {api.StatusSettled, api.StatusRejected},
+	api.StatusSettled:   {api.StatusReturned},
+}
+
+// CanTransition reports whether a payment can move from one status to another.
+func CanTransition(from, to api.Status) bool {
+	for _, next := range transitions[from] {
+		if next == to {
+			return true
+		}
+	}
+
+	return false
+}
+
+// TransitionRequest asks for a payment to be moved to a new status.
+type TransitionRequest struct {
+	To     api.Status
+	Reason string
+	// Version, when not nil, is the version of the payment the change is being made against.
+	Version *int
+}
+
+// Create creates the payment with the created status, recording who created it, taken from the context. Any status,
+// history, deletion or creator given is ignored. A payment above the approval threshold for its currency waits for
+// approval.
+func (handler *PaymentHandler) Create(ctx context.Context, payment *api.Payment) error {
+	if payment.ID == "" {
+		// assigned here rather than by the store so that the approval can be asked for before the payment is stored
+		payment.ID = uuid.New().String()
+	}
+	payment.Status = api.StatusCreated
+	payment.StatusHistory = nil
+	payment.DeletedAt, payment.DeletedBy = nil, ""
+	payment.CreatedBy = audit.Actor(ctx)
+	err := handler.storeWithApproval(ctx, payment, func() error {
+		return handler.PaymentStore.Create(ctx, payment)
+	})
+	if err != nil {
+		return err
+	}
+
+	handler.record(ctx, api.ActionCreated, nil, payment)
+
+	return nil
+}
+
+// Update updates the payment keeping its status and status history, which can only be changed by Transition, its
+// deletion, which can only be changed by Delete and Restore, and who created it.
+// A payment above the approval threshold for its currency needs approving again after any change.
+// An error wrapping ErrIllegalTransition is returned if the payment given has a different status to the stored one, or
+// changes the amount or currency of a payment that has been submitted.
+func (handler *PaymentHandler) Update(ctx context.Context, payment *api.Payment) error {
+	current, err := handler.Load(ctx, payment.ID)
+	if err != nil {
+		return err
+	}
+	status := currentStatus(current)
+	if payment.Status != "" && payment.Status != status {
+		return fmt.Errorf("payment with ID: %s is %s, the status can only be changed with a transition: %w",
+			payment.ID, status, ErrIllegalTransition)
+	}
+	if amountFixed(status) && (payment.Currency != current.Currency || payment.Amount.Cmp(current.Amount) != 0) {
+		return fmt.Errorf("payment with ID: %s is %s, its amount and currency can no longer be changed: %w",
+			payment.ID, status, ErrIllegalTransition)
+	}
+
+	payment.Status = status
+	payment.StatusHistory = current.StatusHistory
+	payment.DeletedAt, payment.DeletedBy = current.DeletedAt, current.DeletedBy
+	payment.CreatedBy = current.CreatedBy
+	err = handler.storeWithApproval(ctx, payment, func() error {
+		return handler.PaymentStore.Update(ctx, payment)
+	})
+	if err != nil {
+		return err
+	}
+
+	handler.record(ctx, api.ActionUpdated, current, payment)
+
+	return nil
+}
+
+// Transition moves the payment with the given ID to the requested status, recording who asked for it, taken from the
+// context, and when.
+// An error wrapping ErrIllegalTransition is returned if the lifecycle does not allow the change, one wrapping
+// ErrApprovalRequired if a payment above its approval threshold is submitted without approval and one wrapping
+// persist.ErrConflict if a version is given and it is not the current version of the payment.
+func (handler *PaymentHandler) Transition(ctx context.Context, paymentID string,
+	request TransitionRequest) (*api.Payment, error) {
+	stored, err := handler.Load(ctx, paymentID)
+	if err != nil {
+		return nil, err
+	}
+
+	// changed on a copy as the store may hand out the payment it holds
+	payment := *stored
+	from := currentStatus(&payment)
+	if !CanTransition(from, request.To) {
+		return nil, fmt.Errorf("payment with ID: %s can not move from %s to %s: %w", paymentID, from, request.To,
+			ErrIllegalTransition)
+	}
+	if request.To == api.StatusSubmitted {
+		if err := handler.checkApproved(&payment); err != nil {
+			return nil, err
+		}
+	}
+	if request.Version != nil {
+		payment.Version = *request.Version
+	}
+
+	payment.Status = request.To
+	payment.StatusHistory = append(append([]api.StatusChange(nil), stored.StatusHistory...), api.StatusChange{
+		From:   from,
+		To:     request.To,
+		Actor:  audit.Actor(ctx),
+		At:     handler.now().UTC(),
+		Reason: request.Reason,
+	})
+	if err := handler.PaymentStore.Update(ctx, &payment); err != nil {
+		return nil, err
+	}
+
+	handler.record(ctx, api.ActionStatusChanged, stored, &payment)
+
+	return &payment, nil
+}
+
+// amountFixed reports whether the amount and currency of a payment in the status can no longer change, as it has been
+// submitted, and approved if it needed to be, or its lifecycle has ended.
+func amountFixed(status api.Status) bool {
+	return status != api.StatusCreated && status != api.StatusValidated
+}
+
+// currentStatus returns the status of the payment, payments stored before statuses were introduced are treated as
+// just created.
+func currentStatus(payment *api.Payment) api.Status {
+	if payment.Status == "" {
+		return api.StatusCreated
+	}
+
+	return payment.Status
+}
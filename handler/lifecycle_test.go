@@ -0,0 +1,102 @@
+package handler_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/cdempsie/payments-example/api"
+	"github.com/cdempsie/payments-example/audit"
+	"github.com/cdempsie/payments-example/handler"
+	"github.com/cdempsie/payments-example/persist"
+	"github.com/cdempsie/payments-example/test"
+)
+
+// TestLifecycle tests a payment can be taken through its lifecycle and each change is recorded.
+func TestLifecycle(t *testing.T) {
+	paymentHandler := handler.NewPaymentHandler(persist.NewInMemoryStore())
+	payment := createPayment(t, paymentHandler)
+	if payment.Status != api.StatusCreated {
+		t.Fatalf("Expected a new payment to be created but got: %s", payment.Status)
+	}
+
+	for _, status := range []api.Status{api.StatusValidated, api.StatusSubmitted, api.StatusSettled,
+		api.StatusReturned} {
+		changed, err := paymentHandler.Transition(testContext(), payment.ID,
+			handler.TransitionRequest{To: status, Reason: "testing"})
+		if err != nil {
+			t.Fatalf("Failed to move payment to %s: %v", status, err)
+		}
+		if changed.Status != status {
+			t.Fatalf("Expected payment to be %s but got: %s", status, changed.Status)
+		}
+	}
+
+	stored, err := paymentHandler.Load(testContext(), payment.ID)
+	if err != nil {
+		t.Fatalf("Failed to load payment: %v", err)
+	}
+	if len(stored.StatusHistory) != 4 {
+		t.Fatalf("Expected 4 status changes but got: %v", stored.StatusHistory)
+	}
+	last := stored.StatusHistory[3]
+	if last.From != api.StatusSettled || last.To != api.StatusReturned || last.Actor != "tester" || last.At.IsZero() {
+		t.Fatalf("Status change recorded wrongly: %+v", last)
+	}
+}
+
+// TestIllegalTransitions tests the lifecycle is enforced for transitions and updates.
+func TestIllegalTransitions(t *testing.T) {
+	paymentHandler := handler.NewPaymentHandler(persist.NewInMemoryStore())
+	payment := createPayment(t, paymentHandler)
+
+	_, err := paymentHandler.Transition(testContext(), payment.ID, handler.TransitionRequest{To: api.StatusSettled})
+	if !errors.Is(err, handler.ErrIllegalTransition) {
+		t.Fatalf("Expected a created payment not to settle but got: %v", err)
+	}
+
+	update := *payment
+	update.Status = api.StatusSettled
+	if err := paymentHandler.Update(testContext(), &update); !errors.Is(err, handler.ErrIllegalTransition) {
+		t.Fatalf("Expected an update not to change the status but got: %v", err)
+	}
+
+	update.Status = ""
+	if err := paymentHandler.Update(testContext(), &update); err != nil {
+		t.Fatalf("Failed to update payment: %v", err)
+	}
+	if update.Status != api.StatusCreated {
+		t.Fatalf("Expected the update to keep the status but got: %s", update.Status)
+	}
+}
+
+// TestCanTransition tests the final statuses can not be left.
+func TestCanTransition(t *testing.T) {
+	for _, from := range []api.Status{api.StatusRejected, api.StatusReturned, api.StatusCancelled} {
+		for _, to := range []api.Status{api.StatusCreated, api.StatusValidated, api.StatusSubmitted,
+			api.StatusSettled, api.StatusRejected, api.StatusReturned, api.StatusCancelled} {
+			if handler.CanTransition(from, to) {
+				t.Errorf("Expected %s to be final but it can move to %s", from, to)
+			}
+		}
+	}
+}
+
+func createPayment(t *testing.T, paymentHandler *handler.PaymentHandler) *api.Payment {
+	payment := &api.Payment{}
+	if err := json.NewDecoder(strings.NewReader(test.CreatePayment)).Decode(payment); err != nil {
+		t.Fatalf("Failed to decode JSON: %v", err)
+	}
+	if err := paymentHandler.Create(testContext(), payment); err != nil {
+		t.Fatalf("Failed to create payment: %v", err)
+	}
+
+	return payment
+}
+
+// testContext returns a context for changes made by the tester.
+func testContext() context.Context {
+	return audit.WithRequestID(audit.WithActor(context.Background(), "tester"), "test-request")
+}
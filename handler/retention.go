@@ -0,0 +1,112 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/cdempsie/payments-example/api"
+	"github.com/cdempsie/payments-example/audit"
+	"github.com/cdempsie/payments-example/logging"
+	"github.com/cdempsie/payments-example/persist"
+)
+
+// ErrNotDeleted is returned when restoring a payment that has not been deleted.
+var ErrNotDeleted = errors.New("payment is not deleted")
+
+// purgeBatchSize is the number of deleted payments looked up at a time when purging.
+const purgeBatchSize = 100
+
+// Load loads the payment with the given ID. Soft deleted payments are treated as not found.
+func (handler *PaymentHandler) Load(ctx context.Context, paymentID string) (*api.Payment, error) {
+	payment, err := handler.PaymentStore.Load(ctx, paymentID)
+	if err != nil {
+		return nil, err
+	}
+	if payment.DeletedAt != nil {
+		return nil, fmt.Errorf("payment with ID: %s was deleted: %w", paymentID, persist.ErrNotFound)
+	}
+
+	return payment, nil
+}
+
+// Delete soft deletes the payment with the given ID, recording when and by whom, taken from the context. The payment
+// is no longer listed or returned by Load but is kept until it is purged so it can be restored.
+func (handler *PaymentHandler) Delete(ctx context.Context, paymentID string) error {
+	stored, err := handler.Load(ctx, paymentID)
+	if err != nil {
+		return err
+	}
+
+	// changed on a copy as the store may hand out the payment it holds
+	payment := *stored
+	deletedAt := handler.now().UTC()
+	payment.DeletedAt, payment.DeletedBy = &deletedAt, audit.Actor(ctx)
+	if err := handler.PaymentStore.Update(ctx, &payment); err != nil {
+		return err
+	}
+
+	handler.record(ctx, api.ActionDeleted, stored, &payment)
+
+	return nil
+}
+
+// Restore brings back the soft deleted payment with the given ID. An error wrapping ErrNotDeleted is returned if the
+// payment has not been deleted.
+func (handler *PaymentHandler) Restore(ctx context.Context, paymentID string) (*api.Payment, error) {
+	stored, err := handler.PaymentStore.Load(ctx, paymentID)
+	if err != nil {
+		return nil, err
+	}
+	if stored.DeletedAt == nil {
+		return nil, fmt.Errorf("can not restore payment with ID: %s: %w", paymentID, ErrNotDeleted)
+	}
+
+	payment := *stored
+	payment.DeletedAt, payment.DeletedBy = nil, ""
+	if err := handler.PaymentStore.Update(ctx, &payment); err != nil {
+		return nil, err
+	}
+
+	handler.record(ctx, api.ActionRestored, stored, &payment)
+
+	return &payment, nil
+}
+
+// Purge permanently deletes the payments that were soft deleted longer ago than the retention period, returning how
+// many were removed. Each removal is recorded in the audit log so the history of the payment remains.
+// A payment that fails to be removed is logged and left for the next purge.
+func (handler *PaymentHandler) Purge(ctx context.Context, retention time.Duration) (int, error) {
+	query := persist.ListQuery{
+		Filter: persist.ListFilter{DeletedBefore: handler.now().Add(-retention)},
+		Limit:  purgeBatchSize,
+	}
+
+	purged := 0
+	for {
+		expired, err := handler.List(ctx, query)
+		if err != nil {
+			return purged, err
+		}
+		if len(expired.Data) == 0 {
+			return purged, nil
+		}
+
+		for i := range expired.Data {
+			payment := &expired.Data[i]
+			if err := handler.PaymentStore.Delete(ctx, payment.ID); err != nil {
+				logging.FromContext(ctx).Error("Failed to purge payment", "payment_id", payment.ID, "error", err)
+				// skip over it next time round
+				query.Offset++
+				continue
+			}
+			purged++
+			if err := handler.approvals.Delete(payment.ID); err != nil {
+				logging.FromContext(ctx).Error("Failed to remove the approval of purged payment",
+					"payment_id", payment.ID, "error", err)
+			}
+			handler.record(ctx, api.ActionPurged, payment, nil)
+		}
+	}
+}
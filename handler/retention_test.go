@@ -0,0 +1,142 @@
+package handler_test
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/cdempsie/payments-example/api"
+	"github.com/cdempsie/payments-example/handler"
+	"github.com/cdempsie/payments-example/persist"
+	"github.com/cdempsie/payments-example/test"
+	"github.com/google/uuid"
+)
+
+// TestSoftDelete tests a deleted payment is hidden until it is restored.
+func TestSoftDelete(t *testing.T) {
+	paymentHandler := handler.NewPaymentHandler(persist.NewInMemoryStore())
+	payment := createPayment(t, paymentHandler)
+
+	if _, err := paymentHandler.Restore(testContext(), payment.ID); !errors.Is(err, handler.ErrNotDeleted) {
+		t.Errorf("Expected restoring a payment that is not deleted to fail but got: %v", err)
+	}
+	if err := paymentHandler.Delete(testContext(), payment.ID); err != nil {
+		t.Fatalf("Failed to delete payment: %v", err)
+	}
+	if _, err := paymentHandler.Load(testContext(), payment.ID); !errors.Is(err, persist.ErrNotFound) {
+		t.Errorf("Expected deleted payment not to be found but got: %v", err)
+	}
+	if err := paymentHandler.Delete(testContext(), payment.ID); !errors.Is(err, persist.ErrNotFound) {
+		t.Errorf("Expected deleting twice not to find the payment but got: %v", err)
+	}
+	if list, err := paymentHandler.List(testContext(), persist.ListQuery{}); err != nil || len(list.Data) != 0 {
+		t.Errorf("Expected deleted payment not to be listed but got: %+v, %v", list, err)
+	}
+
+	stored, err := paymentHandler.PaymentStore.Load(testContext(), payment.ID)
+	if err != nil {
+		t.Fatalf("Expected deleted payment to be kept but got: %v", err)
+	}
+	if stored.DeletedAt == nil || stored.DeletedBy != "tester" {
+		t.Errorf("Expected deletion to be recorded but got: %+v", stored)
+	}
+
+	restored, err := paymentHandler.Restore(testContext(), payment.ID)
+	if err != nil {
+		t.Fatalf("Failed to restore payment: %v", err)
+	}
+	if restored.DeletedAt != nil || restored.DeletedBy != "" {
+		t.Errorf("Expected deletion to be cleared but got: %+v", restored)
+	}
+	if _, err := paymentHandler.Load(testContext(), payment.ID); err != nil {
+		t.Errorf("Expected restored payment to be found but got: %v", err)
+	}
+}
+
+// TestPurge tests only payments deleted longer ago than the retention period are removed and the removal is audited.
+func TestPurge(t *testing.T) {
+	paymentHandler := handler.NewPaymentHandler(persist.NewInMemoryStore())
+	deleted := createPayment(t, paymentHandler)
+	kept := *deleted
+	kept.ID, kept.Version = uuid.New().String(), 0
+	if err := paymentHandler.Create(testContext(), &kept); err != nil {
+		t.Fatalf("Failed to create payment: %v", err)
+	}
+	if err := paymentHandler.Delete(testContext(), deleted.ID); err != nil {
+		t.Fatalf("Failed to delete payment: %v", err)
+	}
+
+	if purged, err := paymentHandler.Purge(testContext(), time.Hour); err != nil || purged != 0 {
+		t.Errorf("Expected nothing within the retention period to be purged but got: %d, %v", purged, err)
+	}
+	time.Sleep(time.Millisecond)
+	if purged, err := paymentHandler.Purge(testContext(), 0); err != nil || purged != 1 {
+		t.Fatalf("Expected the deleted payment to be purged but got: %d, %v", purged, err)
+	}
+
+	if _, err := paymentHandler.PaymentStore.Load(testContext(), deleted.ID); !errors.Is(err, persist.ErrNotFound) {
+		t.Errorf("Expected purged payment to be gone but got: %v", err)
+	}
+	if _, err := paymentHandler.Restore(testContext(), deleted.ID); !errors.Is(err, persist.ErrNotFound) {
+		t.Errorf("Expected purged payment not to be restored but got: %v", err)
+	}
+	if _, err := paymentHandler.Load(testContext(), kept.ID); err != nil {
+		t.Errorf("Expected payment that was not deleted to be kept but got: %v", err)
+	}
+
+	events, err := paymentHandler.History(testContext(), deleted.ID)
+	if err != nil {
+		t.Fatalf("Failed to get history: %v", err)
+	}
+	if last := events[len(events)-1]; last.Action != api.ActionPurged || last.Payment != nil {
+		t.Errorf("Expected the purge to be recorded but got: %+v", last)
+	}
+}
+
+// TestDeletionOnlyChangedByDelete tests a create or update can neither delete a payment nor bring a deleted one back,
+// so that deletion can not skip the delete permission, its audit event or the retention period.
+func TestDeletionOnlyChangedByDelete(t *testing.T) {
+	paymentHandler := handler.NewPaymentHandler(persist.NewInMemoryStore())
+	longAgo := time.Unix(0, 0).UTC()
+
+	payment := &api.Payment{}
+	if err := json.Unmarshal([]byte(test.CreatePayment), payment); err != nil {
+		t.Fatalf("Failed to decode JSON: %v", err)
+	}
+	payment.DeletedAt, payment.DeletedBy = &longAgo, "nobody"
+	if err := paymentHandler.Create(testContext(), payment); err != nil {
+		t.Fatalf("Failed to create payment: %v", err)
+	}
+	if _, err := paymentHandler.Load(testContext(), payment.ID); err != nil {
+		t.Errorf("Expected a create not to delete the payment but got: %v", err)
+	}
+
+	update := *payment
+	update.DeletedAt, update.DeletedBy = &longAgo, "nobody"
+	if err := paymentHandler.Update(testContext(), &update); err != nil {
+		t.Fatalf("Failed to update payment: %v", err)
+	}
+	stored, err := paymentHandler.PaymentStore.Load(testContext(), payment.ID)
+	if err != nil {
+		t.Fatalf("Failed to load payment: %v", err)
+	}
+	if stored.DeletedAt != nil || stored.DeletedBy != "" {
+		t.Errorf("Expected an update not to delete the payment but got: %+v", stored)
+	}
+	if purged, err := paymentHandler.Purge(testContext(), time.Hour); err != nil || purged != 0 {
+		t.Errorf("Expected nothing to be purged but got: %d, %v", purged, err)
+	}
+
+	if err := paymentHandler.Delete(testContext(), payment.ID); err != nil {
+		t.Fatalf("Failed to delete payment: %v", err)
+	}
+	update = *stored
+	update.DeletedAt, update.DeletedBy = nil, ""
+	if err := paymentHandler.Update(testContext(), &update); !errors.Is(err, persist.ErrNotFound) {
+		t.Errorf("Expected an update not to restore the payment but got: %v", err)
+	}
+	if _, err := paymentHandler.Load(testContext(), payment.ID); !errors.Is(err, persist.ErrNotFound) {
+		t.Errorf("Expected the payment to stay deleted but got: %v", err)
+	}
+}
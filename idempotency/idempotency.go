@@ -0,0 +1,123 @@
+// Package idempotency remembers the responses to requests made with an Idempotency-Key so that retried requests are
+// answered with the original response instead of being carried out again.
+package idempotency
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// DefaultTTL is how long a response is remembered for when no other time is configured.
+const DefaultTTL = 24 * time.Hour
+
+// ErrKeyReused is returned when a key is used again for a request with a different fingerprint.
+var ErrKeyReused = errors.New("idempotency key has already been used for a different request")
+
+// ErrInProgress is returned when a key is used while the first request made with it has not finished.
+var ErrInProgress = errors.New("a request with this idempotency key is still in progress")
+
+// Response is a response remembered for a key.
+type Response struct {
+	Status int
+	Header http.Header
+	Body   []byte
+}
+
+// Cache holds the responses for idempotency keys in memory until they expire. It is safe for concurrent use.
+type Cache struct {
+	ttl     time.Duration
+	now     func() time.Time
+	mutex   sync.Mutex
+	entries map[string]*entry
+	// expiries holds the finished entries in the order they expire, which is the order they finished in as every
+	// response is kept for the same time, so that expired entries are found without looking at the others.
+	expiries []expiry
+}
+
+// expiry is a finished entry waiting to expire.
+type expiry struct {
+	key   string
+	entry *entry
+}
+
+// entry is the state of a key, response is nil while the first request made with the key is in progress.
+type entry struct {
+	fingerprint [sha256.Size]byte
+	response    *Response
+	expires     time.Time
+}
+
+// NewCache returns a cache that remembers responses for the ttl.
+func NewCache(ttl time.Duration) *Cache {
+	return &Cache{ttl: ttl, now: time.Now, entries: map[string]*entry{}}
+}
+
+// Fingerprint identifies a request so that reuse of a key for a different request can be spotted. Each part, for
+// example the method, path and body, is included.
+func Fingerprint(parts ...[]byte) [sha256.Size]byte {
+	return sha256.Sum256(bytes.Join(parts, []byte{0}))
+}
+
+// Start claims the key for a request. If the key has not been seen, or its response has expired, nil is returned and
+// the caller must call Finish or Abandon once the request is done. If a request with the same fingerprint has already
+// finished its response is returned to be replayed.
+// ErrKeyReused is returned if the key was used for a different request and ErrInProgress if the first request made
+// with the key is still running.
+func (cache *Cache) Start(key string, fingerprint [sha256.Size]byte) (*Response, error) {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+
+	now := cache.now()
+	cache.removeExpired(now)
+
+	existing, ok := cache.entries[key]
+	if !ok {
+		cache.entries[key] = &entry{fingerprint: fingerprint, expires: now.Add(cache.ttl)}
+		return nil, nil
+	}
+	if existing.fingerprint != fingerprint {
+		return nil, ErrKeyReused
+	}
+	if existing.response == nil {
+		return nil, ErrInProgress
+	}
+
+	return existing.response, nil
+}
+
+// Finish remembers the response for a key claimed by Start, it will be replayed until the TTL has passed.
+func (cache *Cache) Finish(key string, response Response) {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+
+	if existing, ok := cache.entries[key]; ok && existing.response == nil {
+		existing.response = &response
+		existing.expires = cache.now().Add(cache.ttl)
+		cache.expiries = append(cache.expiries, expiry{key: key, entry: existing})
+	}
+}
+
+// Abandon releases a key claimed by Start without remembering a response, so the request can be retried.
+func (cache *Cache) Abandon(key string) {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+
+	if existing, ok := cache.entries[key]; ok && existing.response == nil {
+		delete(cache.entries, key)
+	}
+}
+
+// removeExpired forgets the keys whose responses have expired, keys still in progress are kept.
+func (cache *Cache) removeExpired(now time.Time) {
+	for len(cache.expiries) > 0 && now.After(cache.expiries[0].entry.expires) {
+		expired := cache.expiries[0]
+		cache.expiries = cache.expiries[1:]
+		if cache.entries[expired.key] == expired.entry {
+			delete(cache.entries, expired.key)
+		}
+	}
+}
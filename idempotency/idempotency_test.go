@@ -0,0 +1,94 @@
+package idempotency_test
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/cdempsie/payments-example/idempotency"
+)
+
+// TestReplay tests that a finished response is returned for a retry with the same fingerprint.
+func TestReplay(t *testing.T) {
+	cache := idempotency.NewCache(time.Hour)
+	fingerprint := idempotency.Fingerprint([]byte("POST"), []byte(`{"id": "1"}`))
+
+	response, err := cache.Start("key", fingerprint)
+	if response != nil || err != nil {
+		t.Fatalf("Expected a new key to be claimed but got: %v, %v", response, err)
+	}
+	if _, err := cache.Start("key", fingerprint); !errors.Is(err, idempotency.ErrInProgress) {
+		t.Fatalf("Expected the key to be in progress but got: %v", err)
+	}
+
+	cache.Finish("key", idempotency.Response{Status: http.StatusOK, Body: []byte("created")})
+	response, err = cache.Start("key", fingerprint)
+	if err != nil {
+		t.Fatalf("Failed to replay the response: %v", err)
+	}
+	if response == nil || response.Status != http.StatusOK || string(response.Body) != "created" {
+		t.Fatalf("Replayed the wrong response: %+v", response)
+	}
+}
+
+// TestKeyReused tests that a key can not be used for a different request.
+func TestKeyReused(t *testing.T) {
+	cache := idempotency.NewCache(time.Hour)
+	if _, err := cache.Start("key", idempotency.Fingerprint([]byte("first"))); err != nil {
+		t.Fatal(err)
+	}
+	cache.Finish("key", idempotency.Response{Status: http.StatusOK})
+
+	if _, err := cache.Start("key", idempotency.Fingerprint([]byte("second"))); !errors.Is(err, idempotency.ErrKeyReused) {
+		t.Fatalf("Expected the key to be rejected but got: %v", err)
+	}
+}
+
+// TestAbandon tests that an abandoned key can be claimed again.
+func TestAbandon(t *testing.T) {
+	cache := idempotency.NewCache(time.Hour)
+	fingerprint := idempotency.Fingerprint([]byte("request"))
+	if _, err := cache.Start("key", fingerprint); err != nil {
+		t.Fatal(err)
+	}
+	cache.Abandon("key")
+
+	if response, err := cache.Start("key", fingerprint); response != nil || err != nil {
+		t.Fatalf("Expected the abandoned key to be claimed again but got: %v, %v", response, err)
+	}
+}
+
+// TestExpiry tests that responses are forgotten once the TTL has passed.
+func TestExpiry(t *testing.T) {
+	cache := idempotency.NewCache(time.Millisecond)
+	if _, err := cache.Start("key", idempotency.Fingerprint([]byte("first"))); err != nil {
+		t.Fatal(err)
+	}
+	cache.Finish("key", idempotency.Response{Status: http.StatusOK})
+	time.Sleep(5 * time.Millisecond)
+
+	if response, err := cache.Start("key", idempotency.Fingerprint([]byte("second"))); response != nil || err != nil {
+		t.Fatalf("Expected the expired key to be claimed again but got: %v, %v", response, err)
+	}
+}
+
+// TestExpiryKeepsInProgress tests that expiring responses leaves keys whose requests are still running.
+func TestExpiryKeepsInProgress(t *testing.T) {
+	cache := idempotency.NewCache(time.Millisecond)
+	fingerprint := idempotency.Fingerprint([]byte("request"))
+	for _, key := range []string{"finished", "running"} {
+		if _, err := cache.Start(key, fingerprint); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cache.Finish("finished", idempotency.Response{Status: http.StatusOK})
+	time.Sleep(5 * time.Millisecond)
+
+	if response, err := cache.Start("finished", fingerprint); response != nil || err != nil {
+		t.Fatalf("Expected the expired key to be claimed again but got: %v, %v", response, err)
+	}
+	if _, err := cache.Start("running", fingerprint); !errors.Is(err, idempotency.ErrInProgress) {
+		t.Fatalf("Expected the running key to be kept but got: %v", err)
+	}
+}
@@ -0,0 +1,32 @@
+// Package logging carries a structured logger scoped to a request through the context, so that every line logged
+// while handling the request can be correlated by its request ID.
+package logging
+
+import (
+	"context"
+	"io"
+	"log/slog"
+)
+
+type contextKey int
+
+const loggerKey contextKey = iota
+
+// NewJSON returns a logger writing one JSON object per line to the writer.
+func NewJSON(writer io.Writer) *slog.Logger {
+	return slog.New(slog.NewJSONHandler(writer, nil))
+}
+
+// WithLogger returns a context carrying the logger.
+func WithLogger(ctx context.Context, logger *slog.Logger) context.Context {
+	return context.WithValue(ctx, loggerKey, logger)
+}
+
+// FromContext returns the logger carried by the context, or the default logger if it does not carry one.
+func FromContext(ctx context.Context) *slog.Logger {
+	if logger, ok := ctx.Value(loggerKey).(*slog.Logger); ok {
+		return logger
+	}
+
+	return slog.Default()
+}
@@ -0,0 +1,29 @@
+package logging_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"testing"
+
+	"github.com/cdempsie/payments-example/logging"
+)
+
+func TestFromContext(t *testing.T) {
+	if logging.FromContext(context.Background()) != slog.Default() {
+		t.Errorf("expected the default logger for a context without one")
+	}
+
+	var out bytes.Buffer
+	logger := logging.NewJSON(&out).With("request_id", "req-1")
+	logging.FromContext(logging.WithLogger(context.Background(), logger)).Info("handled", "status", 200)
+
+	line := map[string]interface{}{}
+	if err := json.Unmarshal(out.Bytes(), &line); err != nil {
+		t.Fatalf("expected a JSON log line, got %s: %v", out.String(), err)
+	}
+	if line["msg"] != "handled" || line["request_id"] != "req-1" || line["status"] != float64(200) {
+		t.Errorf("logged wrong line: %s", out.String())
+	}
+}
@@ -0,0 +1,280 @@
+// Package money provides a fixed-point decimal type for amounts of money along with ISO 4217 currency information.
+package money
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+// maxScale is the largest number of digits after the decimal point a Decimal can hold.
+const maxScale = 18
+
+// ErrOverflow is returned when a value or the result of an operation is too large to be held in a Decimal.
+var ErrOverflow = errors.New("decimal overflow")
+
+// Decimal is a fixed-point decimal number. It remembers the number of digits after the decimal point it was created
+// with so that, for example, "5.00" is written back out as "5.00".
+//
+// The zero value is an unset decimal, written as an empty string, which is treated as zero by arithmetic and
+// comparisons. Decimals are values and are safe to copy.
+type Decimal struct {
+	coefficient int64
+	scale       int32
+	set         bool
+}
+
+// New returns the decimal coefficient * 10^-scale, for example New(10021, 2) is 100.21.
+func New(coefficient int64, scale int) Decimal {
+	return Decimal{coefficient: coefficient, scale: int32(scale), set: true}
+}
+
+// Parse parses a decimal written as an optional minus sign, one or more digits and optionally a decimal point followed
+// by one or more digits, for example 100.21.
+func Parse(value string) (Decimal, error) {
+	digits := value
+	negative := strings.HasPrefix(digits, "-")
+	if negative {
+		digits = digits[1:]
+	}
+
+	whole, fraction := digits, ""
+	if point := strings.IndexByte(digits, '.'); point >= 0 {
+		whole, fraction = digits[:point], digits[point+1:]
+		if fraction == "" {
+			return Decimal{}, fmt.Errorf("invalid decimal %q: no digits after the decimal point", value)
+		}
+	}
+	if whole == "" || !allDigits(whole) || !allDigits(fraction) {
+		return Decimal{}, fmt.Errorf("invalid decimal %q", value)
+	}
+	if len(fraction) > maxScale {
+		return Decimal{}, fmt.Errorf("invalid decimal %q: more than %d decimal places", value, maxScale)
+	}
+
+	coefficient, err := strconv.ParseInt(whole+fraction, 10, 64)
+	if err != nil {
+		return Decimal{}, fmt.Errorf("invalid decimal %q: %w", value, ErrOverflow)
+	}
+	if negative {
+		coefficient = -coefficient
+	}
+
+	return Decimal{coefficient: coefficient, scale: int32(len(fraction)), set: true}, nil
+}
+
+// MustParse is like Parse but panics if the value is not a valid decimal. It is intended for constants and tests.
+func MustParse(value string) Decimal {
+	d, err := Parse(value)
+	if err != nil {
+		panic(err)
+	}
+
+	return d
+}
+
+func allDigits(value string) bool {
+	for _, r := range value {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+
+	return true
+}
+
+// IsSet reports whether the decimal has been given a value, as opposed to being the unset zero value.
+func (d Decimal) IsSet() bool {
+	return d.set
+}
+
+// Scale returns the number of digits after the decimal point.
+func (d Decimal) Scale() int {
+	return int(d.scale)
+}
+
+// String returns the decimal with its digits after the decimal point, or an empty string if it is unset.
+func (d Decimal) String() string {
+	if !d.set {
+		return ""
+	}
+
+	digits := strconv.FormatInt(d.coefficient, 10)
+	sign := ""
+	if d.coefficient < 0 {
+		sign, digits = "-", digits[1:]
+	}
+	if d.scale == 0 {
+		return sign + digits
+	}
+
+	scale := int(d.scale)
+	if len(digits) <= scale {
+		digits = strings.Repeat("0", scale-len(digits)+1) + digits
+	}
+
+	return sign + digits[:len(digits)-scale] + "." + digits[len(digits)-scale:]
+}
+
+// Sign returns -1, 0 or 1 depending on whether the decimal is negative, zero or positive.
+func (d Decimal) Sign() int {
+	switch {
+	case d.coefficient < 0:
+		return -1
+	case d.coefficient > 0:
+		return 1
+	default:
+		return 0
+	}
+}
+
+// Cmp compares the decimals returning -1, 0 or 1 when d is less than, equal to or greater than other.
+// Trailing zeros do not matter, 5.00 is equal to 5.
+func (d Decimal) Cmp(other Decimal) int {
+	scale := maxInt32(d.scale, other.scale)
+	return d.rescaled(scale).Cmp(other.rescaled(scale))
+}
+
+// Add returns d + other with the larger of their scales.
+func (d Decimal) Add(other Decimal) (Decimal, error) {
+	scale := maxInt32(d.scale, other.scale)
+	return fromBig(new(big.Int).Add(d.rescaled(scale), other.rescaled(scale)), scale)
+}
+
+// Sub returns d - other with the larger of their scales.
+func (d Decimal) Sub(other Decimal) (Decimal, error) {
+	scale := maxInt32(d.scale, other.scale)
+	return fromBig(new(big.Int).Sub(d.rescaled(scale), other.rescaled(scale)), scale)
+}
+
+// Mul returns d * other exactly, its scale is the sum of their scales.
+func (d Decimal) Mul(other Decimal) (Decimal, error) {
+	scale := d.scale + other.scale
+	if scale > maxScale {
+		return Decimal{}, fmt.Errorf("multiplying %s by %s needs more than %d decimal places: %w",
+			d, other, maxScale, ErrOverflow)
+	}
+
+	return fromBig(new(big.Int).Mul(big.NewInt(d.coefficient), big.NewInt(other.coefficient)), scale)
+}
+
+// Round returns the decimal rounded to the number of decimal places, halves are rounded away from zero.
+// Decimals that already have no more than that many places are returned unchanged.
+func (d Decimal) Round(places int) Decimal {
+	if places < 0 || int32(places) >= d.scale {
+		return d
+	}
+
+	divisor := pow10(d.scale - int32(places))
+	quotient, remainder := new(big.Int).QuoRem(big.NewInt(d.coefficient), divisor, new(big.Int))
+	if new(big.Int).Mul(new(big.Int).Abs(remainder), big.NewInt(2)).Cmp(divisor) >= 0 {
+		quotient.Add(quotient, big.NewInt(int64(d.Sign())))
+	}
+
+	// the result is smaller than d so always fits
+	return Decimal{coefficient: quotient.Int64(), scale: int32(places), set: d.set}
+}
+
+// Exact reports whether the decimal can be written with no more than the number of decimal places without
+// losing anything, for example 5.10 is exact to 1 place but 5.01 is not.
+func (d Decimal) Exact(places int) bool {
+	return d.Round(places).Cmp(d) == 0
+}
+
+// FitsCurrency reports whether the decimal is a whole number of the currency's minor unit, for example 1.005 is not a
+// valid amount of GBP. It is false for codes that are not ISO 4217 currency codes.
+func (d Decimal) FitsCurrency(code string) bool {
+	places, ok := MinorUnits(code)
+	return ok && d.Exact(places)
+}
+
+// MarshalJSON writes the decimal as a JSON string, for example "100.21". Unset decimals are written as "".
+func (d Decimal) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.String())
+}
+
+// UnmarshalJSON reads a decimal from a JSON string. An empty string gives an unset decimal.
+func (d *Decimal) UnmarshalJSON(data []byte) error {
+	var value string
+	if err := json.Unmarshal(data, &value); err != nil {
+		return fmt.Errorf("decimal must be a JSON string: %v", err)
+	}
+	if value == "" {
+		*d = Decimal{}
+		return nil
+	}
+
+	parsed, err := Parse(value)
+	if err != nil {
+		return err
+	}
+	*d = parsed
+
+	return nil
+}
+
+// Value stores the decimal in a database as its string form.
+func (d Decimal) Value() (driver.Value, error) {
+	return d.String(), nil
+}
+
+// Scan reads a decimal stored in a database as a string. An empty string or NULL gives an unset decimal.
+func (d *Decimal) Scan(src interface{}) error {
+	var value string
+	switch src := src.(type) {
+	case nil:
+	case string:
+		value = src
+	case []byte:
+		value = string(src)
+	default:
+		return fmt.Errorf("can not scan %T into a decimal", src)
+	}
+
+	if value == "" {
+		*d = Decimal{}
+		return nil
+	}
+	parsed, err := Parse(value)
+	if err != nil {
+		return err
+	}
+	*d = parsed
+
+	return nil
+}
+
+// rescaled returns the coefficient of the decimal when written with the given, larger or equal, scale.
+func (d Decimal) rescaled(scale int32) *big.Int {
+	coefficient := big.NewInt(d.coefficient)
+	if scale == d.scale {
+		return coefficient
+	}
+
+	return coefficient.Mul(coefficient, pow10(scale-d.scale))
+}
+
+// fromBig returns the decimal with the coefficient and scale or ErrOverflow if the coefficient is too large.
+func fromBig(coefficient *big.Int, scale int32) (Decimal, error) {
+	if !coefficient.IsInt64() {
+		return Decimal{}, ErrOverflow
+	}
+
+	return Decimal{coefficient: coefficient.Int64(), scale: scale, set: true}, nil
+}
+
+func pow10(exponent int32) *big.Int {
+	return new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(exponent)), nil)
+}
+
+func maxInt32(a, b int32) int32 {
+	if a > b {
+		return a
+	}
+
+	return b
+}
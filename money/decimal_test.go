@@ -0,0 +1,130 @@
+package money_test
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/cdempsie/payments-example/money"
+)
+
+// TestParse tests decimals are parsed and written back out with the same number of decimal places.
+func TestParse(t *testing.T) {
+	for _, value := range []string{"0", "5.00", "100.21", "-5.00", "0.001", "9223372036854775807"} {
+		d, err := money.Parse(value)
+		if err != nil {
+			t.Fatalf("Failed to parse %s: %v", value, err)
+		}
+		if d.String() != value {
+			t.Errorf("Expected %s to be written as %s but got: %s", value, value, d.String())
+		}
+	}
+
+	for _, value := range []string{"", "-", ".5", "5.", "1,000.00", "1e3", "+5", "1.2.3", " 5"} {
+		if _, err := money.Parse(value); err == nil {
+			t.Errorf("Expected %q to fail to parse", value)
+		}
+	}
+
+	if _, err := money.Parse("9223372036854775808"); !errors.Is(err, money.ErrOverflow) {
+		t.Errorf("Expected an overflow error but got: %v", err)
+	}
+}
+
+// TestArithmetic tests decimals are added, subtracted, multiplied and compared exactly.
+func TestArithmetic(t *testing.T) {
+	a, b := money.MustParse("0.10"), money.MustParse("0.2")
+
+	sum, err := a.Add(b)
+	if err != nil || sum.String() != "0.30" {
+		t.Errorf("Expected 0.10 + 0.2 = 0.30 but got: %s, %v", sum, err)
+	}
+	difference, err := a.Sub(b)
+	if err != nil || difference.String() != "-0.10" {
+		t.Errorf("Expected 0.10 - 0.2 = -0.10 but got: %s, %v", difference, err)
+	}
+	product, err := money.MustParse("100.21").Mul(money.MustParse("2.00000"))
+	if err != nil || product.String() != "200.4200000" {
+		t.Errorf("Expected 100.21 * 2.00000 = 200.4200000 but got: %s, %v", product, err)
+	}
+	if money.MustParse("5.00").Cmp(money.MustParse("5")) != 0 {
+		t.Errorf("Expected 5.00 to equal 5")
+	}
+	if money.MustParse("-1").Cmp(money.Decimal{}) >= 0 {
+		t.Errorf("Expected -1 to be less than an unset decimal")
+	}
+
+	max := money.MustParse("9223372036854775807")
+	if _, err := max.Add(money.MustParse("1")); !errors.Is(err, money.ErrOverflow) {
+		t.Errorf("Expected an overflow error but got: %v", err)
+	}
+}
+
+// TestRound tests halves are rounded away from zero.
+func TestRound(t *testing.T) {
+	tests := []struct {
+		value   string
+		places  int
+		rounded string
+	}{
+		{"1.005", 2, "1.01"},
+		{"1.004", 2, "1.00"},
+		{"-1.005", 2, "-1.01"},
+		{"2.5", 0, "3"},
+		{"1.2", 2, "1.2"},
+	}
+	for _, tc := range tests {
+		if rounded := money.MustParse(tc.value).Round(tc.places).String(); rounded != tc.rounded {
+			t.Errorf("Expected %s to round to %s but got: %s", tc.value, tc.rounded, rounded)
+		}
+	}
+}
+
+// TestFitsCurrency tests amounts are checked against the minor unit of their currency.
+func TestFitsCurrency(t *testing.T) {
+	tests := []struct {
+		value, currency string
+		fits            bool
+	}{
+		{"100.21", "GBP", true},
+		{"100.210", "GBP", true},
+		{"100.211", "GBP", false},
+		{"100", "JPY", true},
+		{"100.5", "JPY", false},
+		{"1.005", "KWD", true},
+		{"1.00", "ABC", false},
+	}
+	for _, tc := range tests {
+		if fits := money.MustParse(tc.value).FitsCurrency(tc.currency); fits != tc.fits {
+			t.Errorf("Expected %s %s fits to be %v", tc.value, tc.currency, tc.fits)
+		}
+	}
+}
+
+// TestJSON tests decimals are written and read as JSON strings.
+func TestJSON(t *testing.T) {
+	var holder struct {
+		Amount money.Decimal `json:"amount"`
+		Unset  money.Decimal `json:"unset"`
+	}
+	if err := json.Unmarshal([]byte(`{"amount": "100.20", "unset": ""}`), &holder); err != nil {
+		t.Fatalf("Failed to decode JSON: %v", err)
+	}
+	if holder.Unset.IsSet() {
+		t.Errorf("Expected an empty string to give an unset decimal")
+	}
+
+	data, err := json.Marshal(holder)
+	if err != nil {
+		t.Fatalf("Failed to encode JSON: %v", err)
+	}
+	if string(data) != `{"amount":"100.20","unset":""}` {
+		t.Errorf("Unexpected JSON: %s", data)
+	}
+
+	for _, bad := range []string{`{"amount": 100.20}`, `{"amount": "100,20"}`} {
+		if err := json.Unmarshal([]byte(bad), &holder); err == nil {
+			t.Errorf("Expected %s to fail to decode", bad)
+		}
+	}
+}
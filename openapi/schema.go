@@ -0,0 +1,162 @@
+package openapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// componentsPrefix is how a reference to a schema in the components of a document starts.
+const componentsPrefix = "#/components/schemas/"
+
+var (
+	timeType      = reflect.TypeOf(time.Time{})
+	rawType       = reflect.TypeOf(json.RawMessage{})
+	marshalerType = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+)
+
+// Schemas builds the schemas of Go values as encoding/json encodes them. Each named struct is described once in the
+// components of the document and referred to wherever it is used.
+type Schemas struct {
+	components map[string]*Schema
+	// defined holds the schemas of types that can not be worked out from the type alone, such as enums.
+	defined map[reflect.Type]*Schema
+	// named holds the type each component was built from so that two types with the same name are caught.
+	named map[string]reflect.Type
+}
+
+// NewSchemas returns a builder adding the schemas of named structs to the components, usually those of a document.
+// Times are described as RFC 3339 date-times.
+func NewSchemas(components map[string]*Schema) *Schemas {
+	schemas := &Schemas{
+		components: components,
+		defined:    map[reflect.Type]*Schema{},
+		named:      map[string]reflect.Type{},
+	}
+	schemas.defined[timeType] = &Schema{Type: "string", Format: "date-time"}
+	schemas.defined[rawType] = &Schema{}
+
+	return schemas
+}
+
+// Define describes values of the type of value with the schema. It must be used for types that encode themselves
+// with MarshalJSON and can be used to give the allowed values of a string type.
+func (schemas *Schemas) Define(value interface{}, schema *Schema) {
+	schemas.defined[reflect.TypeOf(value)] = schema
+}
+
+// For returns the schema of the value, a reference for named structs. It panics if the value, or anything in it,
+// encodes itself with MarshalJSON and has not been defined, or two different structs have the same name, as both are
+// programming errors.
+func (schemas *Schemas) For(value interface{}) *Schema {
+	return schemas.schemaOf(reflect.TypeOf(value))
+}
+
+// Ref returns a reference to the schema in the components with the name.
+func Ref(name string) *Schema {
+	return &Schema{Ref: componentsPrefix + name}
+}
+
+func (schemas *Schemas) schemaOf(valueType reflect.Type) *Schema {
+	if schema, ok := schemas.defined[valueType]; ok {
+		return schema
+	}
+	if valueType.Kind() == reflect.Ptr {
+		return schemas.schemaOf(valueType.Elem())
+	}
+	if valueType.Implements(marshalerType) || reflect.PtrTo(valueType).Implements(marshalerType) {
+		panic(fmt.Sprintf("%s encodes itself as JSON so its schema must be defined", valueType))
+	}
+
+	switch valueType.Kind() {
+	case reflect.String:
+		return &Schema{Type: "string"}
+	case reflect.Bool:
+		return &Schema{Type: "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return &Schema{Type: "integer"}
+	case reflect.Float32, reflect.Float64:
+		return &Schema{Type: "number"}
+	case reflect.Slice, reflect.Array:
+		if valueType.Elem().Kind() == reflect.Uint8 {
+			return &Schema{Type: "string", Format: "byte"}
+		}
+		return &Schema{Type: "array", Items: schemas.schemaOf(valueType.Elem())}
+	case reflect.Map:
+		return &Schema{Type: "object", AdditionalProperties: schemas.schemaOf(valueType.Elem())}
+	case reflect.Interface:
+		// anything at all
+		return &Schema{}
+	case reflect.Struct:
+		return schemas.structSchema(valueType)
+	default:
+		panic(fmt.Sprintf("%s can not be encoded as JSON", valueType))
+	}
+}
+
+// structSchema returns a reference to the component describing the struct, building it the first time, or the
+// schema itself for an anonymous struct.
+func (schemas *Schemas) structSchema(structType reflect.Type) *Schema {
+	name := structType.Name()
+	if name == "" {
+		return schemas.objectSchema(structType)
+	}
+	if existing, ok := schemas.named[name]; ok {
+		if existing != structType {
+			panic(fmt.Sprintf("%s and %s are both described as %s", existing, structType, name))
+		}
+		return Ref(name)
+	}
+
+	// named before the properties are built so that a struct referring to itself is only built once
+	schemas.named[name] = structType
+	schemas.components[name] = schemas.objectSchema(structType)
+
+	return Ref(name)
+}
+
+// objectSchema returns the schema of the struct, an object with a property for each field encoded.
+func (schemas *Schemas) objectSchema(structType reflect.Type) *Schema {
+	schema := &Schema{Type: "object", Properties: map[string]*Schema{}}
+	schemas.addProperties(schema, structType)
+
+	return schema
+}
+
+// addProperties adds the fields of the struct to the properties of the schema. The fields of embedded structs without
+// a JSON name are added as if they belonged to the struct, as encoding/json does.
+func (schemas *Schemas) addProperties(schema *Schema, structType reflect.Type) {
+	for i := 0; i < structType.NumField(); i++ {
+		field := structType.Field(i)
+		tag := field.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name := tag
+		if comma := strings.Index(tag, ","); comma >= 0 {
+			name = tag[:comma]
+		}
+
+		fieldType := field.Type
+		if fieldType.Kind() == reflect.Ptr {
+			fieldType = fieldType.Elem()
+		}
+		if field.Anonymous && name == "" && fieldType.Kind() == reflect.Struct {
+			if _, defined := schemas.defined[fieldType]; !defined {
+				schemas.addProperties(schema, fieldType)
+				continue
+			}
+		}
+		if field.PkgPath != "" && !(field.Anonymous && fieldType.Kind() == reflect.Struct) {
+			// unexported, embedded structs are still encoded as their fields are exported
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		schema.Properties[name] = schemas.schemaOf(field.Type)
+	}
+}
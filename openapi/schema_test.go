@@ -0,0 +1,104 @@
+package openapi_test
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cdempsie/payments-example/openapi"
+)
+
+type colour string
+
+type amount struct{ units int }
+
+func (a amount) MarshalJSON() ([]byte, error) {
+	return json.Marshal(a.units)
+}
+
+type address struct {
+	Street string `json:"street"`
+}
+
+type audited struct {
+	CreatedAt time.Time  `json:"created_at"`
+	DeletedAt *time.Time `json:"deleted_at,omitempty"`
+}
+
+type account struct {
+	ID      string `json:"id"`
+	Name    string
+	Secret  string `json:"-"`
+	hidden  string
+	Colour  colour                 `json:"colour,omitempty"`
+	Balance amount                 `json:"balance"`
+	Tags    []string               `json:"tags"`
+	Limits  map[string]float64     `json:"limits"`
+	Extra   map[string]interface{} `json:"extra"`
+	Owner   *account               `json:"owner,omitempty"`
+	audited
+	address `json:"address"`
+}
+
+func TestSchemas(t *testing.T) {
+	document := openapi.NewDocument(openapi.Info{Title: "test", Version: "1"})
+	schemas := openapi.NewSchemas(document.Components.Schemas)
+	schemas.Define(colour(""), &openapi.Schema{Type: "string", Enum: []string{"red", "green"}})
+	schemas.Define(amount{}, &openapi.Schema{Type: "integer"})
+
+	ref := schemas.For(&account{})
+	if ref.Ref != "#/components/schemas/account" {
+		t.Fatalf("expected a reference to the account schema, got %+v", ref)
+	}
+	if again := schemas.For(account{}); again.Ref != ref.Ref {
+		t.Errorf("expected the same reference for the struct and a pointer to it, got %+v", again)
+	}
+
+	got, err := json.Marshal(document.Components.Schemas)
+	if err != nil {
+		t.Fatalf("failed to encode schemas: %v", err)
+	}
+	expected := `{"account":{"type":"object","properties":{` +
+		`"Name":{"type":"string"},` +
+		`"address":{"$ref":"#/components/schemas/address"},` +
+		`"balance":{"type":"integer"},` +
+		`"colour":{"type":"string","enum":["red","green"]},` +
+		`"created_at":{"type":"string","format":"date-time"},` +
+		`"deleted_at":{"type":"string","format":"date-time"},` +
+		`"extra":{"type":"object","additionalProperties":{}},` +
+		`"id":{"type":"string"},` +
+		`"limits":{"type":"object","additionalProperties":{"type":"number"}},` +
+		`"owner":{"$ref":"#/components/schemas/account"},` +
+		`"tags":{"type":"array","items":{"type":"string"}}}},` +
+		`"address":{"type":"object","properties":{"street":{"type":"string"}}}}`
+	if string(got) != expected {
+		t.Errorf("expected schemas\n%s\ngot\n%s", expected, got)
+	}
+}
+
+func TestSchemasUndefinedMarshaler(t *testing.T) {
+	defer func() {
+		recovered := recover()
+		if message, _ := recovered.(string); !strings.Contains(message, "must be defined") {
+			t.Errorf("expected a panic asking for the schema to be defined, got %v", recovered)
+		}
+	}()
+
+	openapi.NewSchemas(map[string]*openapi.Schema{}).For(struct {
+		Balance amount `json:"balance"`
+	}{})
+}
+
+func TestAddOperation(t *testing.T) {
+	document := openapi.NewDocument(openapi.Info{Title: "test", Version: "1"})
+	operation := &openapi.Operation{OperationID: "getAccount"}
+	document.AddOperation("GET", "/accounts/{id}", operation)
+
+	if document.Operation("get", "/accounts/{id}") != operation {
+		t.Errorf("expected the operation to be found, got %+v", document.Paths)
+	}
+	if document.Operation("PUT", "/accounts/{id}") != nil {
+		t.Errorf("expected no operation for another method")
+	}
+}
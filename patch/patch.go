@@ -0,0 +1,356 @@
+// Package patch applies JSON Merge Patch (RFC 7396) and JSON Patch (RFC 6902) documents to JSON documents.
+package patch
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// The media types of the patch documents.
+const (
+	MergePatchType = "application/merge-patch+json"
+	JSONPatchType  = "application/json-patch+json"
+)
+
+// ErrMalformed is returned when a patch document is not valid JSON or not the right shape for its kind of patch.
+var ErrMalformed = errors.New("malformed patch")
+
+// ErrNotApplicable is returned when a well formed patch can not be applied to the document, for example because a path
+// does not exist or a test operation failed.
+var ErrNotApplicable = errors.New("patch can not be applied")
+
+// Merge applies an RFC 7396 merge patch to the document. Members of the patch replace the members of the document with
+// the same name, objects are merged recursively and null removes a member.
+func Merge(document, patch []byte) ([]byte, error) {
+	target, err := decode(document)
+	if err != nil {
+		return nil, fmt.Errorf("invalid document: %v", err)
+	}
+	changes, err := decode(patch)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrMalformed, err)
+	}
+
+	return json.Marshal(merge(target, changes))
+}
+
+func merge(target, patch interface{}) interface{} {
+	patchObject, ok := patch.(map[string]interface{})
+	if !ok {
+		return patch
+	}
+	targetObject, ok := target.(map[string]interface{})
+	if !ok {
+		targetObject = map[string]interface{}{}
+	}
+
+	for name, value := range patchObject {
+		if value == nil {
+			delete(targetObject, name)
+			continue
+		}
+		targetObject[name] = merge(targetObject[name], value)
+	}
+
+	return targetObject
+}
+
+// operation is a single operation of a JSON Patch.
+type operation struct {
+	Op    string           `json:"op"`
+	Path  *string          `json:"path"`
+	From  *string          `json:"from"`
+	Value *json.RawMessage `json:"value"`
+}
+
+// Apply applies an RFC 6902 JSON patch to the document. The operations are applied in order and if any of them fails
+// the document is left unchanged and an error is returned.
+func Apply(document, patch []byte) ([]byte, error) {
+	target, err := decode(document)
+	if err != nil {
+		return nil, fmt.Errorf("invalid document: %v", err)
+	}
+
+	var operations []operation
+	if err := json.Unmarshal(patch, &operations); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrMalformed, err)
+	}
+	for i, op := range operations {
+		target, err = apply(target, op)
+		if err != nil {
+			return nil, fmt.Errorf("operation %d: %w", i, err)
+		}
+	}
+
+	return json.Marshal(target)
+}
+
+func apply(document interface{}, op operation) (interface{}, error) {
+	if op.Path == nil {
+		return nil, fmt.Errorf("%w: %s operation has no path", ErrMalformed, op.Op)
+	}
+	path, err := parsePointer(*op.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	switch op.Op {
+	case "add", "replace", "test":
+		if op.Value == nil {
+			return nil, fmt.Errorf("%w: %s operation has no value", ErrMalformed, op.Op)
+		}
+		value, err := decode(*op.Value)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrMalformed, err)
+		}
+		switch op.Op {
+		case "add":
+			return add(document, path, value)
+		case "replace":
+			if document, _, err = remove(document, path); err != nil {
+				return nil, err
+			}
+			return add(document, path, value)
+		default:
+			current, err := get(document, path)
+			if err != nil {
+				return nil, err
+			}
+			if !reflect.DeepEqual(current, value) {
+				return nil, fmt.Errorf("%w: test of %s failed", ErrNotApplicable, *op.Path)
+			}
+			return document, nil
+		}
+	case "remove":
+		document, _, err = remove(document, path)
+		return document, err
+	case "move", "copy":
+		if op.From == nil {
+			return nil, fmt.Errorf("%w: %s operation has no from", ErrMalformed, op.Op)
+		}
+		from, err := parsePointer(*op.From)
+		if err != nil {
+			return nil, err
+		}
+		var value interface{}
+		if op.Op == "move" {
+			if isPrefix(from, path) && len(from) < len(path) {
+				return nil, fmt.Errorf("%w: can not move %s into itself", ErrNotApplicable, *op.From)
+			}
+			document, value, err = remove(document, from)
+		} else {
+			value, err = get(document, from)
+			value = deepCopy(value)
+		}
+		if err != nil {
+			return nil, err
+		}
+		return add(document, path, value)
+	default:
+		return nil, fmt.Errorf("%w: unknown operation %q", ErrMalformed, op.Op)
+	}
+}
+
+// add sets the value at the path, inserting into arrays, and returns the updated document.
+func add(document interface{}, path []string, value interface{}) (interface{}, error) {
+	if len(path) == 0 {
+		return value, nil
+	}
+
+	parent, err := get(document, path[:len(path)-1])
+	if err != nil {
+		return nil, err
+	}
+	name := path[len(path)-1]
+	switch container := parent.(type) {
+	case map[string]interface{}:
+		container[name] = value
+	case []interface{}:
+		index := len(container)
+		if name != "-" {
+			if index, err = arrayIndex(name, len(container)+1); err != nil {
+				return nil, err
+			}
+		}
+		container = append(container, nil)
+		copy(container[index+1:], container[index:])
+		container[index] = value
+		return set(document, path[:len(path)-1], container)
+	default:
+		return nil, fmt.Errorf("%w: %s is not an object or array", ErrNotApplicable, formatPointer(path[:len(path)-1]))
+	}
+
+	return document, nil
+}
+
+// remove removes the value at the path returning the updated document and the value removed.
+func remove(document interface{}, path []string) (interface{}, interface{}, error) {
+	if len(path) == 0 {
+		return nil, document, nil
+	}
+
+	parent, err := get(document, path[:len(path)-1])
+	if err != nil {
+		return nil, nil, err
+	}
+	name := path[len(path)-1]
+	switch container := parent.(type) {
+	case map[string]interface{}:
+		value, ok := container[name]
+		if !ok {
+			return nil, nil, fmt.Errorf("%w: %s does not exist", ErrNotApplicable, formatPointer(path))
+		}
+		delete(container, name)
+		return document, value, nil
+	case []interface{}:
+		index, err := arrayIndex(name, len(container))
+		if err != nil {
+			return nil, nil, err
+		}
+		value := container[index]
+		container = append(container[:index:index], container[index+1:]...)
+		document, err = set(document, path[:len(path)-1], container)
+		return document, value, err
+	default:
+		return nil, nil, fmt.Errorf("%w: %s is not an object or array", ErrNotApplicable,
+			formatPointer(path[:len(path)-1]))
+	}
+}
+
+// set replaces the existing value at the path, used to put back arrays that have changed length.
+func set(document interface{}, path []string, value interface{}) (interface{}, error) {
+	if len(path) == 0 {
+		return value, nil
+	}
+
+	parent, err := get(document, path[:len(path)-1])
+	if err != nil {
+		return nil, err
+	}
+	name := path[len(path)-1]
+	switch container := parent.(type) {
+	case map[string]interface{}:
+		container[name] = value
+	case []interface{}:
+		index, err := arrayIndex(name, len(container))
+		if err != nil {
+			return nil, err
+		}
+		container[index] = value
+	}
+
+	return document, nil
+}
+
+// get returns the value at the path.
+func get(document interface{}, path []string) (interface{}, error) {
+	value := document
+	for i, name := range path {
+		switch container := value.(type) {
+		case map[string]interface{}:
+			var ok bool
+			if value, ok = container[name]; !ok {
+				return nil, fmt.Errorf("%w: %s does not exist", ErrNotApplicable, formatPointer(path[:i+1]))
+			}
+		case []interface{}:
+			index, err := arrayIndex(name, len(container))
+			if err != nil {
+				return nil, err
+			}
+			value = container[index]
+		default:
+			return nil, fmt.Errorf("%w: %s does not exist", ErrNotApplicable, formatPointer(path[:i+1]))
+		}
+	}
+
+	return value, nil
+}
+
+// arrayIndex parses an array index that must be less than the limit.
+func arrayIndex(name string, limit int) (int, error) {
+	index, err := strconv.Atoi(name)
+	if err != nil || index < 0 || index >= limit || (len(name) > 1 && name[0] == '0') {
+		return 0, fmt.Errorf("%w: array index %s is out of range", ErrNotApplicable, name)
+	}
+
+	return index, nil
+}
+
+// parsePointer splits an RFC 6901 JSON pointer into its unescaped reference tokens.
+func parsePointer(pointer string) ([]string, error) {
+	if pointer == "" {
+		return nil, nil
+	}
+	if !strings.HasPrefix(pointer, "/") {
+		return nil, fmt.Errorf("%w: path %q must start with /", ErrMalformed, pointer)
+	}
+
+	tokens := strings.Split(pointer[1:], "/")
+	for i, token := range tokens {
+		tokens[i] = strings.ReplaceAll(strings.ReplaceAll(token, "~1", "/"), "~0", "~")
+	}
+
+	return tokens, nil
+}
+
+func formatPointer(path []string) string {
+	var pointer strings.Builder
+	for _, token := range path {
+		pointer.WriteString("/" + strings.ReplaceAll(strings.ReplaceAll(token, "~", "~0"), "/", "~1"))
+	}
+
+	return pointer.String()
+}
+
+func isPrefix(prefix, path []string) bool {
+	if len(prefix) > len(path) {
+		return false
+	}
+	for i := range prefix {
+		if prefix[i] != path[i] {
+			return false
+		}
+	}
+
+	return true
+}
+
+func deepCopy(value interface{}) interface{} {
+	switch value := value.(type) {
+	case map[string]interface{}:
+		copied := make(map[string]interface{}, len(value))
+		for name, member := range value {
+			copied[name] = deepCopy(member)
+		}
+		return copied
+	case []interface{}:
+		copied := make([]interface{}, len(value))
+		for i, element := range value {
+			copied[i] = deepCopy(element)
+		}
+		return copied
+	default:
+		return value
+	}
+}
+
+// decode decodes JSON keeping numbers as written so they are not rounded through floating point.
+func decode(data []byte) (interface{}, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+
+	var value interface{}
+	if err := dec.Decode(&value); err != nil {
+		return nil, err
+	}
+	if dec.More() {
+		return nil, errors.New("unexpected data after the JSON value")
+	}
+
+	return value, nil
+}
@@ -0,0 +1,114 @@
+package patch_test
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/cdempsie/payments-example/patch"
+)
+
+// TestMerge runs the examples from appendix A of RFC 7396.
+func TestMerge(t *testing.T) {
+	tests := []struct{ document, patch, result string }{
+		{`{"a":"b"}`, `{"a":"c"}`, `{"a":"c"}`},
+		{`{"a":"b"}`, `{"b":"c"}`, `{"a":"b","b":"c"}`},
+		{`{"a":"b"}`, `{"a":null}`, `{}`},
+		{`{"a":"b","b":"c"}`, `{"a":null}`, `{"b":"c"}`},
+		{`{"a":["b"]}`, `{"a":"c"}`, `{"a":"c"}`},
+		{`{"a":"c"}`, `{"a":["b"]}`, `{"a":["b"]}`},
+		{`{"a":{"b":"c"}}`, `{"a":{"b":"d","c":null}}`, `{"a":{"b":"d"}}`},
+		{`{"a":[{"b":"c"}]}`, `{"a":[1]}`, `{"a":[1]}`},
+		{`["a","b"]`, `["c","d"]`, `["c","d"]`},
+		{`{"a":"b"}`, `["c"]`, `["c"]`},
+		{`{"a":"foo"}`, `null`, `null`},
+		{`{"a":"foo"}`, `"bar"`, `"bar"`},
+		{`{"e":null}`, `{"a":1}`, `{"a":1,"e":null}`},
+		{`[1,2]`, `{"a":"b","c":null}`, `{"a":"b"}`},
+		{`{}`, `{"a":{"bb":{"ccc":null}}}`, `{"a":{"bb":{}}}`},
+	}
+
+	for _, tc := range tests {
+		result, err := patch.Merge([]byte(tc.document), []byte(tc.patch))
+		if err != nil {
+			t.Errorf("Failed to merge %s into %s: %v", tc.patch, tc.document, err)
+			continue
+		}
+		assertJSONEqual(t, tc.result, result)
+	}
+
+	if _, err := patch.Merge([]byte(`{}`), []byte(`{"a":`)); !errors.Is(err, patch.ErrMalformed) {
+		t.Errorf("Expected a malformed patch error but got: %v", err)
+	}
+}
+
+// TestApply runs a selection of the examples from appendix A of RFC 6902.
+func TestApply(t *testing.T) {
+	tests := []struct{ document, patch, result string }{
+		{`{"foo":"bar"}`, `[{"op":"add","path":"/baz","value":"qux"}]`, `{"baz":"qux","foo":"bar"}`},
+		{`{"foo":["bar","baz"]}`, `[{"op":"add","path":"/foo/1","value":"qux"}]`, `{"foo":["bar","qux","baz"]}`},
+		{`{"foo":["bar"]}`, `[{"op":"add","path":"/foo/-","value":["abc","def"]}]`,
+			`{"foo":["bar",["abc","def"]]}`},
+		{`{"baz":"qux","foo":"bar"}`, `[{"op":"remove","path":"/baz"}]`, `{"foo":"bar"}`},
+		{`{"foo":["bar","qux","baz"]}`, `[{"op":"remove","path":"/foo/1"}]`, `{"foo":["bar","baz"]}`},
+		{`{"baz":"qux","foo":"bar"}`, `[{"op":"replace","path":"/baz","value":"boo"}]`, `{"baz":"boo","foo":"bar"}`},
+		{`{"foo":{"bar":"baz","waldo":"fred"},"qux":{"corge":"grault"}}`,
+			`[{"op":"move","from":"/foo/waldo","path":"/qux/thud"}]`,
+			`{"foo":{"bar":"baz"},"qux":{"corge":"grault","thud":"fred"}}`},
+		{`{"foo":["all","grass","cows","eat"]}`, `[{"op":"move","from":"/foo/1","path":"/foo/3"}]`,
+			`{"foo":["all","cows","eat","grass"]}`},
+		{`{"foo":{"bar":1}}`, `[{"op":"copy","from":"/foo","path":"/baz"}]`, `{"foo":{"bar":1},"baz":{"bar":1}}`},
+		{`{"baz":"qux","foo":["a",2,"c"]}`,
+			`[{"op":"test","path":"/baz","value":"qux"},{"op":"test","path":"/foo/1","value":2}]`,
+			`{"baz":"qux","foo":["a",2,"c"]}`},
+		{`{"/":9,"~1":10}`, `[{"op":"replace","path":"/~01","value":11}]`, `{"/":9,"~1":11}`},
+	}
+
+	for _, tc := range tests {
+		result, err := patch.Apply([]byte(tc.document), []byte(tc.patch))
+		if err != nil {
+			t.Errorf("Failed to apply %s to %s: %v", tc.patch, tc.document, err)
+			continue
+		}
+		assertJSONEqual(t, tc.result, result)
+	}
+}
+
+// TestApplyErrors tests that patches which are badly formed or can not be applied are reported as such.
+func TestApplyErrors(t *testing.T) {
+	tests := []struct {
+		document, patch string
+		expected        error
+	}{
+		{`{}`, `{"op":"add"}`, patch.ErrMalformed},
+		{`{}`, `[{"op":"add","path":"/a"}]`, patch.ErrMalformed},
+		{`{}`, `[{"op":"frobnicate","path":"/a","value":1}]`, patch.ErrMalformed},
+		{`{}`, `[{"op":"add","path":"a","value":1}]`, patch.ErrMalformed},
+		{`{"baz":"qux"}`, `[{"op":"test","path":"/baz","value":"bar"}]`, patch.ErrNotApplicable},
+		{`{"foo":"bar"}`, `[{"op":"add","path":"/baz/bat","value":"qux"}]`, patch.ErrNotApplicable},
+		{`{"foo":"bar"}`, `[{"op":"remove","path":"/baz"}]`, patch.ErrNotApplicable},
+		{`{"foo":["bar"]}`, `[{"op":"replace","path":"/foo/5","value":1}]`, patch.ErrNotApplicable},
+	}
+
+	for _, tc := range tests {
+		if _, err := patch.Apply([]byte(tc.document), []byte(tc.patch)); !errors.Is(err, tc.expected) {
+			t.Errorf("Expected applying %s to %s to fail with %v but got: %v", tc.patch, tc.document, tc.expected, err)
+		}
+	}
+}
+
+func assertJSONEqual(t *testing.T, expected string, actual []byte) {
+	t.Helper()
+
+	var expectedValue, actualValue interface{}
+	if err := json.Unmarshal([]byte(expected), &expectedValue); err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(actual, &actualValue); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(expectedValue, actualValue) {
+		t.Errorf("Expected %s but got: %s", expected, actual)
+	}
+}
@@ -0,0 +1,58 @@
+package persist
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/cdempsie/payments-example/api"
+)
+
+// Errors returned by every PaymentStore. Stores wrap them with detail so callers should test for them with errors.Is.
+var (
+	// ErrNotFound is returned when there is no payment with the requested ID.
+	ErrNotFound = errors.New("not found")
+	// ErrConflict is returned when a payment is updated with a version that does not match the stored version,
+	// meaning someone else has changed the payment since it was read.
+	ErrConflict = errors.New("conflict")
+	// ErrAlreadyExists is returned when creating a payment with the ID of a payment already in the store.
+	ErrAlreadyExists = errors.New("already exists")
+	// ErrInvalid is returned when the request can never succeed, for example a payment without an ID.
+	ErrInvalid = errors.New("invalid")
+)
+
+// notFound returns an error wrapping ErrNotFound for the payment ID.
+func notFound(paymentUID string) error {
+	return fmt.Errorf("payment with ID: %s %w", paymentUID, ErrNotFound)
+}
+
+// alreadyExists returns an error wrapping ErrAlreadyExists for the payment ID.
+func alreadyExists(paymentUID string) error {
+	return fmt.Errorf("payment with ID: %s %w", paymentUID, ErrAlreadyExists)
+}
+
+// versionConflict returns an error wrapping ErrConflict describing the mismatched versions.
+func versionConflict(payment *api.Payment, storedVersion int) error {
+	return fmt.Errorf("payment with ID: %s is at version %d but version %d was given: %w",
+		payment.ID, storedVersion, payment.Version, ErrConflict)
+}
+
+// checkPayment returns an error wrapping ErrInvalid if the payment is missing or, when requireID is set, has no ID.
+func checkPayment(payment *api.Payment, requireID bool) error {
+	if payment == nil {
+		return fmt.Errorf("payment is missing: %w", ErrInvalid)
+	}
+	if requireID {
+		return checkID(payment.ID)
+	}
+
+	return nil
+}
+
+// checkID returns an error wrapping ErrInvalid if the payment ID is empty.
+func checkID(paymentUID string) error {
+	if paymentUID == "" {
+		return fmt.Errorf("payment ID is missing: %w", ErrInvalid)
+	}
+
+	return nil
+}
@@ -0,0 +1,428 @@
+package persist
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/cdempsie/payments-example/api"
+	"github.com/cdempsie/payments-example/logging"
+	"github.com/google/uuid"
+)
+
+const (
+	// walFileName is the name of the write-ahead log within the data directory.
+	walFileName = "payments.wal"
+	// snapshotFileName is the name of the compacted snapshot within the data directory.
+	snapshotFileName = "payments.snapshot"
+	// DefaultCompactAfter is the number of log entries written before the log is compacted into a snapshot.
+	DefaultCompactAfter = 1000
+)
+
+// walOp is the type of change recorded in a write-ahead log entry.
+type walOp string
+
+const (
+	walCreate walOp = "create"
+	walUpdate walOp = "update"
+	walDelete walOp = "delete"
+)
+
+// walEntry is a single line in the write-ahead log.
+type walEntry struct {
+	Op      walOp        `json:"op"`
+	ID      string       `json:"id"`
+	Payment *api.Payment `json:"payment,omitempty"`
+}
+
+// FileStore provides a payment store that survives server restarts.
+// Every change is appended to a write-ahead log on disk before it is applied in memory and the log is replayed when
+// the store is opened. Once the log reaches a configured number of entries it is compacted into a snapshot so that it
+// does not grow without bound.
+type FileStore struct {
+	dir          string
+	data         map[string]*api.Payment
+	lock         sync.RWMutex
+	wal          *os.File
+	walEntries   int
+	compactAfter int
+}
+
+// NewFileStore opens, or creates, a file store in the given directory replaying any existing snapshot and log.
+// The log is compacted after compactAfter entries, a value less than 1 uses DefaultCompactAfter.
+func NewFileStore(dir string, compactAfter int) (*FileStore, error) {
+	if compactAfter < 1 {
+		compactAfter = DefaultCompactAfter
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create data directory %s: %v", dir, err)
+	}
+
+	store := &FileStore{
+		dir:          dir,
+		data:         make(map[string]*api.Payment),
+		compactAfter: compactAfter,
+	}
+	if err := store.loadSnapshot(); err != nil {
+		return nil, err
+	}
+	if err := store.replay(); err != nil {
+		return nil, err
+	}
+
+	return store, nil
+}
+
+// Create creates a new payment in the store, assigning a UUID in the process. The payment starts at version 0.
+func (store *FileStore) Create(ctx context.Context, payment *api.Payment) error {
+	if err := checkPayment(payment, false); err != nil {
+		return err
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if payment.ID == "" {
+		payment.ID = uuid.New().String()
+	}
+	payment.Version = 0
+	store.lock.Lock()
+	defer store.lock.Unlock()
+
+	if _, ok := store.data[payment.ID]; ok {
+		return alreadyExists(payment.ID)
+	}
+
+	if err := store.append(walEntry{Op: walCreate, ID: payment.ID, Payment: payment}); err != nil {
+		return err
+	}
+	store.data[payment.ID] = payment
+	store.compactIfDue(ctx)
+
+	return nil
+}
+
+// Update updates the given payment in the store, incrementing its version.
+// An error is returned if the payment with the given ID could not be found or its version is not the stored version.
+func (store *FileStore) Update(ctx context.Context, payment *api.Payment) error {
+	if err := checkPayment(payment, true); err != nil {
+		return err
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	store.lock.Lock()
+	defer store.lock.Unlock()
+
+	id := payment.ID
+	stored, ok := store.data[id]
+	if !ok {
+		return notFound(id)
+	}
+	if stored.Version != payment.Version {
+		return versionConflict(payment, stored.Version)
+	}
+
+	payment.Version++
+	if err := store.append(walEntry{Op: walUpdate, ID: id, Payment: payment}); err != nil {
+		payment.Version--
+		return err
+	}
+	store.data[id] = payment
+	store.compactIfDue(ctx)
+
+	return nil
+}
+
+// Delete deletes the payment with the given ID.
+// An error is returned if the payment with the given ID could not be found.
+func (store *FileStore) Delete(ctx context.Context, paymentUID string) error {
+	if err := checkID(paymentUID); err != nil {
+		return err
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	store.lock.Lock()
+	defer store.lock.Unlock()
+
+	if _, ok := store.data[paymentUID]; !ok {
+		return notFound(paymentUID)
+	}
+
+	if err := store.append(walEntry{Op: walDelete, ID: paymentUID}); err != nil {
+		return err
+	}
+	delete(store.data, paymentUID)
+	store.compactIfDue(ctx)
+
+	return nil
+}
+
+// Load loads the payment with the given ID.
+// If the payment is not found an error is returned.
+func (store *FileStore) Load(ctx context.Context, paymentUID string) (payment *api.Payment, err error) {
+	if err := checkID(paymentUID); err != nil {
+		return nil, err
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	store.lock.RLock()
+	defer store.lock.RUnlock()
+
+	if payment, ok := store.data[paymentUID]; ok {
+		return payment, nil
+	}
+
+	return nil, notFound(paymentUID)
+}
+
+// List lists the payments in the store matching the query.
+func (store *FileStore) List(ctx context.Context, query ListQuery) (results *api.ListHolder, err error) {
+	if err := checkQuery(query); err != nil {
+		return nil, err
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	store.lock.RLock()
+	defer store.lock.RUnlock()
+
+	return queryPayments(store.data, query), nil
+}
+
+// CountPayments counts the payments in the store that are not deleted by currency and scheme.
+func (store *FileStore) CountPayments(ctx context.Context) ([]PaymentCount, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	store.lock.RLock()
+	defer store.lock.RUnlock()
+
+	return countPayments(store.data), nil
+}
+
+// Compact writes the current state of the store to a snapshot and truncates the write-ahead log.
+func (store *FileStore) Compact() error {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+
+	return store.compact()
+}
+
+// Ping checks the store has not been closed and its write-ahead log can still be written to.
+func (store *FileStore) Ping(ctx context.Context) error {
+	store.lock.RLock()
+	defer store.lock.RUnlock()
+
+	if store.wal == nil {
+		return fmt.Errorf("file store in %s is closed", store.dir)
+	}
+	if _, err := store.wal.Stat(); err != nil {
+		return fmt.Errorf("failed to check the log of the file store in %s: %v", store.dir, err)
+	}
+
+	return nil
+}
+
+// Close closes the write-ahead log. The store must not be used after it has been closed.
+func (store *FileStore) Close() error {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+
+	if store.wal == nil {
+		return nil
+	}
+	err := store.wal.Close()
+	store.wal = nil
+
+	return err
+}
+
+// append writes the entry to the write-ahead log and syncs it to disk. If the write fails anything partly written is
+// cut off so that later entries are not appended after it, which would leave the log unreadable.
+// The caller must hold the write lock.
+func (store *FileStore) append(entry walEntry) error {
+	if store.wal == nil {
+		return fmt.Errorf("file store in %s is closed", store.dir)
+	}
+
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("failed to encode log entry for payment %s: %v", entry.ID, err)
+	}
+	offset, err := store.wal.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return fmt.Errorf("failed to find the end of the log: %v", err)
+	}
+	line = append(line, '\n')
+	if _, err = store.wal.Write(line); err == nil {
+		err = store.wal.Sync()
+	}
+	if err != nil {
+		if truncateErr := store.wal.Truncate(offset); truncateErr == nil {
+			store.wal.Seek(offset, io.SeekStart)
+		}
+		return fmt.Errorf("failed to write log entry for payment %s: %v", entry.ID, err)
+	}
+
+	store.walEntries++
+
+	return nil
+}
+
+// compactIfDue compacts the log once it has grown to the configured number of entries.
+// The change that triggered it is already safely in the log so a failure is logged, with the logger of the context,
+// rather than returned, compaction will be attempted again on the next write. Compaction is not abandoned when the
+// context is done as the change has already been made.
+// The caller must hold the write lock.
+func (store *FileStore) compactIfDue(ctx context.Context) {
+	if store.walEntries < store.compactAfter {
+		return
+	}
+	if err := store.compact(); err != nil {
+		logging.FromContext(ctx).Error("Failed to compact file store", "dir", store.dir, "error", err)
+	}
+}
+
+// compact writes a snapshot of the in memory data and then truncates the write-ahead log.
+// The snapshot is written to a temporary file and renamed into place so a crash never leaves a partial snapshot.
+// The caller must hold the write lock.
+func (store *FileStore) compact() error {
+	payments := make([]*api.Payment, 0, len(store.data))
+	for _, payment := range store.data {
+		payments = append(payments, payment)
+	}
+
+	tmpPath := filepath.Join(store.dir, snapshotFileName+".tmp")
+	tmp, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to create snapshot: %v", err)
+	}
+	if err := json.NewEncoder(tmp).Encode(payments); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write snapshot: %v", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to sync snapshot: %v", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to close snapshot: %v", err)
+	}
+	if err := os.Rename(tmpPath, filepath.Join(store.dir, snapshotFileName)); err != nil {
+		return fmt.Errorf("failed to replace snapshot: %v", err)
+	}
+	// the rename must be on disk before the log is truncated, otherwise a crash could lose both
+	if err := syncDir(store.dir); err != nil {
+		return fmt.Errorf("failed to sync snapshot: %v", err)
+	}
+
+	// Entries in the log are now covered by the snapshot. If we crash before the truncate the replay
+	// simply re-applies them on top of the snapshot which gives the same result.
+	if err := store.wal.Truncate(0); err != nil {
+		return fmt.Errorf("failed to truncate log: %v", err)
+	}
+	if _, err := store.wal.Seek(0, io.SeekStart); err != nil {
+		return fmt.Errorf("failed to rewind log: %v", err)
+	}
+	store.walEntries = 0
+
+	return nil
+}
+
+// syncDir syncs the directory to disk so that files renamed into it survive a crash.
+func syncDir(dir string) error {
+	file, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	return file.Sync()
+}
+
+// loadSnapshot loads the snapshot, if there is one, into memory.
+func (store *FileStore) loadSnapshot() error {
+	file, err := os.Open(filepath.Join(store.dir, snapshotFileName))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to open snapshot: %v", err)
+	}
+	defer file.Close()
+
+	var payments []*api.Payment
+	if err := json.NewDecoder(file).Decode(&payments); err != nil {
+		return fmt.Errorf("failed to read snapshot: %v", err)
+	}
+	for _, payment := range payments {
+		store.data[payment.ID] = payment
+	}
+
+	return nil
+}
+
+// replay applies the entries in the write-ahead log on top of the snapshot and leaves the log open for appending.
+// A partially written final entry, for example from a crash mid write, is discarded.
+func (store *FileStore) replay() error {
+	wal, err := os.OpenFile(filepath.Join(store.dir, walFileName), os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to open log: %v", err)
+	}
+
+	reader := bufio.NewReader(wal)
+	var offset int64
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err == io.EOF {
+			// anything left without a trailing newline is a torn write
+			break
+		}
+		if err != nil {
+			wal.Close()
+			return fmt.Errorf("failed to read log: %v", err)
+		}
+
+		entry := walEntry{}
+		if err := json.Unmarshal(bytes.TrimSpace(line), &entry); err != nil {
+			wal.Close()
+			return fmt.Errorf("corrupt log entry at offset %d: %v", offset, err)
+		}
+		store.apply(entry)
+		offset += int64(len(line))
+		store.walEntries++
+	}
+
+	if err := wal.Truncate(offset); err != nil {
+		wal.Close()
+		return fmt.Errorf("failed to truncate log: %v", err)
+	}
+	if _, err := wal.Seek(offset, io.SeekStart); err != nil {
+		wal.Close()
+		return fmt.Errorf("failed to seek log: %v", err)
+	}
+	store.wal = wal
+
+	return nil
+}
+
+// apply applies a log entry to the in memory data.
+// Entries are applied idempotently so that replaying entries already covered by the snapshot is harmless.
+func (store *FileStore) apply(entry walEntry) {
+	switch entry.Op {
+	case walCreate, walUpdate:
+		if entry.Payment != nil {
+			store.data[entry.ID] = entry.Payment
+		}
+	case walDelete:
+		delete(store.data, entry.ID)
+	}
+}
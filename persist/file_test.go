@@ -0,0 +1,196 @@
+package persist_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/cdempsie/payments-example/persist"
+	"github.com/google/uuid"
+)
+
+func TestFileStoreCRUD(t *testing.T) {
+	store := openFileStore(t, t.TempDir(), 100)
+	defer store.Close()
+	payment := create(t, store)
+
+	result, err := store.Load(context.Background(), payment.ID)
+	if err != nil {
+		t.Fatalf("Failed to load payment from store: %v", err)
+	}
+	if result.ID != payment.ID {
+		t.Fatalf("Expected payment ID %s got %s", payment.ID, result.ID)
+	}
+
+	payment.BeneficiaryParty.Address = "new address"
+	if err := store.Update(context.Background(), payment); err != nil {
+		t.Fatalf("Failed to update payment in store: %v", err)
+	}
+
+	if err := store.Delete(context.Background(), payment.ID); err != nil {
+		t.Fatalf("Failed to delete payment from store: %v", err)
+	}
+	if _, err := store.Load(context.Background(), payment.ID); err == nil {
+		t.Fatalf("Expected error loading deleted payment: %v", payment.ID)
+	}
+}
+
+func TestFileStorePing(t *testing.T) {
+	store := openFileStore(t, t.TempDir(), 100)
+	if err := store.Ping(context.Background()); err != nil {
+		t.Fatalf("Expected open store to be usable: %v", err)
+	}
+
+	store.Close()
+	if err := store.Ping(context.Background()); err == nil {
+		t.Fatalf("Expected closed store not to be usable")
+	}
+}
+
+func TestFileStoreNotFoundID(t *testing.T) {
+	store := openFileStore(t, t.TempDir(), 100)
+	defer store.Close()
+	payment := create(t, store)
+
+	testID := uuid.New().String()
+	if _, err := store.Load(context.Background(), testID); err == nil {
+		t.Fatalf("Expected error loading unknown ID: %v", testID)
+	}
+	if err := store.Delete(context.Background(), testID); err == nil {
+		t.Fatalf("Expected error deleting unknown ID: %v", testID)
+	}
+	payment.ID = testID
+	if err := store.Update(context.Background(), payment); err == nil {
+		t.Fatalf("Expected error updating unknown ID: %v", testID)
+	}
+}
+
+func TestFileStoreUpdateVersion(t *testing.T) {
+	store := openFileStore(t, t.TempDir(), 100)
+	defer store.Close()
+	assertOptimisticConcurrency(t, store)
+}
+
+func TestFileStoreCancelled(t *testing.T) {
+	store := openFileStore(t, t.TempDir(), 100)
+	defer store.Close()
+	assertCancelled(t, store)
+}
+
+func TestFileStoreErrors(t *testing.T) {
+	store := openFileStore(t, t.TempDir(), 100)
+	defer store.Close()
+	assertStoreErrors(t, store)
+}
+
+func TestFileStoreCountPayments(t *testing.T) {
+	store := openFileStore(t, t.TempDir(), 100)
+	defer store.Close()
+	assertCountPayments(t, store)
+}
+
+func TestFileStoreListQuery(t *testing.T) {
+	store := openFileStore(t, t.TempDir(), 100)
+	defer store.Close()
+	assertListQuery(t, store)
+}
+
+func TestFileStoreAmountOrder(t *testing.T) {
+	store := openFileStore(t, t.TempDir(), 100)
+	defer store.Close()
+	assertAmountOrder(t, store)
+}
+
+func TestFileStoreSurvivesRestart(t *testing.T) {
+	dir := t.TempDir()
+	store := openFileStore(t, dir, 100)
+	kept := create(t, store)
+	deleted := create(t, store)
+	kept.BeneficiaryParty.Address = "new address"
+	if err := store.Update(context.Background(), kept); err != nil {
+		t.Fatalf("Failed to update payment in store: %v", err)
+	}
+	if err := store.Delete(context.Background(), deleted.ID); err != nil {
+		t.Fatalf("Failed to delete payment from store: %v", err)
+	}
+	store.Close()
+
+	reopened := openFileStore(t, dir, 100)
+	defer reopened.Close()
+	assertOnlyPayment(t, reopened, kept.ID, "new address")
+}
+
+func TestFileStoreCompaction(t *testing.T) {
+	dir := t.TempDir()
+	store := openFileStore(t, dir, 3)
+	kept := create(t, store)
+	deleted := create(t, store)
+	// the third entry triggers a compaction
+	if err := store.Delete(context.Background(), deleted.ID); err != nil {
+		t.Fatalf("Failed to delete payment from store: %v", err)
+	}
+
+	info, err := os.Stat(filepath.Join(dir, "payments.wal"))
+	if err != nil {
+		t.Fatalf("Failed to stat log: %v", err)
+	}
+	if info.Size() != 0 {
+		t.Fatalf("Expected log to be truncated after compaction but it was %d bytes", info.Size())
+	}
+
+	kept.BeneficiaryParty.Address = "after compaction"
+	if err := store.Update(context.Background(), kept); err != nil {
+		t.Fatalf("Failed to update payment in store: %v", err)
+	}
+	store.Close()
+
+	reopened := openFileStore(t, dir, 3)
+	defer reopened.Close()
+	assertOnlyPayment(t, reopened, kept.ID, "after compaction")
+}
+
+func TestFileStoreIgnoresTornWrite(t *testing.T) {
+	dir := t.TempDir()
+	store := openFileStore(t, dir, 100)
+	payment := create(t, store)
+	store.Close()
+
+	wal, err := os.OpenFile(filepath.Join(dir, "payments.wal"), os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		t.Fatalf("Failed to open log: %v", err)
+	}
+	if _, err := wal.WriteString(`{"op":"delete","id":"` + payment.ID); err != nil {
+		t.Fatalf("Failed to write to log: %v", err)
+	}
+	wal.Close()
+
+	reopened := openFileStore(t, dir, 100)
+	defer reopened.Close()
+	assertOnlyPayment(t, reopened, payment.ID, payment.BeneficiaryParty.Address)
+}
+
+func openFileStore(t *testing.T, dir string, compactAfter int) *persist.FileStore {
+	store, err := persist.NewFileStore(dir, compactAfter)
+	if err != nil {
+		t.Fatalf("Failed to open file store: %v", err)
+	}
+
+	return store
+}
+
+func assertOnlyPayment(t *testing.T, store persist.PaymentStore, paymentID, address string) {
+	results, err := store.List(context.Background(), persist.ListQuery{})
+	if err != nil {
+		t.Fatalf("Failed to list payments: %v", err)
+	}
+	if len(results.Data) != 1 {
+		t.Fatalf("Got %d results when only 1 was expected", len(results.Data))
+	}
+	if results.Data[0].ID != paymentID {
+		t.Fatalf("Payment IDs did not match. Expected %s got: %s", paymentID, results.Data[0].ID)
+	}
+	if results.Data[0].BeneficiaryParty.Address != address {
+		t.Fatalf("Expected address %q got %q", address, results.Data[0].BeneficiaryParty.Address)
+	}
+}
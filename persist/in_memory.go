@@ -1,7 +1,7 @@
 package persist
 
 import (
-	"fmt"
+	"context"
 	"sync"
 
 	"github.com/cdempsie/payments-example/api"
@@ -20,41 +20,70 @@ func NewInMemoryStore() *InMemoryStore {
 	return &InMemoryStore{data: make(map[string]*api.Payment)}
 }
 
-// Create creates a new payment in the store, assigning a UUID in the process.
-func (store *InMemoryStore) Create(payment *api.Payment) error {
+// Create creates a new payment in the store, assigning a UUID in the process. The payment starts at version 0.
+func (store *InMemoryStore) Create(ctx context.Context, payment *api.Payment) error {
+	if err := checkPayment(payment, false); err != nil {
+		return err
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
 	if payment.ID == "" {
 		payment.ID = uuid.New().String()
 	}
+	payment.Version = 0
 	store.lock.Lock()
 	defer store.lock.Unlock()
 
+	if _, ok := store.data[payment.ID]; ok {
+		return alreadyExists(payment.ID)
+	}
 	store.data[payment.ID] = payment
 
 	return nil
 }
 
-// Update updates the given payment in the store.
-// An error is returned if the payment with the given ID could not be found.
-func (store *InMemoryStore) Update(payment *api.Payment) error {
+// Update updates the given payment in the store, incrementing its version.
+// An error is returned if the payment with the given ID could not be found or its version is not the stored version.
+func (store *InMemoryStore) Update(ctx context.Context, payment *api.Payment) error {
+	if err := checkPayment(payment, true); err != nil {
+		return err
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
 	store.lock.Lock()
 	defer store.lock.Unlock()
 
 	id := payment.ID
-	if _, ok := store.data[id]; !ok {
-		return fmt.Errorf("payment with ID: %s not found", id)
+	stored, ok := store.data[id]
+	if !ok {
+		return notFound(id)
+	}
+	if stored.Version != payment.Version {
+		return versionConflict(payment, stored.Version)
 	}
 
+	payment.Version++
 	store.data[id] = payment
 
 	return nil
 }
 
-func (store *InMemoryStore) Delete(paymentUID string) error {
+// Delete deletes the payment with the given ID.
+// An error is returned if the payment with the given ID could not be found.
+func (store *InMemoryStore) Delete(ctx context.Context, paymentUID string) error {
+	if err := checkID(paymentUID); err != nil {
+		return err
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
 	store.lock.Lock()
 	defer store.lock.Unlock()
 
 	if _, ok := store.data[paymentUID]; !ok {
-		return fmt.Errorf("payment with ID: %s not found", paymentUID)
+		return notFound(paymentUID)
 	}
 
 	delete(store.data, paymentUID)
@@ -64,7 +93,13 @@ func (store *InMemoryStore) Delete(paymentUID string) error {
 
 // Load loads the payment with the given ID.
 // If the payment is not found an error is returned.
-func (store *InMemoryStore) Load(paymentUID string) (payment *api.Payment, err error) {
+func (store *InMemoryStore) Load(ctx context.Context, paymentUID string) (payment *api.Payment, err error) {
+	if err := checkID(paymentUID); err != nil {
+		return nil, err
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
 	store.lock.RLock()
 	defer store.lock.RUnlock()
 
@@ -72,19 +107,30 @@ func (store *InMemoryStore) Load(paymentUID string) (payment *api.Payment, err e
 		return payment, nil
 	}
 
-	return nil, fmt.Errorf("payment with ID: %s not found", paymentUID)
+	return nil, notFound(paymentUID)
 }
 
-// List lists all the payments currently in the store.
-func (store *InMemoryStore) List() (results *api.ListHolder, err error) {
+// CountPayments counts the payments in the store that are not deleted by currency and scheme.
+func (store *InMemoryStore) CountPayments(ctx context.Context) ([]PaymentCount, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
 	store.lock.RLock()
 	defer store.lock.RUnlock()
 
-	result := &api.ListHolder{}
+	return countPayments(store.data), nil
+}
 
-	for _, payment := range store.data {
-		result.Data = append(result.Data, *payment)
+// List lists the payments in the store matching the query.
+func (store *InMemoryStore) List(ctx context.Context, query ListQuery) (results *api.ListHolder, err error) {
+	if err := checkQuery(query); err != nil {
+		return nil, err
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
 	}
+	store.lock.RLock()
+	defer store.lock.RUnlock()
 
-	return result, nil
+	return queryPayments(store.data, query), nil
 }
@@ -1,11 +1,16 @@
 package persist_test
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
+	"reflect"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/cdempsie/payments-example/api"
+	"github.com/cdempsie/payments-example/money"
 	"github.com/cdempsie/payments-example/persist"
 	"github.com/cdempsie/payments-example/test"
 	"github.com/google/uuid"
@@ -19,7 +24,7 @@ func TestCreate(t *testing.T) {
 	if err != nil {
 		t.Fatalf("Failed to decode JSON: %v", err)
 	}
-	err = store.Create(payment)
+	err = store.Create(context.Background(), payment)
 	if err != nil {
 		t.Fatalf("Failed to create payment in store: %v", err)
 	}
@@ -33,7 +38,7 @@ func TestLoad(t *testing.T) {
 	store := persist.NewInMemoryStore()
 	payment := create(t, store)
 
-	result, err := store.Load(payment.ID)
+	result, err := store.Load(context.Background(), payment.ID)
 	if err != nil {
 		t.Fatalf("Failed to load payment from store: %v", err)
 	}
@@ -52,7 +57,7 @@ func TestLoadNotFoundID(t *testing.T) {
 	create(t, store)
 
 	testID := uuid.New().String()
-	_, err := store.Load(testID)
+	_, err := store.Load(context.Background(), testID)
 	if err == nil {
 		t.Fatalf("Expected error for unknown ID: %v", testID)
 	}
@@ -63,7 +68,7 @@ func TestUpdate(t *testing.T) {
 	payment := create(t, store)
 
 	payment.BeneficiaryParty.Address = "new address"
-	err := store.Update(payment)
+	err := store.Update(context.Background(), payment)
 	if err != nil {
 		t.Fatalf("Failed to load payment from store: %v", err)
 	}
@@ -75,17 +80,29 @@ func TestUpdateNotFoundID(t *testing.T) {
 
 	// set ID to a new val
 	payment.ID = uuid.New().String()
-	err := store.Update(payment)
+	err := store.Update(context.Background(), payment)
 	if err == nil {
 		t.Fatalf("Expected error for unknown ID: %v", payment.ID)
 	}
 }
 
+func TestUpdateVersion(t *testing.T) {
+	assertOptimisticConcurrency(t, persist.NewInMemoryStore())
+}
+
+func TestStoreErrors(t *testing.T) {
+	assertStoreErrors(t, persist.NewInMemoryStore())
+}
+
+func TestStoreCancelled(t *testing.T) {
+	assertCancelled(t, persist.NewInMemoryStore())
+}
+
 func TestDelete(t *testing.T) {
 	store := persist.NewInMemoryStore()
 	payment := create(t, store)
 
-	err := store.Delete(payment.ID)
+	err := store.Delete(context.Background(), payment.ID)
 	if err != nil {
 		t.Fatalf("Failed to delete payment from store: %v", err)
 	}
@@ -96,7 +113,7 @@ func TestDeleteNotFoundID(t *testing.T) {
 
 	// set ID to a new val
 	testID := uuid.New().String()
-	err := store.Delete(testID)
+	err := store.Delete(context.Background(), testID)
 	if err == nil {
 		t.Fatalf("Expected error for unknown ID: %v", testID)
 	}
@@ -106,7 +123,7 @@ func TestList(t *testing.T) {
 	store := persist.NewInMemoryStore()
 	payment := create(t, store)
 
-	results, err := store.List()
+	results, err := store.List(context.Background(), persist.ListQuery{})
 	if err != nil {
 		t.Fatalf("Failed to delete payment from store: %v", err)
 	}
@@ -120,17 +137,273 @@ func TestList(t *testing.T) {
 	}
 }
 
+func TestListQuery(t *testing.T) {
+	assertListQuery(t, persist.NewInMemoryStore())
+}
+
+func TestAmountOrder(t *testing.T) {
+	assertAmountOrder(t, persist.NewInMemoryStore())
+}
+
+func TestCountPayments(t *testing.T) {
+	assertCountPayments(t, persist.NewInMemoryStore())
+}
+
 func create(t *testing.T, store persist.PaymentStore) *api.Payment {
+	payment := decode(t)
+	payment.CreatedBy = "tester"
+	err := store.Create(context.Background(), payment)
+	if err != nil {
+		t.Fatalf("Failed to create payment in store: %v", err)
+	}
+
+	return payment
+}
+
+func decode(t *testing.T) *api.Payment {
 	dec := json.NewDecoder(strings.NewReader(test.CreatePayment))
 	payment := &api.Payment{}
 	err := dec.Decode(payment)
 	if err != nil {
 		t.Fatalf("Failed to decode JSON: %v", err)
 	}
-	err = store.Create(payment)
+
+	return payment
+}
+
+// assertOptimisticConcurrency checks the store increments the version on update and rejects stale versions.
+func assertOptimisticConcurrency(t *testing.T, store persist.PaymentStore) {
+	payment := create(t, store)
+	if payment.Version != 0 {
+		t.Fatalf("Expected new payment to be at version 0 but was %d", payment.Version)
+	}
+
+	if err := store.Update(context.Background(), payment); err != nil {
+		t.Fatalf("Failed to update payment in store: %v", err)
+	}
+	if payment.Version != 1 {
+		t.Fatalf("Expected updated payment to be at version 1 but was %d", payment.Version)
+	}
+
+	stale := *payment
+	stale.Version = 0
+	err := store.Update(context.Background(), &stale)
+	if !errors.Is(err, persist.ErrConflict) {
+		t.Fatalf("Expected conflict updating stale version but got: %v", err)
+	}
+
+	result, err := store.Load(context.Background(), payment.ID)
+	if err != nil {
+		t.Fatalf("Failed to load payment from store: %v", err)
+	}
+	if result.Version != 1 {
+		t.Fatalf("Expected stored payment to be at version 1 but was %d", result.Version)
+	}
+}
+
+// assertStoreErrors checks the store returns the typed persist errors for each kind of failure.
+func assertStoreErrors(t *testing.T, store persist.PaymentStore) {
+	payment := create(t, store)
+
+	duplicate := *payment
+	if err := store.Create(context.Background(), &duplicate); !errors.Is(err, persist.ErrAlreadyExists) {
+		t.Fatalf("Expected already exists creating duplicate ID but got: %v", err)
+	}
+
+	testID := uuid.New().String()
+	if _, err := store.Load(context.Background(), testID); !errors.Is(err, persist.ErrNotFound) {
+		t.Fatalf("Expected not found loading unknown ID but got: %v", err)
+	}
+	if err := store.Delete(context.Background(), testID); !errors.Is(err, persist.ErrNotFound) {
+		t.Fatalf("Expected not found deleting unknown ID but got: %v", err)
+	}
+	unknown := *payment
+	unknown.ID = testID
+	if err := store.Update(context.Background(), &unknown); !errors.Is(err, persist.ErrNotFound) {
+		t.Fatalf("Expected not found updating unknown ID but got: %v", err)
+	}
+
+	if _, err := store.Load(context.Background(), ""); !errors.Is(err, persist.ErrInvalid) {
+		t.Fatalf("Expected invalid loading empty ID but got: %v", err)
+	}
+	if err := store.Create(context.Background(), nil); !errors.Is(err, persist.ErrInvalid) {
+		t.Fatalf("Expected invalid creating nil payment but got: %v", err)
+	}
+}
+
+// assertCancelled checks the store gives up on every operation once the context is done, leaving the payments as they
+// were.
+func assertCancelled(t *testing.T, store persist.PaymentStore) {
+	payment := create(t, store)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if err := store.Create(ctx, decode(t)); !errors.Is(err, context.Canceled) {
+		t.Fatalf("Expected create to be cancelled but got: %v", err)
+	}
+	update := *payment
+	if err := store.Update(ctx, &update); !errors.Is(err, context.Canceled) {
+		t.Fatalf("Expected update to be cancelled but got: %v", err)
+	}
+	if err := store.Delete(ctx, payment.ID); !errors.Is(err, context.Canceled) {
+		t.Fatalf("Expected delete to be cancelled but got: %v", err)
+	}
+	if _, err := store.Load(ctx, payment.ID); !errors.Is(err, context.Canceled) {
+		t.Fatalf("Expected load to be cancelled but got: %v", err)
+	}
+	if _, err := store.List(ctx, persist.ListQuery{}); !errors.Is(err, context.Canceled) {
+		t.Fatalf("Expected list to be cancelled but got: %v", err)
+	}
+
+	list, err := store.List(context.Background(), persist.ListQuery{})
 	if err != nil {
+		t.Fatalf("Failed to list payments: %v", err)
+	}
+	if len(list.Data) != 1 || list.Data[0].Version != payment.Version {
+		t.Errorf("Expected the payment to be left as it was but got: %+v", list.Data)
+	}
+}
+
+// assertListQuery checks the store filters, sorts and pages payments and leaves out soft deleted payments unless
+// asked for them.
+func assertListQuery(t *testing.T, store persist.PaymentStore) {
+	amounts := []string{"10.00", "2.50", "100.21", "7.00", "1.00"}
+	dates := []string{"2017-01-18", "2017-01-20", "2017-01-19", "2017-01-21", "2017-01-22"}
+	deletedAt := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	var ids []string
+	for i, amount := range amounts {
+		payment := decode(t)
+		payment.Amount = money.MustParse(amount)
+		payment.ProcessingDate = dates[i]
+		if i == 3 {
+			payment.Currency = "USD"
+		}
+		if i == 4 {
+			payment.DeletedAt, payment.DeletedBy = &deletedAt, "tester"
+		}
+		if err := store.Create(context.Background(), payment); err != nil {
+			t.Fatalf("Failed to create payment in store: %v", err)
+		}
+		ids = append(ids, payment.ID)
+	}
+
+	tests := []struct {
+		name     string
+		query    persist.ListQuery
+		expected []string
+		total    int
+	}{
+		{"by amount", persist.ListQuery{Sort: persist.SortByAmount}, []string{ids[1], ids[3], ids[0], ids[2]}, 4},
+		{"by date descending", persist.ListQuery{Sort: persist.SortByProcessingDate, Descending: true},
+			[]string{ids[3], ids[1], ids[2], ids[0]}, 4},
+		{"currency filter", persist.ListQuery{Sort: persist.SortByAmount, Filter: persist.ListFilter{Currency: "GBP"}},
+			[]string{ids[1], ids[0], ids[2]}, 3},
+		{"date range", persist.ListQuery{Sort: persist.SortByProcessingDate,
+			Filter: persist.ListFilter{ProcessingDateFrom: "2017-01-19", ProcessingDateTo: "2017-01-20"}},
+			[]string{ids[2], ids[1]}, 2},
+		{"second page", persist.ListQuery{Sort: persist.SortByAmount, Offset: 2, Limit: 1}, []string{ids[0]}, 4},
+		{"past the end", persist.ListQuery{Offset: 10, Limit: 2}, []string{}, 4},
+		{"include deleted", persist.ListQuery{Sort: persist.SortByAmount,
+			Filter: persist.ListFilter{IncludeDeleted: true}}, []string{ids[4], ids[1], ids[3], ids[0], ids[2]}, 5},
+		{"deleted before", persist.ListQuery{Filter: persist.ListFilter{DeletedBefore: deletedAt.Add(time.Hour)}},
+			[]string{ids[4]}, 1},
+		{"not deleted before", persist.ListQuery{Filter: persist.ListFilter{DeletedBefore: deletedAt}}, []string{}, 0},
+	}
+
+	for _, tc := range tests {
+		results, err := store.List(context.Background(), tc.query)
+		if err != nil {
+			t.Fatalf("%s: Failed to list payments: %v", tc.name, err)
+		}
+		var got []string
+		for _, payment := range results.Data {
+			got = append(got, payment.ID)
+		}
+		if len(got) != len(tc.expected) {
+			t.Fatalf("%s: Expected payments %v got %v", tc.name, tc.expected, got)
+		}
+		for i := range got {
+			if got[i] != tc.expected[i] {
+				t.Fatalf("%s: Expected payments %v got %v", tc.name, tc.expected, got)
+			}
+		}
+		if results.Meta == nil || results.Meta.TotalCount != tc.total {
+			t.Fatalf("%s: Expected total count %d got %v", tc.name, tc.total, results.Meta)
+		}
+	}
+
+	_, err := store.List(context.Background(), persist.ListQuery{Sort: "reference"})
+	if !errors.Is(err, persist.ErrInvalid) {
+		t.Fatalf("Expected invalid sorting by unknown field but got: %v", err)
+	}
+}
+
+// assertAmountOrder checks the store sorts payments by amount the same as money.Decimal.Cmp compares them, including
+// negative amounts, amounts with different scales and amounts too close together to tell apart as floating point.
+func assertAmountOrder(t *testing.T, store persist.PaymentStore) {
+	amounts := []string{"9007199254740993", "-2", "5.1", "9007199254740992.5", "-100.5", "0.000000000000000001", "0",
+		"5.00", "-1.999", "9223372036854775807"}
+	var ids []string
+	for _, amount := range amounts {
+		payment := decode(t)
+		payment.Amount = money.MustParse(amount)
+		if err := store.Create(context.Background(), payment); err != nil {
+			t.Fatalf("Failed to create payment in store: %v", err)
+		}
+		ids = append(ids, payment.ID)
+	}
+	ascending := []string{ids[4], ids[1], ids[8], ids[6], ids[5], ids[7], ids[2], ids[3], ids[0], ids[9]}
+
+	for _, descending := range []bool{false, true} {
+		results, err := store.List(context.Background(),
+			persist.ListQuery{Sort: persist.SortByAmount, Descending: descending})
+		if err != nil {
+			t.Fatalf("Failed to list payments: %v", err)
+		}
+		if len(results.Data) != len(ascending) {
+			t.Fatalf("Expected %d payments but got %d", len(ascending), len(results.Data))
+		}
+		for i, payment := range results.Data {
+			expected := ascending[i]
+			if descending {
+				expected = ascending[len(ascending)-1-i]
+			}
+			if payment.ID != expected {
+				t.Fatalf("Expected payment %d sorted descending %v to be %s but got %s with amount %s", i, descending,
+					expected, payment.ID, payment.Amount)
+			}
+		}
+	}
+}
+
+// assertCountPayments checks the store counts its payments by currency and scheme, leaving out deleted ones.
+func assertCountPayments(t *testing.T, store interface {
+	persist.PaymentStore
+	persist.PaymentCounter
+}) {
+	create(t, store)
+	create(t, store)
+	other := decode(t)
+	other.Currency, other.PaymentScheme = "USD", "SWIFT"
+	if err := store.Create(context.Background(), other); err != nil {
 		t.Fatalf("Failed to create payment in store: %v", err)
 	}
+	deleted := create(t, store)
+	deletedAt := time.Now()
+	deleted.DeletedAt = &deletedAt
+	if err := store.Update(context.Background(), deleted); err != nil {
+		t.Fatalf("Failed to delete payment: %v", err)
+	}
 
-	return payment
+	counts, err := store.CountPayments(context.Background())
+	if err != nil {
+		t.Fatalf("Failed to count payments: %v", err)
+	}
+	expected := []persist.PaymentCount{
+		{Currency: "GBP", PaymentScheme: "FPS", Count: 2},
+		{Currency: "USD", PaymentScheme: "SWIFT", Count: 1},
+	}
+	if !reflect.DeepEqual(counts, expected) {
+		t.Errorf("Expected counts %+v but got %+v", expected, counts)
+	}
 }
@@ -0,0 +1,77 @@
+package persist
+
+import (
+	"context"
+	"time"
+
+	"github.com/cdempsie/payments-example/api"
+)
+
+// The names of the PaymentStore operations reported by InstrumentedStore.
+const (
+	OperationCreate = "create"
+	OperationUpdate = "update"
+	OperationDelete = "delete"
+	OperationLoad   = "load"
+	OperationList   = "list"
+)
+
+// Observer is told how long each operation on a store took and the error it returned, if any.
+type Observer func(operation string, duration time.Duration, err error)
+
+// InstrumentedStore reports how long each operation on a PaymentStore takes and whether it failed, so that the store
+// can be monitored.
+type InstrumentedStore struct {
+	store   PaymentStore
+	observe Observer
+}
+
+// NewInstrumentedStore returns a store that passes each operation on to the store and reports it to the observer.
+func NewInstrumentedStore(store PaymentStore, observe Observer) *InstrumentedStore {
+	return &InstrumentedStore{store: store, observe: observe}
+}
+
+// Create creates the payment in the store.
+func (store *InstrumentedStore) Create(ctx context.Context, payment *api.Payment) error {
+	start := time.Now()
+	err := store.store.Create(ctx, payment)
+	store.observe(OperationCreate, time.Since(start), err)
+
+	return err
+}
+
+// Update updates the payment in the store.
+func (store *InstrumentedStore) Update(ctx context.Context, payment *api.Payment) error {
+	start := time.Now()
+	err := store.store.Update(ctx, payment)
+	store.observe(OperationUpdate, time.Since(start), err)
+
+	return err
+}
+
+// Delete deletes the payment with the given ID from the store.
+func (store *InstrumentedStore) Delete(ctx context.Context, paymentUID string) error {
+	start := time.Now()
+	err := store.store.Delete(ctx, paymentUID)
+	store.observe(OperationDelete, time.Since(start), err)
+
+	return err
+}
+
+// Load loads the payment with the given ID from the store.
+func (store *InstrumentedStore) Load(ctx context.Context, paymentUID string) (*api.Payment, error) {
+	start := time.Now()
+	payment, err := store.store.Load(ctx, paymentUID)
+	store.observe(OperationLoad, time.Since(start), err)
+
+	return payment, err
+}
+
+// List lists the payments in the store matching the query.
+func (store *InstrumentedStore) List(ctx context.Context, query ListQuery) (*api.ListHolder, error) {
+	start := time.Now()
+	results, err := store.store.List(ctx, query)
+	store.observe(OperationList, time.Since(start), err)
+
+	return results, err
+}
@@ -0,0 +1,50 @@
+package persist_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/cdempsie/payments-example/persist"
+	"github.com/google/uuid"
+)
+
+func TestInstrumentedStore(t *testing.T) {
+	type observation struct {
+		operation string
+		err       error
+	}
+	var observed []observation
+	store := persist.NewInstrumentedStore(persist.NewInMemoryStore(),
+		func(operation string, duration time.Duration, err error) {
+			if duration < 0 {
+				t.Errorf("Expected %s to take a positive time but got %v", operation, duration)
+			}
+			observed = append(observed, observation{operation, err})
+		})
+
+	payment := create(t, store)
+	if _, err := store.Load(context.Background(), payment.ID); err != nil {
+		t.Fatalf("Failed to load payment from store: %v", err)
+	}
+	if _, err := store.Load(context.Background(), uuid.New().String()); !errors.Is(err, persist.ErrNotFound) {
+		t.Fatalf("Expected unknown payment not to be found but got: %v", err)
+	}
+	if _, err := store.List(context.Background(), persist.ListQuery{}); err != nil {
+		t.Fatalf("Failed to list payments: %v", err)
+	}
+
+	expected := []string{persist.OperationCreate, persist.OperationLoad, persist.OperationLoad, persist.OperationList}
+	if len(observed) != len(expected) {
+		t.Fatalf("Expected operations %v but got %+v", expected, observed)
+	}
+	for i, operation := range expected {
+		if observed[i].operation != operation {
+			t.Errorf("Expected operation %d to be %s but got %s", i, operation, observed[i].operation)
+		}
+	}
+	if observed[1].err != nil || !errors.Is(observed[2].err, persist.ErrNotFound) {
+		t.Errorf("Expected only the load of the unknown payment to fail but got %+v", observed)
+	}
+}
@@ -0,0 +1,49 @@
+CREATE TABLE payments (
+    id                      TEXT PRIMARY KEY,
+    type                    TEXT NOT NULL,
+    version                 INTEGER NOT NULL,
+    organisation_id         TEXT NOT NULL,
+    amount                  TEXT NOT NULL,
+    currency                TEXT NOT NULL,
+    end_to_end_reference    TEXT NOT NULL,
+    numeric_reference       TEXT NOT NULL,
+    payment_id              TEXT NOT NULL,
+    payment_purpose         TEXT NOT NULL,
+    payment_scheme          TEXT NOT NULL,
+    payment_type            TEXT NOT NULL,
+    processing_date         TEXT NOT NULL,
+    reference               TEXT NOT NULL,
+    scheme_payment_sub_type TEXT NOT NULL,
+    scheme_payment_type     TEXT NOT NULL,
+    bearer_code             TEXT NOT NULL,
+    receiver_charges_amount TEXT NOT NULL,
+    receiver_charges_currency TEXT NOT NULL,
+    fx_contract_reference   TEXT NOT NULL,
+    fx_exchange_rate        TEXT NOT NULL,
+    fx_original_amount      TEXT NOT NULL,
+    fx_original_currency    TEXT NOT NULL
+);
+
+CREATE INDEX payments_organisation_id ON payments (organisation_id);
+
+CREATE TABLE payment_parties (
+    payment_id          TEXT NOT NULL REFERENCES payments (id),
+    role                TEXT NOT NULL,
+    account_name        TEXT NOT NULL,
+    account_number      TEXT NOT NULL,
+    account_number_code TEXT NOT NULL,
+    account_type        INTEGER NOT NULL,
+    address             TEXT NOT NULL,
+    bank_id             TEXT NOT NULL,
+    bank_id_code        TEXT NOT NULL,
+    name                TEXT NOT NULL,
+    PRIMARY KEY (payment_id, role)
+);
+
+CREATE TABLE payment_sender_charges (
+    payment_id TEXT NOT NULL REFERENCES payments (id),
+    position   INTEGER NOT NULL,
+    amount     TEXT NOT NULL,
+    currency   TEXT NOT NULL,
+    PRIMARY KEY (payment_id, position)
+);
@@ -0,0 +1,12 @@
+ALTER TABLE payments ADD COLUMN status TEXT NOT NULL DEFAULT '';
+
+CREATE TABLE payment_status_changes (
+    payment_id  TEXT NOT NULL REFERENCES payments (id),
+    position    INTEGER NOT NULL,
+    from_status TEXT NOT NULL,
+    to_status   TEXT NOT NULL,
+    actor       TEXT NOT NULL,
+    changed_at  TEXT NOT NULL,
+    reason      TEXT NOT NULL,
+    PRIMARY KEY (payment_id, position)
+);
@@ -0,0 +1,5 @@
+ALTER TABLE payments ADD COLUMN deleted_at TEXT NOT NULL DEFAULT '';
+
+ALTER TABLE payments ADD COLUMN deleted_by TEXT NOT NULL DEFAULT '';
+
+CREATE INDEX payments_deleted_at ON payments (deleted_at);
@@ -0,0 +1 @@
+ALTER TABLE payments ADD COLUMN created_by TEXT NOT NULL DEFAULT '';
@@ -0,0 +1,3 @@
+ALTER TABLE payments ADD COLUMN amount_key TEXT NOT NULL DEFAULT '';
+
+CREATE INDEX payments_amount_key ON payments (amount_key);
@@ -3,20 +3,22 @@
 package mocks
 
 import api "github.com/cdempsie/payments-example/api"
+import context "context"
 import mock "github.com/stretchr/testify/mock"
+import persist "github.com/cdempsie/payments-example/persist"
 
 // PaymentStore is an autogenerated mock type for the PaymentStore type
 type PaymentStore struct {
 	mock.Mock
 }
 
-// Create provides a mock function with given fields: payment
-func (_m *PaymentStore) Create(payment *api.Payment) error {
-	ret := _m.Called(payment)
+// Create provides a mock function with given fields: ctx, payment
+func (_m *PaymentStore) Create(ctx context.Context, payment *api.Payment) error {
+	ret := _m.Called(ctx, payment)
 
 	var r0 error
-	if rf, ok := ret.Get(0).(func(*api.Payment) error); ok {
-		r0 = rf(payment)
+	if rf, ok := ret.Get(0).(func(context.Context, *api.Payment) error); ok {
+		r0 = rf(ctx, payment)
 	} else {
 		r0 = ret.Error(0)
 	}
@@ -24,13 +26,13 @@ func (_m *PaymentStore) Create(payment *api.Payment) error {
 	return r0
 }
 
-// Delete provides a mock function with given fields: paymentUID
-func (_m *PaymentStore) Delete(paymentUID string) error {
-	ret := _m.Called(paymentUID)
+// Delete provides a mock function with given fields: ctx, paymentUID
+func (_m *PaymentStore) Delete(ctx context.Context, paymentUID string) error {
+	ret := _m.Called(ctx, paymentUID)
 
 	var r0 error
-	if rf, ok := ret.Get(0).(func(string) error); ok {
-		r0 = rf(paymentUID)
+	if rf, ok := ret.Get(0).(func(context.Context, string) error); ok {
+		r0 = rf(ctx, paymentUID)
 	} else {
 		r0 = ret.Error(0)
 	}
@@ -38,13 +40,13 @@ func (_m *PaymentStore) Delete(paymentUID string) error {
 	return r0
 }
 
-// List provides a mock function with given fields:
-func (_m *PaymentStore) List() (*api.ListHolder, error) {
-	ret := _m.Called()
+// List provides a mock function with given fields: ctx, query
+func (_m *PaymentStore) List(ctx context.Context, query persist.ListQuery) (*api.ListHolder, error) {
+	ret := _m.Called(ctx, query)
 
 	var r0 *api.ListHolder
-	if rf, ok := ret.Get(0).(func() *api.ListHolder); ok {
-		r0 = rf()
+	if rf, ok := ret.Get(0).(func(context.Context, persist.ListQuery) *api.ListHolder); ok {
+		r0 = rf(ctx, query)
 	} else {
 		if ret.Get(0) != nil {
 			r0 = ret.Get(0).(*api.ListHolder)
@@ -52,8 +54,8 @@ func (_m *PaymentStore) List() (*api.ListHolder, error) {
 	}
 
 	var r1 error
-	if rf, ok := ret.Get(1).(func() error); ok {
-		r1 = rf()
+	if rf, ok := ret.Get(1).(func(context.Context, persist.ListQuery) error); ok {
+		r1 = rf(ctx, query)
 	} else {
 		r1 = ret.Error(1)
 	}
@@ -61,13 +63,13 @@ func (_m *PaymentStore) List() (*api.ListHolder, error) {
 	return r0, r1
 }
 
-// Load provides a mock function with given fields: paymentUID
-func (_m *PaymentStore) Load(paymentUID string) (*api.Payment, error) {
-	ret := _m.Called(paymentUID)
+// Load provides a mock function with given fields: ctx, paymentUID
+func (_m *PaymentStore) Load(ctx context.Context, paymentUID string) (*api.Payment, error) {
+	ret := _m.Called(ctx, paymentUID)
 
 	var r0 *api.Payment
-	if rf, ok := ret.Get(0).(func(string) *api.Payment); ok {
-		r0 = rf(paymentUID)
+	if rf, ok := ret.Get(0).(func(context.Context, string) *api.Payment); ok {
+		r0 = rf(ctx, paymentUID)
 	} else {
 		if ret.Get(0) != nil {
 			r0 = ret.Get(0).(*api.Payment)
@@ -75,8 +77,8 @@ func (_m *PaymentStore) Load(paymentUID string) (*api.Payment, error) {
 	}
 
 	var r1 error
-	if rf, ok := ret.Get(1).(func(string) error); ok {
-		r1 = rf(paymentUID)
+	if rf, ok := ret.Get(1).(func(context.Context, string) error); ok {
+		r1 = rf(ctx, paymentUID)
 	} else {
 		r1 = ret.Error(1)
 	}
@@ -84,13 +86,13 @@ func (_m *PaymentStore) Load(paymentUID string) (*api.Payment, error) {
 	return r0, r1
 }
 
-// Update provides a mock function with given fields: payment
-func (_m *PaymentStore) Update(payment *api.Payment) error {
-	ret := _m.Called(payment)
+// Update provides a mock function with given fields: ctx, payment
+func (_m *PaymentStore) Update(ctx context.Context, payment *api.Payment) error {
+	ret := _m.Called(ctx, payment)
 
 	var r0 error
-	if rf, ok := ret.Get(0).(func(*api.Payment) error); ok {
-		r0 = rf(payment)
+	if rf, ok := ret.Get(0).(func(context.Context, *api.Payment) error); ok {
+		r0 = rf(ctx, payment)
 	} else {
 		r0 = ret.Error(0)
 	}
@@ -1,12 +1,52 @@
 package persist
 
-import "github.com/cdempsie/payments-example/api"
+import (
+	"context"
+
+	"github.com/cdempsie/payments-example/api"
+)
 
 // PaymentStore defines the methods a persistent store must provide.
+//
+// Stores own the payment version. Create starts a payment at version 0 and each successful Update increments it.
+//
+// Failures must wrap one of the errors in errors.go where one applies: ErrNotFound for an unknown ID, ErrConflict when
+// Update is given a version that is not the stored version, ErrAlreadyExists when Create is given the ID of a stored
+// payment and ErrInvalid for requests that can never succeed. Anything else is treated as an internal failure.
+//
+// Every method takes the context of the request it is made for. Stores should give up once the context is done,
+// returning an error wrapping the context's error, so that work for a client that has gone away or a request that has
+// run out of time is not carried on with.
+//
+// List returns the page of payments described by the query along with the total number of matching payments in
+// the result's Meta. Links are left for the caller to fill in.
 type PaymentStore interface {
-	Create(payment *api.Payment) error
-	Update(payment *api.Payment) error
-	Delete(paymentUID string) error
-	Load(paymentUID string) (payment *api.Payment, err error)
-	List() (results *api.ListHolder, err error)
+	Create(ctx context.Context, payment *api.Payment) error
+	Update(ctx context.Context, payment *api.Payment) error
+	Delete(ctx context.Context, paymentUID string) error
+	Load(ctx context.Context, paymentUID string) (payment *api.Payment, err error)
+	List(ctx context.Context, query ListQuery) (results *api.ListHolder, err error)
+}
+
+// HealthChecker is implemented by stores that can report whether they are usable, such as those depending on a
+// database connection or files on disk. Stores that can not become unusable, like the in-memory store, need not
+// implement it.
+type HealthChecker interface {
+	// Ping returns an error if the store can not currently serve requests.
+	Ping(ctx context.Context) error
+}
+
+// PaymentCount is how many payments there are of a currency and payment scheme.
+type PaymentCount struct {
+	Currency      string
+	PaymentScheme string
+	Count         int
+}
+
+// PaymentCounter is implemented by stores that can count their payments without reading each of them back, such as
+// those holding them in memory or in a database that counts them itself.
+type PaymentCounter interface {
+	// CountPayments returns how many payments there are of each currency and payment scheme, ordered by currency and
+	// then scheme. Soft deleted payments are not counted.
+	CountPayments(ctx context.Context) ([]PaymentCount, error)
 }
@@ -0,0 +1,174 @@
+package persist
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/cdempsie/payments-example/api"
+)
+
+// SortField is a field payments can be listed in order of.
+type SortField string
+
+// The fields payments can be sorted by. Payments with equal values are always ordered by ID so pages are stable.
+const (
+	SortByID             SortField = "id"
+	SortByProcessingDate SortField = "processing_date"
+	SortByAmount         SortField = "amount"
+)
+
+// ListQuery describes which payments List returns and in what order.
+// The zero value lists every payment ordered by ID.
+type ListQuery struct {
+	Filter     ListFilter
+	Sort       SortField
+	Descending bool
+	// Offset is the number of matching payments to skip.
+	Offset int
+	// Limit is the maximum number of payments to return, 0 means no limit.
+	Limit int
+}
+
+// ListFilter restricts the payments returned by List. Empty fields match every payment, apart from soft deleted
+// payments which are only matched when asked for.
+type ListFilter struct {
+	OrganisationID string
+	Currency       string
+	PaymentScheme  string
+	PaymentType    string
+	// ProcessingDateFrom and ProcessingDateTo are inclusive ISO 8601 dates (YYYY-MM-DD).
+	ProcessingDateFrom string
+	ProcessingDateTo   string
+	// IncludeDeleted matches soft deleted payments as well as live ones.
+	IncludeDeleted bool
+	// DeletedBefore, when not zero, only matches payments soft deleted before the time.
+	DeletedBefore time.Time
+}
+
+// Matches reports whether the payment passes the filter.
+func (filter ListFilter) Matches(payment *api.Payment) bool {
+	switch {
+	case filter.OrganisationID != "" && payment.OrganisationID != filter.OrganisationID:
+		return false
+	case filter.Currency != "" && payment.Currency != filter.Currency:
+		return false
+	case filter.PaymentScheme != "" && payment.PaymentScheme != filter.PaymentScheme:
+		return false
+	case filter.PaymentType != "" && payment.PaymentType != filter.PaymentType:
+		return false
+	// ISO dates order the same as strings
+	case filter.ProcessingDateFrom != "" && payment.ProcessingDate < filter.ProcessingDateFrom:
+		return false
+	case filter.ProcessingDateTo != "" && payment.ProcessingDate > filter.ProcessingDateTo:
+		return false
+	case !filter.DeletedBefore.IsZero():
+		return payment.DeletedAt != nil && payment.DeletedAt.Before(filter.DeletedBefore)
+	case payment.DeletedAt != nil && !filter.IncludeDeleted:
+		return false
+	}
+
+	return true
+}
+
+// checkQuery returns an error wrapping ErrInvalid if the query can not be run.
+func checkQuery(query ListQuery) error {
+	switch {
+	case query.Offset < 0:
+		return fmt.Errorf("offset %d is negative: %w", query.Offset, ErrInvalid)
+	case query.Limit < 0:
+		return fmt.Errorf("limit %d is negative: %w", query.Limit, ErrInvalid)
+	}
+	switch query.Sort {
+	case "", SortByID, SortByProcessingDate, SortByAmount:
+		return nil
+	default:
+		return fmt.Errorf("payments can not be sorted by %s: %w", query.Sort, ErrInvalid)
+	}
+}
+
+// countPayments counts a set of payments held in memory by currency and scheme, as used by the in memory and file
+// stores.
+func countPayments(payments map[string]*api.Payment) []PaymentCount {
+	byKind := map[PaymentCount]int{}
+	for _, payment := range payments {
+		if payment.DeletedAt == nil {
+			byKind[PaymentCount{Currency: payment.Currency, PaymentScheme: payment.PaymentScheme}]++
+		}
+	}
+
+	counts := make([]PaymentCount, 0, len(byKind))
+	for kind, count := range byKind {
+		kind.Count = count
+		counts = append(counts, kind)
+	}
+	sort.Slice(counts, func(i, j int) bool {
+		if counts[i].Currency != counts[j].Currency {
+			return counts[i].Currency < counts[j].Currency
+		}
+		return counts[i].PaymentScheme < counts[j].PaymentScheme
+	})
+
+	return counts
+}
+
+// queryPayments applies the query to a set of payments held in memory, as used by the in memory and file stores.
+func queryPayments(payments map[string]*api.Payment, query ListQuery) *api.ListHolder {
+	var matches []*api.Payment
+	for _, payment := range payments {
+		if query.Filter.Matches(payment) {
+			matches = append(matches, payment)
+		}
+	}
+
+	sort.Slice(matches, func(i, j int) bool {
+		a, b := matches[i], matches[j]
+		cmp := compareBy(query.Sort, a, b)
+		if cmp == 0 {
+			return a.ID < b.ID
+		}
+		if query.Descending {
+			return cmp > 0
+		}
+		return cmp < 0
+	})
+
+	result := &api.ListHolder{Data: []api.Payment{}, Meta: &api.ListMeta{TotalCount: len(matches)}}
+	if query.Offset < len(matches) {
+		matches = matches[query.Offset:]
+	} else {
+		matches = nil
+	}
+	if query.Limit > 0 && query.Limit < len(matches) {
+		matches = matches[:query.Limit]
+	}
+	for _, payment := range matches {
+		result.Data = append(result.Data, *payment)
+	}
+
+	return result
+}
+
+// compareBy compares two payments on the sort field returning a negative number, zero or a positive number when a is
+// before, the same as or after b.
+func compareBy(field SortField, a, b *api.Payment) int {
+	switch field {
+	case SortByProcessingDate:
+		return compareStrings(a.ProcessingDate, b.ProcessingDate)
+	case SortByAmount:
+		return a.Amount.Cmp(b.Amount)
+	default:
+		return compareStrings(a.ID, b.ID)
+	}
+}
+
+func compareStrings(a, b string) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	default:
+		return 0
+	}
+}
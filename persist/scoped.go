@@ -0,0 +1,99 @@
+package persist
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/cdempsie/payments-example/api"
+)
+
+// ScopedStore restricts a PaymentStore to the payments of a single organisation.
+// Payments of other organisations are reported as not found, so their existence is not revealed, and payments can
+// not be created in or moved to another organisation.
+type ScopedStore struct {
+	store          PaymentStore
+	organisationID string
+}
+
+// NewScopedStore returns a store that only reads and changes the payments of the organisation held in the store.
+func NewScopedStore(store PaymentStore, organisationID string) *ScopedStore {
+	return &ScopedStore{store: store, organisationID: organisationID}
+}
+
+// Create creates the payment, which must belong to the organisation. Creating a payment with the ID of a payment of
+// another organisation is rejected as invalid rather than as already existing, so that the error does not reveal there
+// is a payment with the ID.
+func (store *ScopedStore) Create(ctx context.Context, payment *api.Payment) error {
+	if err := store.checkOrganisation(payment); err != nil {
+		return err
+	}
+
+	err := store.store.Create(ctx, payment)
+	if !errors.Is(err, ErrAlreadyExists) {
+		return err
+	}
+	if _, loadErr := store.Load(ctx, payment.ID); errors.Is(loadErr, ErrNotFound) {
+		return fmt.Errorf("payment ID: %s can not be used, leave it out to have one assigned: %w", payment.ID,
+			ErrInvalid)
+	}
+
+	return err
+}
+
+// Update updates the payment, which must already belong to the organisation and stay in it.
+func (store *ScopedStore) Update(ctx context.Context, payment *api.Payment) error {
+	if err := store.checkOrganisation(payment); err != nil {
+		return err
+	}
+	if _, err := store.Load(ctx, payment.ID); err != nil {
+		return err
+	}
+
+	return store.store.Update(ctx, payment)
+}
+
+// Delete deletes the payment with the given ID if it belongs to the organisation.
+func (store *ScopedStore) Delete(ctx context.Context, paymentUID string) error {
+	if _, err := store.Load(ctx, paymentUID); err != nil {
+		return err
+	}
+
+	return store.store.Delete(ctx, paymentUID)
+}
+
+// Load loads the payment with the given ID. A payment belonging to another organisation is not found.
+func (store *ScopedStore) Load(ctx context.Context, paymentUID string) (*api.Payment, error) {
+	payment, err := store.store.Load(ctx, paymentUID)
+	if err != nil {
+		return nil, err
+	}
+	if payment.OrganisationID != store.organisationID {
+		return nil, notFound(paymentUID)
+	}
+
+	return payment, nil
+}
+
+// List lists the payments of the organisation matching the query. Filtering by another organisation matches nothing.
+func (store *ScopedStore) List(ctx context.Context, query ListQuery) (*api.ListHolder, error) {
+	if query.Filter.OrganisationID != "" && query.Filter.OrganisationID != store.organisationID {
+		return &api.ListHolder{Data: []api.Payment{}, Meta: &api.ListMeta{}}, nil
+	}
+	query.Filter.OrganisationID = store.organisationID
+
+	return store.store.List(ctx, query)
+}
+
+// checkOrganisation returns an error wrapping ErrInvalid if the payment is missing or belongs to another organisation.
+func (store *ScopedStore) checkOrganisation(payment *api.Payment) error {
+	if err := checkPayment(payment, false); err != nil {
+		return err
+	}
+	if payment.OrganisationID != store.organisationID {
+		return fmt.Errorf("payment with ID: %s must belong to organisation %s not %q: %w", payment.ID,
+			store.organisationID, payment.OrganisationID, ErrInvalid)
+	}
+
+	return nil
+}
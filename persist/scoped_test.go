@@ -0,0 +1,83 @@
+package persist_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/cdempsie/payments-example/persist"
+	"github.com/google/uuid"
+)
+
+func TestScopedStore(t *testing.T) {
+	store := persist.NewInMemoryStore()
+	own := create(t, store)
+	other := decode(t)
+	other.ID, other.OrganisationID = uuid.New().String(), uuid.New().String()
+	if err := store.Create(context.Background(), other); err != nil {
+		t.Fatalf("Failed to create payment in store: %v", err)
+	}
+	scoped := persist.NewScopedStore(store, own.OrganisationID)
+
+	if _, err := scoped.Load(context.Background(), own.ID); err != nil {
+		t.Errorf("Failed to load payment of the organisation: %v", err)
+	}
+	if _, err := scoped.Load(context.Background(), other.ID); !errors.Is(err, persist.ErrNotFound) {
+		t.Errorf("Expected payment of another organisation not to be found but got: %v", err)
+	}
+
+	list, err := scoped.List(context.Background(), persist.ListQuery{})
+	if err != nil {
+		t.Fatalf("Failed to list payments: %v", err)
+	}
+	if len(list.Data) != 1 || list.Data[0].ID != own.ID || list.Meta.TotalCount != 1 {
+		t.Errorf("Expected only the payment of the organisation to be listed but got: %+v", list.Data)
+	}
+	list, err = scoped.List(context.Background(),
+		persist.ListQuery{Filter: persist.ListFilter{OrganisationID: other.OrganisationID}})
+	if err != nil || len(list.Data) != 0 {
+		t.Errorf("Expected filtering by another organisation to match nothing but got: %+v, %v", list, err)
+	}
+
+	duplicate := *own
+	if err := scoped.Create(context.Background(), &duplicate); !errors.Is(err, persist.ErrAlreadyExists) {
+		t.Errorf("Expected creating a payment with the ID of one of the organisation to fail but got: %v", err)
+	}
+	taken := *own
+	taken.ID = other.ID
+	err = scoped.Create(context.Background(), &taken)
+	if !errors.Is(err, persist.ErrInvalid) || errors.Is(err, persist.ErrAlreadyExists) ||
+		strings.Contains(err.Error(), "exists") {
+		t.Errorf("Expected creating a payment with the ID of another organisation not to reveal it but got: %v", err)
+	}
+
+	update := *other
+	if err := scoped.Update(context.Background(), &update); !errors.Is(err, persist.ErrInvalid) {
+		t.Errorf("Expected updating a payment of another organisation to fail but got: %v", err)
+	}
+	update.OrganisationID = own.OrganisationID
+	if err := scoped.Update(context.Background(), &update); !errors.Is(err, persist.ErrNotFound) {
+		t.Errorf("Expected taking a payment from another organisation not to find it but got: %v", err)
+	}
+	moved := *own
+	moved.OrganisationID = other.OrganisationID
+	if err := scoped.Update(context.Background(), &moved); !errors.Is(err, persist.ErrInvalid) {
+		t.Errorf("Expected moving a payment to another organisation to fail but got: %v", err)
+	}
+	if err := scoped.Delete(context.Background(), other.ID); !errors.Is(err, persist.ErrNotFound) {
+		t.Errorf("Expected deleting a payment of another organisation not to find it but got: %v", err)
+	}
+	if _, err := store.Load(context.Background(), other.ID); err != nil {
+		t.Errorf("Expected payment of another organisation to be untouched but got: %v", err)
+	}
+
+	created := decode(t)
+	created.ID, created.OrganisationID = "", other.OrganisationID
+	if err := scoped.Create(context.Background(), created); !errors.Is(err, persist.ErrInvalid) {
+		t.Errorf("Expected creating a payment in another organisation to fail but got: %v", err)
+	}
+	if err := scoped.Delete(context.Background(), own.ID); err != nil {
+		t.Errorf("Failed to delete payment of the organisation: %v", err)
+	}
+}
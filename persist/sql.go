@@ -0,0 +1,700 @@
+package persist
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cdempsie/payments-example/api"
+	"github.com/cdempsie/payments-example/money"
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+	"github.com/mattn/go-sqlite3"
+)
+
+// Party roles used to distinguish the rows in the payment_parties table.
+const (
+	roleBeneficiary = "beneficiary"
+	roleDebtor      = "debtor"
+	roleSponsor     = "sponsor"
+)
+
+// paymentColumns lists the columns of the payments table in the order they are scanned and inserted.
+const paymentColumns = `id, type, version, organisation_id, amount, currency, end_to_end_reference, numeric_reference,
+	payment_id, payment_purpose, payment_scheme, payment_type, processing_date, reference, scheme_payment_sub_type,
+	scheme_payment_type, bearer_code, receiver_charges_amount, receiver_charges_currency, fx_contract_reference,
+	fx_exchange_rate, fx_original_amount, fx_original_currency, status, deleted_at, deleted_by, created_by`
+
+// pgUniqueViolation is the Postgres error code for a row that would break a primary key or unique constraint.
+const pgUniqueViolation = "23505"
+
+// deletedAtLayout is the layout deleted_at is stored in, fixed width in UTC so that it orders the same as a string.
+const deletedAtLayout = "2006-01-02T15:04:05.000000000Z"
+
+// SQLStore provides a payment store backed by a relational database accessed through database/sql.
+// Payments are split over a payments table, a payment_parties table holding the beneficiary, debtor and sponsor, a
+// payment_sender_charges table and a payment_status_changes table holding the status history. The schema is migrated
+// to the latest version when the store is opened.
+//
+// The SQL used is portable between SQLite and Postgres, whose drivers are registered along with the store as it needs
+// their errors to tell when a payment already exists.
+type SQLStore struct {
+	db         *sql.DB
+	driverName string
+}
+
+// NewSQLStore opens a connection to the database using the named driver and data source name and applies any
+// outstanding schema migrations.
+func NewSQLStore(driverName, dataSourceName string) (*SQLStore, error) {
+	db, err := sql.Open(driverName, dataSourceName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s database: %v", driverName, err)
+	}
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to connect to %s database: %v", driverName, err)
+	}
+
+	store := &SQLStore{db: db, driverName: driverName}
+	if err := store.migrate(); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return store, nil
+}
+
+// Create creates a new payment in the store, assigning a UUID in the process. The payment starts at version 0.
+func (store *SQLStore) Create(ctx context.Context, payment *api.Payment) error {
+	if err := checkPayment(payment, false); err != nil {
+		return err
+	}
+	if payment.ID == "" {
+		payment.ID = uuid.New().String()
+	}
+	payment.Version = 0
+
+	return store.inTx(ctx, func(tx *sql.Tx) error {
+		if err := store.insertPayment(ctx, tx, payment); err != nil {
+			if uniqueViolation(err) {
+				return alreadyExists(payment.ID)
+			}
+			return fmt.Errorf("failed to create payment with ID: %s: %v", payment.ID, err)
+		}
+
+		return store.insertChildren(ctx, tx, payment)
+	})
+}
+
+// Update updates the given payment in the store, incrementing its version.
+// An error is returned if the payment with the given ID could not be found or its version is not the stored version.
+func (store *SQLStore) Update(ctx context.Context, payment *api.Payment) error {
+	if err := checkPayment(payment, true); err != nil {
+		return err
+	}
+	err := store.inTx(ctx, func(tx *sql.Tx) error {
+		result, err := tx.ExecContext(ctx, store.rebind(`UPDATE payments SET type = ?, version = version + 1,
+			organisation_id = ?, amount = ?, currency = ?, end_to_end_reference = ?, numeric_reference = ?,
+			payment_id = ?, payment_purpose = ?, payment_scheme = ?, payment_type = ?, processing_date = ?,
+			reference = ?, scheme_payment_sub_type = ?, scheme_payment_type = ?, bearer_code = ?,
+			receiver_charges_amount = ?, receiver_charges_currency = ?, fx_contract_reference = ?, fx_exchange_rate = ?,
+			fx_original_amount = ?, fx_original_currency = ?, status = ?, deleted_at = ?, deleted_by = ?,
+			created_by = ?, amount_key = ? WHERE id = ? AND version = ?`), updateValues(payment)...)
+		if err != nil {
+			return fmt.Errorf("failed to update payment with ID: %s: %v", payment.ID, err)
+		}
+		affected, err := result.RowsAffected()
+		if err != nil {
+			return fmt.Errorf("failed to check rows affected for payment with ID: %s: %v", payment.ID, err)
+		}
+		if affected != 1 {
+			return store.updateMissed(ctx, tx, payment)
+		}
+
+		if err := store.deleteChildren(ctx, tx, payment.ID); err != nil {
+			return err
+		}
+
+		return store.insertChildren(ctx, tx, payment)
+	})
+	if err != nil {
+		return err
+	}
+	payment.Version++
+
+	return nil
+}
+
+// updateMissed works out why an update matched no rows, either the payment does not exist or it is at a different
+// version to the one given.
+func (store *SQLStore) updateMissed(ctx context.Context, tx *sql.Tx, payment *api.Payment) error {
+	var storedVersion int
+	err := tx.QueryRowContext(ctx, store.rebind("SELECT version FROM payments WHERE id = ?"), payment.ID).
+		Scan(&storedVersion)
+	if err == sql.ErrNoRows {
+		return notFound(payment.ID)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read version of payment with ID: %s: %v", payment.ID, err)
+	}
+
+	return versionConflict(payment, storedVersion)
+}
+
+// Delete deletes the payment with the given ID.
+// An error is returned if the payment with the given ID could not be found.
+func (store *SQLStore) Delete(ctx context.Context, paymentUID string) error {
+	if err := checkID(paymentUID); err != nil {
+		return err
+	}
+	return store.inTx(ctx, func(tx *sql.Tx) error {
+		if err := store.deleteChildren(ctx, tx, paymentUID); err != nil {
+			return err
+		}
+
+		result, err := tx.ExecContext(ctx, store.rebind("DELETE FROM payments WHERE id = ?"), paymentUID)
+		if err != nil {
+			return fmt.Errorf("failed to delete payment with ID: %s: %v", paymentUID, err)
+		}
+
+		return expectOneRow(result, paymentUID)
+	})
+}
+
+// Load loads the payment with the given ID.
+// If the payment is not found an error is returned.
+func (store *SQLStore) Load(ctx context.Context, paymentUID string) (payment *api.Payment, err error) {
+	if err := checkID(paymentUID); err != nil {
+		return nil, err
+	}
+	var payments []*api.Payment
+	err = store.inReadTx(ctx, func(tx *sql.Tx) (err error) {
+		payments, err = store.query(ctx, tx, "WHERE id = ?", paymentUID)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(payments) == 0 {
+		return nil, notFound(paymentUID)
+	}
+
+	return payments[0], nil
+}
+
+// List lists the payments in the store matching the query.
+func (store *SQLStore) List(ctx context.Context, query ListQuery) (results *api.ListHolder, err error) {
+	if err := checkQuery(query); err != nil {
+		return nil, err
+	}
+
+	where, args := sqlFilter(query.Filter)
+	clause := where + " ORDER BY " + sqlOrder(query)
+	pageArgs := args
+	if query.Limit > 0 || query.Offset > 0 {
+		limit := int64(query.Limit)
+		if limit == 0 {
+			// an offset needs a limit in SQLite
+			limit = math.MaxInt64
+		}
+		clause += " LIMIT ? OFFSET ?"
+		pageArgs = append(append([]interface{}{}, args...), limit, query.Offset)
+	}
+
+	var total int
+	var payments []*api.Payment
+	err = store.inReadTx(ctx, func(tx *sql.Tx) error {
+		err := tx.QueryRowContext(ctx, store.rebind("SELECT COUNT(*) FROM payments "+where), args...).Scan(&total)
+		if err != nil {
+			return fmt.Errorf("failed to count payments: %v", err)
+		}
+		payments, err = store.query(ctx, tx, clause, pageArgs...)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	result := &api.ListHolder{Data: []api.Payment{}, Meta: &api.ListMeta{TotalCount: total}}
+	for _, payment := range payments {
+		result.Data = append(result.Data, *payment)
+	}
+
+	return result, nil
+}
+
+// CountPayments counts the payments that are not deleted by currency and scheme in the database.
+func (store *SQLStore) CountPayments(ctx context.Context) ([]PaymentCount, error) {
+	counts, err := store.countPayments(ctx)
+
+	return counts, withContextError(ctx, err)
+}
+
+// countPayments runs the query counting the payments.
+func (store *SQLStore) countPayments(ctx context.Context) ([]PaymentCount, error) {
+	rows, err := store.db.QueryContext(ctx, `SELECT currency, payment_scheme, COUNT(*) FROM payments
+		WHERE deleted_at = '' GROUP BY currency, payment_scheme ORDER BY currency, payment_scheme`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to count payments: %v", err)
+	}
+	defer rows.Close()
+
+	counts := []PaymentCount{}
+	for rows.Next() {
+		var count PaymentCount
+		if err := rows.Scan(&count.Currency, &count.PaymentScheme, &count.Count); err != nil {
+			return nil, fmt.Errorf("failed to read payment count: %v", err)
+		}
+		counts = append(counts, count)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read payment counts: %v", err)
+	}
+
+	return counts, nil
+}
+
+// Ping checks the database can still be reached.
+func (store *SQLStore) Ping(ctx context.Context) error {
+	if err := store.db.PingContext(ctx); err != nil {
+		return fmt.Errorf("failed to reach %s database: %v", store.driverName, err)
+	}
+
+	return nil
+}
+
+// Close closes the underlying database connection pool.
+func (store *SQLStore) Close() error {
+	return store.db.Close()
+}
+
+// inTx runs the function in a transaction, committing if it succeeds and rolling back otherwise. The transaction is
+// rolled back if the context is done before it is committed.
+func (store *SQLStore) inTx(ctx context.Context, fn func(tx *sql.Tx) error) error {
+	return store.runTx(ctx, nil, fn)
+}
+
+// inReadTx runs the function in a read-only transaction, so that a payment and the rows of its child tables are read
+// as they were at one point rather than part way through a change made alongside.
+func (store *SQLStore) inReadTx(ctx context.Context, fn func(tx *sql.Tx) error) error {
+	return store.runTx(ctx, &sql.TxOptions{ReadOnly: true}, fn)
+}
+
+// runTx runs the function in a transaction with the options, which may be nil for the defaults.
+func (store *SQLStore) runTx(ctx context.Context, options *sql.TxOptions, fn func(tx *sql.Tx) error) error {
+	tx, err := store.db.BeginTx(ctx, options)
+	if err != nil {
+		return withContextError(ctx, fmt.Errorf("failed to start transaction: %v", err))
+	}
+	defer tx.Rollback()
+
+	if err := fn(tx); err != nil {
+		return withContextError(ctx, err)
+	}
+
+	return withContextError(ctx, tx.Commit())
+}
+
+// withContextError wraps the error, if there is one, with the error of the context when it is done as that is why
+// the database gave up, so that callers can tell with errors.Is. Errors that already wrap it are returned as they are.
+func withContextError(ctx context.Context, err error) error {
+	if err == nil || ctx.Err() == nil || errors.Is(err, ctx.Err()) {
+		return err
+	}
+
+	return fmt.Errorf("%v: %w", err, ctx.Err())
+}
+
+// query loads the payments matched by the clause, which follows the FROM of the payments select, along with their
+// parties, sender charges and status history, all read in the transaction.
+func (store *SQLStore) query(ctx context.Context, tx *sql.Tx, clause string,
+	args ...interface{}) ([]*api.Payment, error) {
+	rows, err := tx.QueryContext(ctx, store.rebind("SELECT "+paymentColumns+" FROM payments "+clause), args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query payments: %v", err)
+	}
+	defer rows.Close()
+
+	var payments []*api.Payment
+	byID := make(map[string]*api.Payment)
+	for rows.Next() {
+		payment := &api.Payment{}
+		attributes := &payment.Attributes
+		var deletedAt string
+		err := rows.Scan(&payment.ID, &payment.Type, &payment.Version, &payment.OrganisationID, &attributes.Amount,
+			&attributes.Currency, &attributes.EndToEndReference, &attributes.NumericReference, &attributes.PaymentID,
+			&attributes.PaymentPurpose, &attributes.PaymentScheme, &attributes.PaymentType, &attributes.ProcessingDate,
+			&attributes.Reference, &attributes.SchemePaymentSubType, &attributes.SchemePaymentType,
+			&attributes.ChargesInformation.BearerCode, &attributes.ChargesInformation.ReceiverChargesAmount,
+			&attributes.ChargesInformation.ReceiverChargesCurrency, &attributes.Fx.ContractReference,
+			&attributes.Fx.ExchangeRate, &attributes.Fx.OriginalAmount, &attributes.Fx.OriginalCurrency,
+			&payment.Status, &deletedAt, &payment.DeletedBy, &payment.CreatedBy)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read payment: %v", err)
+		}
+		if deletedAt != "" {
+			at, err := time.Parse(deletedAtLayout, deletedAt)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read deletion time of payment with ID: %s: %v", payment.ID, err)
+			}
+			payment.DeletedAt = &at
+		}
+		payments = append(payments, payment)
+		byID[payment.ID] = payment
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read payments: %v", err)
+	}
+	if len(payments) == 0 {
+		return nil, nil
+	}
+
+	if err := store.loadParties(ctx, tx, byID); err != nil {
+		return nil, err
+	}
+	if err := store.loadSenderCharges(ctx, tx, byID); err != nil {
+		return nil, err
+	}
+	if err := store.loadStatusHistory(ctx, tx, byID); err != nil {
+		return nil, err
+	}
+
+	return payments, nil
+}
+
+// loadParties fills in the parties of the given payments.
+func (store *SQLStore) loadParties(ctx context.Context, tx *sql.Tx, byID map[string]*api.Payment) error {
+	ids, placeholders := idArgs(byID)
+	rows, err := tx.QueryContext(ctx, store.rebind(`SELECT payment_id, role, account_name, account_number,
+		account_number_code, account_type, address, bank_id, bank_id_code, name
+		FROM payment_parties WHERE payment_id IN (`+placeholders+`)`), ids...)
+	if err != nil {
+		return fmt.Errorf("failed to query payment parties: %v", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var paymentID, role string
+		party := api.BeneficiaryParty{}
+		err := rows.Scan(&paymentID, &role, &party.AccountName, &party.AccountNumber, &party.AccountNumberCode,
+			&party.AccountType, &party.Address, &party.BankID, &party.BankIDCode, &party.Name)
+		if err != nil {
+			return fmt.Errorf("failed to read payment party: %v", err)
+		}
+
+		attributes := &byID[paymentID].Attributes
+		switch role {
+		case roleBeneficiary:
+			attributes.BeneficiaryParty = party
+		case roleDebtor:
+			attributes.DebtorParty = api.DebtorParty{
+				AccountName:       party.AccountName,
+				AccountNumber:     party.AccountNumber,
+				AccountNumberCode: party.AccountNumberCode,
+				Address:           party.Address,
+				BankID:            party.BankID,
+				BankIDCode:        party.BankIDCode,
+				Name:              party.Name,
+			}
+		case roleSponsor:
+			attributes.SponsorParty = api.SponsorParty{
+				AccountNumber: party.AccountNumber,
+				BankID:        party.BankID,
+				BankIDCode:    party.BankIDCode,
+			}
+		}
+	}
+
+	return rows.Err()
+}
+
+// loadSenderCharges fills in the sender charges of the given payments preserving their original order.
+func (store *SQLStore) loadSenderCharges(ctx context.Context, tx *sql.Tx, byID map[string]*api.Payment) error {
+	ids, placeholders := idArgs(byID)
+	rows, err := tx.QueryContext(ctx, store.rebind(`SELECT payment_id, amount, currency FROM payment_sender_charges
+		WHERE payment_id IN (`+placeholders+`) ORDER BY payment_id, position`), ids...)
+	if err != nil {
+		return fmt.Errorf("failed to query sender charges: %v", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var paymentID string
+		charge := api.SenderCharge{}
+		if err := rows.Scan(&paymentID, &charge.Amount, &charge.Currency); err != nil {
+			return fmt.Errorf("failed to read sender charge: %v", err)
+		}
+		charges := &byID[paymentID].ChargesInformation
+		charges.SenderCharges = append(charges.SenderCharges, charge)
+	}
+
+	return rows.Err()
+}
+
+// loadStatusHistory fills in the status changes of the given payments in the order they were made.
+func (store *SQLStore) loadStatusHistory(ctx context.Context, tx *sql.Tx, byID map[string]*api.Payment) error {
+	ids, placeholders := idArgs(byID)
+	rows, err := tx.QueryContext(ctx, store.rebind(`SELECT payment_id, from_status, to_status, actor,
+		changed_at, reason FROM payment_status_changes WHERE payment_id IN (`+placeholders+`)
+		ORDER BY payment_id, position`), ids...)
+	if err != nil {
+		return fmt.Errorf("failed to query status changes: %v", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var paymentID, changedAt string
+		change := api.StatusChange{}
+		err := rows.Scan(&paymentID, &change.From, &change.To, &change.Actor, &changedAt, &change.Reason)
+		if err != nil {
+			return fmt.Errorf("failed to read status change: %v", err)
+		}
+		if change.At, err = time.Parse(time.RFC3339Nano, changedAt); err != nil {
+			return fmt.Errorf("failed to read status change time of payment with ID: %s: %v", paymentID, err)
+		}
+		payment := byID[paymentID]
+		payment.StatusHistory = append(payment.StatusHistory, change)
+	}
+
+	return rows.Err()
+}
+
+// insertPayment inserts the top level payment row along with the key its amount is sorted by.
+func (store *SQLStore) insertPayment(ctx context.Context, tx *sql.Tx, payment *api.Payment) error {
+	values := append(paymentValues(payment), amountKey(payment.Amount))
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(values)), ", ")
+	_, err := tx.ExecContext(ctx, store.rebind("INSERT INTO payments ("+paymentColumns+", amount_key) VALUES ("+
+		placeholders+")"), values...)
+
+	return err
+}
+
+// insertChildren inserts the parties, sender charges and status changes of the payment.
+func (store *SQLStore) insertChildren(ctx context.Context, tx *sql.Tx, payment *api.Payment) error {
+	insertParty := store.rebind(`INSERT INTO payment_parties (payment_id, role, account_name, account_number,
+		account_number_code, account_type, address, bank_id, bank_id_code, name) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`)
+
+	beneficiary := payment.BeneficiaryParty
+	debtor := payment.DebtorParty
+	sponsor := payment.SponsorParty
+	parties := [][]interface{}{
+		{roleBeneficiary, beneficiary.AccountName, beneficiary.AccountNumber, beneficiary.AccountNumberCode,
+			beneficiary.AccountType, beneficiary.Address, beneficiary.BankID, beneficiary.BankIDCode, beneficiary.Name},
+		{roleDebtor, debtor.AccountName, debtor.AccountNumber, debtor.AccountNumberCode, 0, debtor.Address,
+			debtor.BankID, debtor.BankIDCode, debtor.Name},
+		{roleSponsor, "", sponsor.AccountNumber, "", 0, "", sponsor.BankID, sponsor.BankIDCode, ""},
+	}
+	for _, party := range parties {
+		if _, err := tx.ExecContext(ctx, insertParty, append([]interface{}{payment.ID}, party...)...); err != nil {
+			return fmt.Errorf("failed to save %s party for payment with ID: %s: %v", party[0], payment.ID, err)
+		}
+	}
+
+	insertCharge := store.rebind(`INSERT INTO payment_sender_charges (payment_id, position, amount, currency)
+		VALUES (?, ?, ?, ?)`)
+	for i, charge := range payment.ChargesInformation.SenderCharges {
+		if _, err := tx.ExecContext(ctx, insertCharge, payment.ID, i, charge.Amount, charge.Currency); err != nil {
+			return fmt.Errorf("failed to save sender charge for payment with ID: %s: %v", payment.ID, err)
+		}
+	}
+
+	insertChange := store.rebind(`INSERT INTO payment_status_changes (payment_id, position, from_status, to_status,
+		actor, changed_at, reason) VALUES (?, ?, ?, ?, ?, ?, ?)`)
+	for i, change := range payment.StatusHistory {
+		_, err := tx.ExecContext(ctx, insertChange, payment.ID, i, change.From, change.To, change.Actor,
+			change.At.Format(time.RFC3339Nano), change.Reason)
+		if err != nil {
+			return fmt.Errorf("failed to save status change for payment with ID: %s: %v", payment.ID, err)
+		}
+	}
+
+	return nil
+}
+
+// deleteChildren removes the parties, sender charges and status changes of the payment.
+func (store *SQLStore) deleteChildren(ctx context.Context, tx *sql.Tx, paymentUID string) error {
+	for _, table := range []string{"payment_parties", "payment_sender_charges", "payment_status_changes"} {
+		if _, err := tx.ExecContext(ctx, store.rebind("DELETE FROM "+table+" WHERE payment_id = ?"), paymentUID); err != nil {
+			return fmt.Errorf("failed to delete from %s for payment with ID: %s: %v", table, paymentUID, err)
+		}
+	}
+
+	return nil
+}
+
+// rebind rewrites the ? placeholders used throughout the store into the style expected by the driver.
+func (store *SQLStore) rebind(query string) string {
+	if store.driverName != "postgres" && store.driverName != "pgx" {
+		return query
+	}
+
+	builder := &strings.Builder{}
+	n := 0
+	for _, r := range query {
+		if r == '?' {
+			n++
+			builder.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+		builder.WriteRune(r)
+	}
+
+	return builder.String()
+}
+
+// paymentValues returns the values of the payments table columns in paymentColumns order.
+func paymentValues(payment *api.Payment) []interface{} {
+	attributes := payment.Attributes
+	return []interface{}{payment.ID, payment.Type, payment.Version, payment.OrganisationID, attributes.Amount,
+		attributes.Currency, attributes.EndToEndReference, attributes.NumericReference, attributes.PaymentID,
+		attributes.PaymentPurpose, attributes.PaymentScheme, attributes.PaymentType, attributes.ProcessingDate,
+		attributes.Reference, attributes.SchemePaymentSubType, attributes.SchemePaymentType,
+		attributes.ChargesInformation.BearerCode, attributes.ChargesInformation.ReceiverChargesAmount,
+		attributes.ChargesInformation.ReceiverChargesCurrency, attributes.Fx.ContractReference,
+		attributes.Fx.ExchangeRate, attributes.Fx.OriginalAmount, attributes.Fx.OriginalCurrency, payment.Status,
+		formatDeletedAt(payment.DeletedAt), payment.DeletedBy, payment.CreatedBy}
+}
+
+// formatDeletedAt returns the deleted_at column value for the time, empty for a payment that is not deleted.
+func formatDeletedAt(deletedAt *time.Time) string {
+	if deletedAt == nil {
+		return ""
+	}
+
+	return deletedAt.UTC().Format(deletedAtLayout)
+}
+
+// The number of digits before and after the decimal point in an amount key, enough for any money.Decimal.
+const (
+	amountKeyWholeDigits    = 19
+	amountKeyFractionDigits = 18
+)
+
+// amountKey returns the amount_key column value for the amount, a fixed width string that orders the same as the
+// amounts compare with money.Decimal.Cmp. It is a 1 for amounts of zero or more, or a 0 for negative amounts,
+// followed by the digits before and after the decimal point padded to a fixed width. The digits of negative amounts
+// are subtracted from 9 so that larger amounts come first. An unset amount is treated as zero, as it is by Cmp.
+func amountKey(amount money.Decimal) string {
+	digits := amount.String()
+	if digits == "" {
+		digits = "0"
+	}
+	negative := strings.HasPrefix(digits, "-")
+	digits = strings.TrimPrefix(digits, "-")
+
+	whole, fraction := digits, ""
+	if point := strings.IndexByte(digits, '.'); point >= 0 {
+		whole, fraction = digits[:point], digits[point+1:]
+	}
+	whole = strings.TrimLeft(whole, "0")
+	padded := strings.Repeat("0", amountKeyWholeDigits-len(whole)) + whole + fraction +
+		strings.Repeat("0", amountKeyFractionDigits-len(fraction))
+	if !negative {
+		return "1" + padded
+	}
+
+	complement := []byte(padded)
+	for i, digit := range complement {
+		complement[i] = '9' - digit + '0'
+	}
+
+	return "0" + string(complement)
+}
+
+// sqlFilter returns the WHERE clause, which may be empty, and arguments for the filter.
+func sqlFilter(filter ListFilter) (where string, args []interface{}) {
+	var conditions []string
+	add := func(condition string, value string) {
+		if value != "" {
+			conditions = append(conditions, condition)
+			args = append(args, value)
+		}
+	}
+	add("organisation_id = ?", filter.OrganisationID)
+	add("currency = ?", filter.Currency)
+	add("payment_scheme = ?", filter.PaymentScheme)
+	add("payment_type = ?", filter.PaymentType)
+	add("processing_date >= ?", filter.ProcessingDateFrom)
+	add("processing_date <= ?", filter.ProcessingDateTo)
+	switch {
+	case !filter.DeletedBefore.IsZero():
+		add("deleted_at <> '' AND deleted_at < ?", formatDeletedAt(&filter.DeletedBefore))
+	case !filter.IncludeDeleted:
+		conditions = append(conditions, "deleted_at = ''")
+	}
+
+	if len(conditions) == 0 {
+		return "", nil
+	}
+
+	return "WHERE " + strings.Join(conditions, " AND "), args
+}
+
+// sqlOrder returns the ORDER BY expression for the query, ties are always broken by ID.
+func sqlOrder(query ListQuery) string {
+	direction := " ASC"
+	if query.Descending {
+		direction = " DESC"
+	}
+
+	switch query.Sort {
+	case SortByProcessingDate:
+		return "processing_date" + direction + ", id ASC"
+	case SortByAmount:
+		return "amount_key" + direction + ", id ASC"
+	default:
+		return "id" + direction
+	}
+}
+
+// updateValues returns the arguments for the update statement, the payments table columns other than id and version
+// and the amount key, followed by the id and the expected version.
+func updateValues(payment *api.Payment) []interface{} {
+	values := paymentValues(payment)
+	columns := append([]interface{}{values[1]}, values[3:]...)
+
+	return append(columns, amountKey(payment.Amount), payment.ID, payment.Version)
+}
+
+// idArgs returns the payment IDs as query arguments along with a matching list of placeholders.
+func idArgs(byID map[string]*api.Payment) (ids []interface{}, placeholders string) {
+	for id := range byID {
+		ids = append(ids, id)
+	}
+
+	return ids, strings.TrimSuffix(strings.Repeat("?, ", len(ids)), ", ")
+}
+
+// uniqueViolation reports whether the error is the driver's error for a row that would break a primary key or unique
+// constraint, which differs between SQLite and Postgres.
+func uniqueViolation(err error) bool {
+	var sqliteErr sqlite3.Error
+	if errors.As(err, &sqliteErr) {
+		return sqliteErr.ExtendedCode == sqlite3.ErrConstraintPrimaryKey ||
+			sqliteErr.ExtendedCode == sqlite3.ErrConstraintUnique
+	}
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		return pqErr.Code == pgUniqueViolation
+	}
+
+	return false
+}
+
+// expectOneRow returns an error wrapping ErrNotFound if the statement did not affect exactly one row.
+func expectOneRow(result sql.Result, paymentUID string) error {
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to check rows affected for payment with ID: %s: %v", paymentUID, err)
+	}
+	if affected != 1 {
+		return notFound(paymentUID)
+	}
+
+	return nil
+}
@@ -0,0 +1,171 @@
+package persist
+
+import (
+	"embed"
+	"fmt"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cdempsie/payments-example/money"
+)
+
+// migrationFiles holds the versioned schema migrations. Each file is named <version>_<description>.sql and
+// the versions must be unique. Migrations are never edited once released, add a new file instead.
+//
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+// migration is a single versioned schema change.
+type migration struct {
+	version    int
+	name       string
+	statements string
+}
+
+// loadMigrations reads the embedded migrations ordered by version.
+func loadMigrations() ([]migration, error) {
+	entries, err := migrationFiles.ReadDir("migrations")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read migrations: %v", err)
+	}
+
+	migrations := make([]migration, 0, len(entries))
+	seen := make(map[int]string)
+	for _, entry := range entries {
+		name := entry.Name()
+		prefix := strings.SplitN(name, "_", 2)[0]
+		version, err := strconv.Atoi(prefix)
+		if err != nil {
+			return nil, fmt.Errorf("migration %s does not start with a version number", name)
+		}
+		if other, ok := seen[version]; ok {
+			return nil, fmt.Errorf("migrations %s and %s share version %d", other, name, version)
+		}
+		seen[version] = name
+
+		contents, err := migrationFiles.ReadFile(path.Join("migrations", name))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read migration %s: %v", name, err)
+		}
+		migrations = append(migrations, migration{version: version, name: name, statements: string(contents)})
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].version < migrations[j].version })
+
+	return migrations, nil
+}
+
+// migrate applies any migrations that have not yet been applied to the database.
+// Each migration runs in its own transaction along with the record of it having been applied.
+func (store *SQLStore) migrate() error {
+	migrations, err := loadMigrations()
+	if err != nil {
+		return err
+	}
+
+	_, err = store.db.Exec(`CREATE TABLE IF NOT EXISTS schema_migrations (
+		version    INTEGER PRIMARY KEY,
+		applied_at TEXT NOT NULL
+	)`)
+	if err != nil {
+		return fmt.Errorf("failed to create schema_migrations table: %v", err)
+	}
+
+	applied := make(map[int]bool)
+	rows, err := store.db.Query("SELECT version FROM schema_migrations")
+	if err != nil {
+		return fmt.Errorf("failed to read applied migrations: %v", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var version int
+		if err := rows.Scan(&version); err != nil {
+			return fmt.Errorf("failed to read applied migrations: %v", err)
+		}
+		applied[version] = true
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to read applied migrations: %v", err)
+	}
+
+	for _, m := range migrations {
+		if applied[m.version] {
+			continue
+		}
+		if err := store.applyMigration(m); err != nil {
+			return err
+		}
+	}
+
+	return store.fillAmountKeys()
+}
+
+// fillAmountKeys sets the amount key of the payments stored before the amount_key column was added, which can not be
+// worked out in portable SQL. Every payment stored since has a key so once filled in there is nothing left to do.
+func (store *SQLStore) fillAmountKeys() error {
+	rows, err := store.db.Query("SELECT id, amount FROM payments WHERE amount_key = ''")
+	if err != nil {
+		return fmt.Errorf("failed to read payments without an amount key: %v", err)
+	}
+	keys := make(map[string]string)
+	for rows.Next() {
+		var id string
+		var amount money.Decimal
+		if err := rows.Scan(&id, &amount); err != nil {
+			rows.Close()
+			return fmt.Errorf("failed to read payment without an amount key: %v", err)
+		}
+		keys[id] = amountKey(amount)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to read payments without an amount key: %v", err)
+	}
+
+	for id, key := range keys {
+		_, err := store.db.Exec(store.rebind("UPDATE payments SET amount_key = ? WHERE id = ?"), key, id)
+		if err != nil {
+			return fmt.Errorf("failed to set amount key of payment with ID: %s: %v", id, err)
+		}
+	}
+
+	return nil
+}
+
+// applyMigration runs a single migration and records it as applied.
+func (store *SQLStore) applyMigration(m migration) error {
+	tx, err := store.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to start migration %s: %v", m.name, err)
+	}
+	defer tx.Rollback()
+
+	for _, statement := range splitStatements(m.statements) {
+		if _, err := tx.Exec(statement); err != nil {
+			return fmt.Errorf("failed to apply migration %s: %v", m.name, err)
+		}
+	}
+	_, err = tx.Exec(store.rebind("INSERT INTO schema_migrations (version, applied_at) VALUES (?, ?)"),
+		m.version, time.Now().UTC().Format(time.RFC3339))
+	if err != nil {
+		return fmt.Errorf("failed to record migration %s: %v", m.name, err)
+	}
+
+	return tx.Commit()
+}
+
+// splitStatements splits a migration file into its individual statements so that drivers which only accept a
+// single statement per Exec can run them. Statements are separated by a semicolon at the end of a line.
+func splitStatements(contents string) []string {
+	var statements []string
+	for _, statement := range strings.Split(contents, ";\n") {
+		statement = strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(statement), ";"))
+		if statement != "" {
+			statements = append(statements, statement)
+		}
+	}
+
+	return statements
+}
@@ -0,0 +1,187 @@
+package persist_test
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/cdempsie/payments-example/api"
+	"github.com/cdempsie/payments-example/money"
+	"github.com/cdempsie/payments-example/persist"
+	"github.com/google/uuid"
+)
+
+func TestSQLStoreRoundTrip(t *testing.T) {
+	store := openSQLStore(t, filepath.Join(t.TempDir(), "payments.db"))
+	defer store.Close()
+	payment := create(t, store)
+
+	result, err := store.Load(context.Background(), payment.ID)
+	if err != nil {
+		t.Fatalf("Failed to load payment from store: %v", err)
+	}
+	if !reflect.DeepEqual(payment, result) {
+		t.Fatalf("Expected payment structs to match but they didn't. Expected: %v\nGot %v\n", payment, result)
+	}
+}
+
+func TestSQLStorePing(t *testing.T) {
+	store := openSQLStore(t, filepath.Join(t.TempDir(), "payments.db"))
+	if err := store.Ping(context.Background()); err != nil {
+		t.Fatalf("Expected open store to be usable: %v", err)
+	}
+
+	store.Close()
+	if err := store.Ping(context.Background()); err == nil {
+		t.Fatalf("Expected closed store not to be usable")
+	}
+}
+
+func TestSQLStoreUpdate(t *testing.T) {
+	store := openSQLStore(t, filepath.Join(t.TempDir(), "payments.db"))
+	defer store.Close()
+	payment := create(t, store)
+
+	payment.BeneficiaryParty.Address = "new address"
+	payment.ChargesInformation.SenderCharges = payment.ChargesInformation.SenderCharges[:1]
+	payment.Status = api.StatusValidated
+	payment.StatusHistory = []api.StatusChange{
+		{From: api.StatusCreated, To: api.StatusValidated, Actor: "tester", At: time.Now().UTC(), Reason: "checked"},
+	}
+	if err := store.Update(context.Background(), payment); err != nil {
+		t.Fatalf("Failed to update payment in store: %v", err)
+	}
+
+	result, err := store.Load(context.Background(), payment.ID)
+	if err != nil {
+		t.Fatalf("Failed to load payment from store: %v", err)
+	}
+	if !reflect.DeepEqual(payment, result) {
+		t.Fatalf("Expected payment structs to match but they didn't. Expected: %v\nGot %v\n", payment, result)
+	}
+}
+
+func TestSQLStoreUpdateVersion(t *testing.T) {
+	store := openSQLStore(t, filepath.Join(t.TempDir(), "payments.db"))
+	defer store.Close()
+	assertOptimisticConcurrency(t, store)
+}
+
+func TestSQLStoreCancelled(t *testing.T) {
+	store := openSQLStore(t, filepath.Join(t.TempDir(), "payments.db"))
+	defer store.Close()
+	assertCancelled(t, store)
+}
+
+func TestSQLStoreErrors(t *testing.T) {
+	store := openSQLStore(t, filepath.Join(t.TempDir(), "payments.db"))
+	defer store.Close()
+	assertStoreErrors(t, store)
+}
+
+func TestSQLStoreCountPayments(t *testing.T) {
+	store := openSQLStore(t, filepath.Join(t.TempDir(), "payments.db"))
+	defer store.Close()
+	assertCountPayments(t, store)
+}
+
+func TestSQLStoreListQuery(t *testing.T) {
+	store := openSQLStore(t, filepath.Join(t.TempDir(), "payments.db"))
+	defer store.Close()
+	assertListQuery(t, store)
+}
+
+func TestSQLStoreAmountOrder(t *testing.T) {
+	store := openSQLStore(t, filepath.Join(t.TempDir(), "payments.db"))
+	defer store.Close()
+	assertAmountOrder(t, store)
+}
+
+// TestSQLStoreFillsAmountKeys tests payments stored before they were given a key to sort their amount by are given one
+// when the store is opened.
+func TestSQLStoreFillsAmountKeys(t *testing.T) {
+	dsn := filepath.Join(t.TempDir(), "payments.db")
+	store := openSQLStore(t, dsn)
+	var ids []string
+	for _, amount := range []string{"20.00", "-3", "100.21"} {
+		payment := decode(t)
+		payment.Amount = money.MustParse(amount)
+		if err := store.Create(context.Background(), payment); err != nil {
+			t.Fatalf("Failed to create payment in store: %v", err)
+		}
+		ids = append(ids, payment.ID)
+	}
+	store.Close()
+
+	db, err := sql.Open("sqlite3", dsn)
+	if err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+	if _, err := db.Exec("UPDATE payments SET amount_key = ''"); err != nil {
+		t.Fatalf("Failed to clear amount keys: %v", err)
+	}
+	db.Close()
+
+	reopened := openSQLStore(t, dsn)
+	defer reopened.Close()
+	results, err := reopened.List(context.Background(), persist.ListQuery{Sort: persist.SortByAmount})
+	if err != nil {
+		t.Fatalf("Failed to list payments: %v", err)
+	}
+	expected := []string{ids[1], ids[0], ids[2]}
+	if len(results.Data) != len(expected) {
+		t.Fatalf("Expected %d payments but got %d", len(expected), len(results.Data))
+	}
+	for i, payment := range results.Data {
+		if payment.ID != expected[i] {
+			t.Fatalf("Expected payment %d to be %s but got %s with amount %s", i, expected[i], payment.ID,
+				payment.Amount)
+		}
+	}
+}
+
+func TestSQLStoreNotFoundID(t *testing.T) {
+	store := openSQLStore(t, filepath.Join(t.TempDir(), "payments.db"))
+	defer store.Close()
+	payment := create(t, store)
+
+	testID := uuid.New().String()
+	if _, err := store.Load(context.Background(), testID); err == nil {
+		t.Fatalf("Expected error loading unknown ID: %v", testID)
+	}
+	if err := store.Delete(context.Background(), testID); err == nil {
+		t.Fatalf("Expected error deleting unknown ID: %v", testID)
+	}
+	payment.ID = testID
+	if err := store.Update(context.Background(), payment); err == nil {
+		t.Fatalf("Expected error updating unknown ID: %v", testID)
+	}
+}
+
+func TestSQLStoreDeleteAndList(t *testing.T) {
+	dsn := filepath.Join(t.TempDir(), "payments.db")
+	store := openSQLStore(t, dsn)
+	kept := create(t, store)
+	deleted := create(t, store)
+	if err := store.Delete(context.Background(), deleted.ID); err != nil {
+		t.Fatalf("Failed to delete payment from store: %v", err)
+	}
+	store.Close()
+
+	// reopening runs the migrations again which must be a no-op
+	reopened := openSQLStore(t, dsn)
+	defer reopened.Close()
+	assertOnlyPayment(t, reopened, kept.ID, kept.BeneficiaryParty.Address)
+}
+
+func openSQLStore(t *testing.T, dsn string) *persist.SQLStore {
+	store, err := persist.NewSQLStore("sqlite3", dsn)
+	if err != nil {
+		t.Fatalf("Failed to open SQL store: %v", err)
+	}
+
+	return store
+}
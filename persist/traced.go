@@ -0,0 +1,96 @@
+package persist
+
+import (
+	"context"
+
+	"github.com/cdempsie/payments-example/api"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// paymentIDKey is the span attribute holding the ID of the payment an operation is on.
+const paymentIDKey = attribute.Key("payment.id")
+
+// TracedStore records a span for each operation on a PaymentStore, as a child of the span in the context the
+// operation is given, so that the time spent in the store shows up in the trace of the request using it.
+type TracedStore struct {
+	store  PaymentStore
+	tracer trace.Tracer
+}
+
+// NewTracedStore returns a store that passes each operation on to the store inside a span started by the tracer.
+func NewTracedStore(store PaymentStore, tracer trace.Tracer) *TracedStore {
+	return &TracedStore{store: store, tracer: tracer}
+}
+
+// Create creates the payment in the store.
+func (store *TracedStore) Create(ctx context.Context, payment *api.Payment) error {
+	ctx, span := store.start(ctx, OperationCreate, payment.ID)
+	err := store.store.Create(ctx, payment)
+	// the store assigns an ID when the payment does not have one
+	span.SetAttributes(paymentIDKey.String(payment.ID))
+	endSpan(span, err)
+
+	return err
+}
+
+// Update updates the payment in the store.
+func (store *TracedStore) Update(ctx context.Context, payment *api.Payment) error {
+	ctx, span := store.start(ctx, OperationUpdate, payment.ID)
+	err := store.store.Update(ctx, payment)
+	endSpan(span, err)
+
+	return err
+}
+
+// Delete deletes the payment with the given ID from the store.
+func (store *TracedStore) Delete(ctx context.Context, paymentUID string) error {
+	ctx, span := store.start(ctx, OperationDelete, paymentUID)
+	err := store.store.Delete(ctx, paymentUID)
+	endSpan(span, err)
+
+	return err
+}
+
+// Load loads the payment with the given ID from the store.
+func (store *TracedStore) Load(ctx context.Context, paymentUID string) (*api.Payment, error) {
+	ctx, span := store.start(ctx, OperationLoad, paymentUID)
+	payment, err := store.store.Load(ctx, paymentUID)
+	endSpan(span, err)
+
+	return payment, err
+}
+
+// List lists the payments in the store matching the query.
+func (store *TracedStore) List(ctx context.Context, query ListQuery) (*api.ListHolder, error) {
+	ctx, span := store.start(ctx, OperationList, "")
+	results, err := store.store.List(ctx, query)
+	if err == nil {
+		span.SetAttributes(attribute.Int("payment.count", len(results.Data)))
+	}
+	endSpan(span, err)
+
+	return results, err
+}
+
+// start starts the span of the operation on the payment, the ID is left out when empty. The returned context holds
+// the span so that anything the store traces is part of it.
+func (store *TracedStore) start(ctx context.Context, operation, paymentUID string) (context.Context, trace.Span) {
+	attributes := []attribute.KeyValue{attribute.String("payment_store.operation", operation)}
+	if paymentUID != "" {
+		attributes = append(attributes, paymentIDKey.String(paymentUID))
+	}
+
+	return store.tracer.Start(ctx, "payment_store."+operation, trace.WithSpanKind(trace.SpanKindInternal),
+		trace.WithAttributes(attributes...))
+}
+
+// endSpan ends the span, marking it as failed with the error if there is one.
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
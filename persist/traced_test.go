@@ -0,0 +1,56 @@
+package persist_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/cdempsie/payments-example/persist"
+	"github.com/google/uuid"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestTracedStore(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	tracer := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)).Tracer("test")
+	ctx, parent := tracer.Start(context.Background(), "request")
+	store := persist.NewTracedStore(persist.NewInMemoryStore(), tracer)
+
+	payment := decode(t)
+	if err := store.Create(ctx, payment); err != nil {
+		t.Fatalf("Failed to create payment in store: %v", err)
+	}
+	if _, err := store.Load(ctx, uuid.New().String()); !errors.Is(err, persist.ErrNotFound) {
+		t.Fatalf("Expected unknown payment not to be found but got: %v", err)
+	}
+	parent.End()
+
+	spans := recorder.Ended()
+	if len(spans) != 3 {
+		t.Fatalf("Expected a span for each operation and the request but got %d", len(spans))
+	}
+	created, loaded := spans[0], spans[1]
+	if created.Name() != "payment_store.create" || loaded.Name() != "payment_store.load" {
+		t.Errorf("Expected create and load spans but got %s and %s", created.Name(), loaded.Name())
+	}
+	for _, span := range spans[:2] {
+		if span.Parent().SpanID() != parent.SpanContext().SpanID() {
+			t.Errorf("Expected %s to be a child of the request span", span.Name())
+		}
+	}
+	foundID := false
+	for _, attribute := range created.Attributes() {
+		if attribute.Key == "payment.id" && attribute.Value.AsString() == payment.ID {
+			foundID = true
+		}
+	}
+	if !foundID {
+		t.Errorf("Expected the create span to have the ID of the payment but got %v", created.Attributes())
+	}
+	if created.Status().Code == codes.Error || loaded.Status().Code != codes.Error {
+		t.Errorf("Expected only the load of the unknown payment to fail but got %v and %v", created.Status(),
+			loaded.Status())
+	}
+}
@@ -0,0 +1,118 @@
+package server
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/cdempsie/payments-example/persist"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// metricsContentType is the content type of the Prometheus text format the metrics are served in.
+const metricsContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// paymentCountsTimeout is how long the store is given to count its payments when the metrics are scraped.
+const paymentCountsTimeout = 5 * time.Second
+
+// serverMetrics are the metrics the server exposes at /metrics.
+type serverMetrics struct {
+	requests        *prometheus.CounterVec
+	requestDuration *prometheus.HistogramVec
+	storeDuration   *prometheus.HistogramVec
+	storeErrors     *prometheus.CounterVec
+}
+
+// WithMetrics registers the metrics of the server in the registry, so that they can be exposed along with others,
+// instead of in a registry of its own. They are served at /metrics either way.
+func WithMetrics(registry *prometheus.Registry) Option {
+	return func(server *Server) {
+		server.metricsRegistry = registry
+	}
+}
+
+// newServerMetrics registers the metrics of the server in the registry.
+func newServerMetrics(registerer prometheus.Registerer) *serverMetrics {
+	factory := promauto.With(registerer)
+	return &serverMetrics{
+		requests: factory.NewCounterVec(prometheus.CounterOpts{
+			Name: "http_requests_total",
+			Help: "HTTP requests handled by route and status.",
+		}, []string{"method", "route", "status"}),
+		requestDuration: factory.NewHistogramVec(prometheus.HistogramOpts{
+			Name: "http_request_duration_seconds",
+			Help: "How long HTTP requests took to handle by route.",
+		}, []string{"method", "route"}),
+		storeDuration: factory.NewHistogramVec(prometheus.HistogramOpts{
+			Name: "payment_store_operation_duration_seconds",
+			Help: "How long operations on the payment store took.",
+		}, []string{"operation"}),
+		storeErrors: factory.NewCounterVec(prometheus.CounterOpts{
+			Name: "payment_store_errors_total",
+			Help: "Operations on the payment store that failed by the kind of error.",
+		}, []string{"operation", "error"}),
+	}
+}
+
+// paymentCounts is the gauge of how many payments there are by currency and scheme, not counting deleted ones. The
+// store counts them each time the metrics are collected.
+type paymentCounts struct {
+	counter persist.PaymentCounter
+	desc    *prometheus.Desc
+	logger  *slog.Logger
+}
+
+// newPaymentCounts returns the gauge of the payments counted by the store.
+func newPaymentCounts(counter persist.PaymentCounter, logger *slog.Logger) *paymentCounts {
+	return &paymentCounts{
+		counter: counter,
+		desc: prometheus.NewDesc("payments", "Payments by currency and scheme, not counting deleted ones.",
+			[]string{"currency", "payment_scheme"}, nil),
+		logger: logger,
+	}
+}
+
+// Describe sends the description of the gauge.
+func (counts *paymentCounts) Describe(descs chan<- *prometheus.Desc) {
+	descs <- counts.desc
+}
+
+// Collect counts the payments in the store. If counting fails it is logged and the gauge is left out of the scrape
+// rather than failing the other metrics with it.
+func (counts *paymentCounts) Collect(metrics chan<- prometheus.Metric) {
+	ctx, cancel := context.WithTimeout(context.Background(), paymentCountsTimeout)
+	defer cancel()
+	counted, err := counts.counter.CountPayments(ctx)
+	if err != nil {
+		counts.logger.Error("Failed to count payments", "error", err)
+		return
+	}
+	for _, count := range counted {
+		metrics <- prometheus.MustNewConstMetric(counts.desc, prometheus.GaugeValue, float64(count.Count),
+			count.Currency, count.PaymentScheme)
+	}
+}
+
+// observeStore records how long an operation on the store took and the kind of error it failed with, if it did.
+func (serverMetrics *serverMetrics) observeStore(operation string, duration time.Duration, err error) {
+	serverMetrics.storeDuration.WithLabelValues(operation).Observe(duration.Seconds())
+	if err != nil {
+		serverMetrics.storeErrors.WithLabelValues(operation, storeErrorCode(err)).Inc()
+	}
+}
+
+// measureRequests counts the requests to each route by status and records how long they took.
+func (server *Server) measureRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(responseWriter http.ResponseWriter, request *http.Request) {
+		start := time.Now()
+		writer := &statusWriter{ResponseWriter: responseWriter, status: http.StatusOK}
+		next.ServeHTTP(writer, request)
+
+		route := routeTemplate(request)
+		server.metrics.requests.WithLabelValues(request.Method, route, strconv.Itoa(writer.status)).Inc()
+		server.metrics.requestDuration.WithLabelValues(request.Method, route).Observe(time.Since(start).Seconds())
+	})
+}
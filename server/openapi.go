@@ -0,0 +1,397 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/cdempsie/payments-example/api"
+	"github.com/cdempsie/payments-example/auth"
+	"github.com/cdempsie/payments-example/money"
+	"github.com/cdempsie/payments-example/openapi"
+	"github.com/cdempsie/payments-example/patch"
+)
+
+// Names of the security schemes in the OpenAPI document.
+const (
+	apiKeyScheme = "apiKey"
+	bearerScheme = "bearer"
+)
+
+// pathParameter matches the {name} variables in route paths.
+var pathParameter = regexp.MustCompile(`{([^}]+)}`)
+
+// openAPIHandler returns the OpenAPI document describing the API.
+func (server *Server) openAPIHandler(responseWriter http.ResponseWriter, request *http.Request) {
+	writeResult(responseWriter, server.openAPI)
+}
+
+// apiDescriber builds the OpenAPI document describing the routes added by newRouter, the schemas of the bodies are
+// built from the api types so that they always match what is sent and accepted.
+type apiDescriber struct {
+	document *openapi.Document
+	schemas  *openapi.Schemas
+	errors   *openapi.Schema
+}
+
+// newOpenAPIDocument returns the OpenAPI document describing the API of the version of the server, dev when the version
+// was not set at link time.
+func newOpenAPIDocument(version string) *openapi.Document {
+	if version == "" {
+		version = "dev"
+	}
+	document := openapi.NewDocument(openapi.Info{
+		Title: "Payments API",
+		Description: "Create, change and move payments through their lifecycle. Callers reach the payments of their " +
+			"own organisation under /v1/organisations/{org-id}/payments, admins those of every organisation under " +
+			"/v1/payment and /v1/payments. Callers authenticate with an API key or a bearer token, or when the " +
+			"server is told to trust a gateway in front of it with the X-Actor, X-Organisation-ID, X-Roles and " +
+			"X-Admin headers the gateway sets.",
+		Version: version,
+	})
+	document.Components.SecuritySchemes = map[string]*openapi.SecurityScheme{
+		apiKeyScheme: {Type: "apiKey", Description: "An API key issued to the caller.", Name: auth.APIKeyHeader,
+			In: openapi.InHeader},
+		bearerScheme: {Type: "http", Description: "A JSON web token signed by a key in the configured key set.",
+			Scheme: "bearer", BearerFormat: "JWT"},
+	}
+
+	describer := &apiDescriber{document: document, schemas: openapi.NewSchemas(document.Components.Schemas)}
+	describer.schemas.Define(money.Decimal{}, &openapi.Schema{Type: "string", Format: "decimal",
+		Description: "A decimal number written as a string, empty when not set.", Pattern: `^(-?[0-9]+(\.[0-9]+)?)?$`})
+	describer.schemas.Define(api.StatusCreated, enumSchema(api.StatusCreated, api.StatusValidated,
+		api.StatusSubmitted, api.StatusSettled, api.StatusRejected, api.StatusReturned, api.StatusCancelled))
+	describer.schemas.Define(api.ApprovalPending, enumSchema(api.ApprovalPending, api.ApprovalApproved,
+		api.ApprovalRejected))
+	describer.schemas.Define(api.ActionCreated, enumSchema(api.ActionCreated, api.ActionUpdated,
+		api.ActionStatusChanged, api.ActionDeleted, api.ActionRestored, api.ActionPurged))
+	describer.errors = describer.schemas.For(&api.ErrorHolder{})
+
+	describer.describeOperations()
+	describer.describePayments("/v1/organisations/{org-id}/payments", "", "payments")
+	describer.describePayments("/v1/payment", "admin", "admin")
+	describer.describeList("/v1/payments", "adminListPayments", "admin")
+
+	return document
+}
+
+// enumSchema returns the schema of a string that must be one of the values.
+func enumSchema(values ...interface{}) *openapi.Schema {
+	schema := &openapi.Schema{Type: "string"}
+	for _, value := range values {
+		schema.Enum = append(schema.Enum, fmt.Sprint(value))
+	}
+
+	return schema
+}
+
+// describeOperations describes the endpoints, open to anyone, that orchestrators and monitoring use.
+func (describer *apiDescriber) describeOperations() {
+	health := describer.schemas.For(&api.Health{})
+	describer.add(http.MethodGet, "/healthz", &openapi.Operation{
+		OperationID: "getHealth",
+		Summary:     "Report the server is alive",
+		Responses:   map[string]*openapi.Response{"200": jsonResponse("The server is alive.", health)},
+	})
+	describer.add(http.MethodGet, "/readyz", &openapi.Operation{
+		OperationID: "getReadiness",
+		Summary:     "Report whether the server and its store can serve requests",
+		Responses: map[string]*openapi.Response{
+			"200": jsonResponse("The server can serve requests.", health),
+			"503": jsonResponse("The store did not answer.", health),
+		},
+	})
+	describer.add(http.MethodGet, "/version", &openapi.Operation{
+		OperationID: "getVersion",
+		Summary:     "Return the build of the server",
+		Responses: map[string]*openapi.Response{
+			"200": jsonResponse("The build of the server.", describer.schemas.For(&api.BuildInfo{})),
+		},
+	})
+	describer.add(http.MethodGet, "/metrics", &openapi.Operation{
+		OperationID: "getMetrics",
+		Summary:     "Return the metrics of the server in the Prometheus text format",
+		Responses: map[string]*openapi.Response{"200": {
+			Description: "The metrics of the server.",
+			Content:     map[string]*openapi.MediaType{metricsContentType: {Schema: &openapi.Schema{Type: "string"}}},
+		}},
+	})
+	describer.add(http.MethodGet, "/openapi.json", &openapi.Operation{
+		OperationID: "getOpenAPI",
+		Summary:     "Return this document",
+		Responses: map[string]*openapi.Response{
+			"200": jsonResponse("The OpenAPI document describing the API.", &openapi.Schema{Type: "object"}),
+		},
+	})
+}
+
+// describePayments describes the routes added by paymentRoutes under the prefix. The operation IDs are prefixed so
+// that they are unique across the prefixes.
+func (describer *apiDescriber) describePayments(prefix, idPrefix, tag string) {
+	payment := describer.schemas.For(&api.PaymentHolder{})
+	approval := describer.schemas.For(&api.ApprovalHolder{})
+	paymentBody := &openapi.RequestBody{Required: true, Content: jsonContent(describer.schemas.For(&api.Payment{}))}
+	item := prefix + "/{payment-id}"
+	if idPrefix == "" {
+		describer.describeList(prefix, "listPayments", tag)
+	}
+
+	describer.addPayments(http.MethodPost, prefix, &openapi.Operation{
+		OperationID: operationID(idPrefix, "createPayment"),
+		Summary:     "Create a payment",
+		Description: "A create made with an Idempotency-Key header can be retried safely, a retry with the same " +
+			"body is sent the response to the first request.",
+		Tags:        []string{tag},
+		Parameters:  []*openapi.Parameter{idempotencyKeyParameter()},
+		RequestBody: paymentBody,
+		Responses: describer.responses(paymentResponse(payment),
+			http.StatusBadRequest, http.StatusConflict, http.StatusRequestEntityTooLarge,
+			http.StatusUnprocessableEntity),
+	}, auth.OperationCreate)
+	describer.addPayments(http.MethodPut, prefix, &openapi.Operation{
+		OperationID: operationID(idPrefix, "updatePayment"),
+		Summary:     "Replace a payment",
+		Description: "The version being updated is taken from the If-Match header if one is given, otherwise from " +
+			"the payment.",
+		Tags:        []string{tag},
+		Parameters:  []*openapi.Parameter{ifMatchParameter()},
+		RequestBody: paymentBody,
+		Responses: describer.responses(paymentResponse(payment),
+			http.StatusBadRequest, http.StatusNotFound, http.StatusConflict, http.StatusPreconditionFailed,
+			http.StatusRequestEntityTooLarge, http.StatusUnprocessableEntity),
+	}, auth.OperationUpdate)
+	describer.addPayments(http.MethodGet, item, &openapi.Operation{
+		OperationID: operationID(idPrefix, "getPayment"),
+		Summary:     "Fetch a payment",
+		Tags:        []string{tag},
+		Parameters: []*openapi.Parameter{{
+			Name:        "version",
+			In:          openapi.InQuery,
+			Description: "Fetch an earlier version of the payment, found even if it has since been deleted.",
+			Schema:      &openapi.Schema{Type: "integer", Minimum: float(0)},
+		}},
+		Responses: describer.responses(paymentResponse(payment), http.StatusBadRequest, http.StatusNotFound),
+	}, auth.OperationRead)
+	describer.addPayments(http.MethodPatch, item, &openapi.Operation{
+		OperationID: operationID(idPrefix, "patchPayment"),
+		Summary:     "Change some fields of a payment",
+		Description: "The body is a JSON merge patch (RFC 7396) or a JSON patch (RFC 6902). The version is taken " +
+			"from the If-Match header if one is given, otherwise from the patched payment.",
+		Tags:       []string{tag},
+		Parameters: []*openapi.Parameter{ifMatchParameter()},
+		RequestBody: &openapi.RequestBody{Required: true, Content: map[string]*openapi.MediaType{
+			patch.MergePatchType: {Schema: &openapi.Schema{Type: "object"}},
+			"application/json":   {Schema: &openapi.Schema{Type: "object"}},
+			patch.JSONPatchType:  {Schema: &openapi.Schema{Type: "array", Items: jsonPatchOperationSchema()}},
+		}},
+		Responses: describer.responses(paymentResponse(payment),
+			http.StatusBadRequest, http.StatusNotFound, http.StatusConflict, http.StatusPreconditionFailed,
+			http.StatusRequestEntityTooLarge, http.StatusUnsupportedMediaType, http.StatusUnprocessableEntity),
+	}, auth.OperationUpdate)
+	describer.addPayments(http.MethodDelete, item, &openapi.Operation{
+		OperationID: operationID(idPrefix, "deletePayment"),
+		Summary:     "Delete a payment",
+		Description: "The payment is kept until the retention period has passed so that it can be restored.",
+		Tags:        []string{tag},
+		Responses: describer.responses(&openapi.Response{Description: "The payment was deleted."},
+			http.StatusNotFound),
+	}, auth.OperationDelete)
+	describer.addPayments(http.MethodGet, item+"/history", &openapi.Operation{
+		OperationID: operationID(idPrefix, "getPaymentHistory"),
+		Summary:     "Fetch the audit trail of a payment, oldest change first",
+		Tags:        []string{tag},
+		Responses: describer.responses(
+			jsonResponse("The audit trail of the payment.", describer.schemas.For(&api.HistoryHolder{})),
+			http.StatusNotFound),
+	}, auth.OperationRead)
+	describer.addPayments(http.MethodPost, item+"/restore", &openapi.Operation{
+		OperationID: operationID(idPrefix, "restorePayment"),
+		Summary:     "Restore a deleted payment",
+		Tags:        []string{tag},
+		Responses:   describer.responses(paymentResponse(payment), http.StatusNotFound, http.StatusConflict),
+	}, auth.OperationRestore)
+
+	describer.addPayments(http.MethodGet, item+"/approval", &openapi.Operation{
+		OperationID: operationID(idPrefix, "getPaymentApproval"),
+		Summary:     "Fetch the approval of a high value payment",
+		Tags:        []string{tag},
+		Responses: describer.responses(jsonResponse("The approval of the payment.", approval),
+			http.StatusNotFound),
+	}, auth.OperationRead)
+	decision := &openapi.RequestBody{Content: jsonContent(describer.schemas.For(&api.ApprovalDecisionRequest{}))}
+	for action, summary := range map[string]string{
+		"approve": "Approve a high value payment",
+		"reject":  "Reject a high value payment",
+	} {
+		describer.addPayments(http.MethodPost, item+"/approval/"+action, &openapi.Operation{
+			OperationID: operationID(idPrefix, action+"PaymentApproval"),
+			Summary:     summary,
+			Description: "The payment can not be approved or rejected by whoever created or changed it.",
+			Tags:        []string{tag},
+			RequestBody: decision,
+			Responses: describer.responses(jsonResponse("The decided approval.", approval),
+				http.StatusBadRequest, http.StatusNotFound, http.StatusConflict, http.StatusRequestEntityTooLarge),
+		}, auth.OperationApprove)
+	}
+
+	change := &openapi.RequestBody{Content: jsonContent(describer.schemas.For(&api.StatusChangeRequest{}))}
+	for action, status := range transitions {
+		describer.addPayments(http.MethodPost, item+"/"+action, &openapi.Operation{
+			OperationID: operationID(idPrefix, action+"Payment"),
+			Summary:     fmt.Sprintf("Move a payment to %s", status),
+			Tags:        []string{tag},
+			Parameters:  []*openapi.Parameter{ifMatchParameter()},
+			RequestBody: change,
+			Responses: describer.responses(paymentResponse(payment),
+				http.StatusBadRequest, http.StatusNotFound, http.StatusConflict, http.StatusPreconditionFailed,
+				http.StatusRequestEntityTooLarge),
+		}, auth.OperationTransition)
+	}
+}
+
+// describeList describes the listing of payments at the path.
+func (describer *apiDescriber) describeList(path, id, tag string) {
+	parameters := []*openapi.Parameter{
+		{Name: "page[number]", In: openapi.InQuery, Description: "The page to return, counting from 0.",
+			Schema: &openapi.Schema{Type: "integer", Minimum: float(0)}},
+		{Name: "page[size]", In: openapi.InQuery, Description: "The number of payments in each page.",
+			Schema: &openapi.Schema{Type: "integer", Minimum: float(1), Maximum: float(maxPageSize)}},
+		{Name: "sort", In: openapi.InQuery, Description: "The order of the payments, descending with a leading -.",
+			Schema: &openapi.Schema{Type: "string", Enum: []string{"id", "-id", "processing_date",
+				"-processing_date", "amount", "-amount"}}},
+	}
+	for _, filter := range []string{"organisation_id", "currency", "payment_scheme", "payment_type"} {
+		parameters = append(parameters, &openapi.Parameter{Name: "filter[" + filter + "]", In: openapi.InQuery,
+			Schema: &openapi.Schema{Type: "string"}})
+	}
+	for _, filter := range []string{"processing_date_from", "processing_date_to"} {
+		parameters = append(parameters, &openapi.Parameter{Name: "filter[" + filter + "]", In: openapi.InQuery,
+			Schema: &openapi.Schema{Type: "string", Format: "date"}})
+	}
+	parameters = append(parameters, &openapi.Parameter{Name: "filter[include_deleted]", In: openapi.InQuery,
+		Description: "List deleted payments too.", Schema: &openapi.Schema{Type: "boolean"}})
+
+	describer.addPayments(http.MethodGet, path, &openapi.Operation{
+		OperationID: id,
+		Summary:     "List a page of payments",
+		Tags:        []string{tag},
+		Parameters:  parameters,
+		Responses: describer.responses(
+			jsonResponse("A page of payments with links to the other pages.", describer.schemas.For(&api.ListHolder{})),
+			http.StatusBadRequest),
+	}, auth.OperationRead)
+}
+
+// add adds the operation on the path to the document, along with a parameter for each variable in the path.
+func (describer *apiDescriber) add(method, path string, operation *openapi.Operation) {
+	var parameters []*openapi.Parameter
+	for _, match := range pathParameter.FindAllStringSubmatch(path, -1) {
+		parameters = append(parameters, &openapi.Parameter{
+			Name:        match[1],
+			In:          openapi.InPath,
+			Description: pathParameterDescriptions[match[1]],
+			Required:    true,
+			Schema:      &openapi.Schema{Type: "string"},
+		})
+	}
+	operation.Parameters = append(parameters, operation.Parameters...)
+	describer.document.AddOperation(method, path, operation)
+}
+
+// addPayments adds an operation on payments, which needs an authenticated caller allowed to perform the operation.
+func (describer *apiDescriber) addPayments(method, path string, operation *openapi.Operation,
+	allowed auth.Operation) {
+	operation.Description = strings.TrimSpace(fmt.Sprintf("%s The caller needs a role allowed to %s payments.",
+		operation.Description, allowed))
+	operation.Security = []openapi.SecurityRequirement{{apiKeyScheme: {}}, {bearerScheme: {}}}
+	describer.add(method, path, operation)
+}
+
+// pathParameterDescriptions describes the variables in the route paths.
+var pathParameterDescriptions = map[string]string{
+	"org-id":     "The ID of the organisation owning the payments.",
+	"payment-id": "The ID of the payment.",
+}
+
+// responses returns the responses to an operation on payments, the result and an error for each status along with
+// the errors every operation on payments can return.
+func (describer *apiDescriber) responses(result *openapi.Response, statuses ...int) map[string]*openapi.Response {
+	responses := map[string]*openapi.Response{"200": result}
+	statuses = append(statuses, http.StatusUnauthorized, http.StatusForbidden, http.StatusInternalServerError,
+		http.StatusServiceUnavailable)
+	for _, status := range statuses {
+		responses[strconv.Itoa(status)] = jsonResponse(http.StatusText(status)+".", describer.errors)
+	}
+
+	return responses
+}
+
+// paymentResponse returns the response holding a single payment.
+func paymentResponse(payment *openapi.Schema) *openapi.Response {
+	response := jsonResponse("The payment with a link to it.", payment)
+	response.Headers = map[string]*openapi.Header{
+		"ETag": {
+			Description: "The version of the payment, to send as If-Match.",
+			Schema:      &openapi.Schema{Type: "string"},
+		},
+	}
+
+	return response
+}
+
+// jsonResponse returns a response with a JSON body.
+func jsonResponse(description string, schema *openapi.Schema) *openapi.Response {
+	return &openapi.Response{Description: description, Content: jsonContent(schema)}
+}
+
+// jsonContent returns the content of a JSON body.
+func jsonContent(schema *openapi.Schema) map[string]*openapi.MediaType {
+	return map[string]*openapi.MediaType{"application/json": {Schema: schema}}
+}
+
+// ifMatchParameter returns the If-Match header naming the version of the payment being changed.
+func ifMatchParameter() *openapi.Parameter {
+	return &openapi.Parameter{
+		Name:        "If-Match",
+		In:          openapi.InHeader,
+		Description: "The ETag of the version of the payment being changed, or * for whichever is current.",
+		Schema:      &openapi.Schema{Type: "string"},
+	}
+}
+
+// idempotencyKeyParameter returns the Idempotency-Key header making a create safe to retry.
+func idempotencyKeyParameter() *openapi.Parameter {
+	return &openapi.Parameter{
+		Name:        "Idempotency-Key",
+		In:          openapi.InHeader,
+		Description: "A key unique to the payment being created, so that the request can be retried.",
+		Schema:      &openapi.Schema{Type: "string", Pattern: fmt.Sprintf("^.{1,%d}$", maxIdempotencyKeyLength)},
+	}
+}
+
+// jsonPatchOperationSchema returns the schema of a single JSON patch operation.
+func jsonPatchOperationSchema() *openapi.Schema {
+	return &openapi.Schema{Type: "object", Properties: map[string]*openapi.Schema{
+		"op":    {Type: "string", Enum: []string{"add", "remove", "replace", "move", "copy", "test"}},
+		"path":  {Type: "string", Description: "A JSON pointer (RFC 6901) to the field to change."},
+		"from":  {Type: "string", Description: "A JSON pointer to the field moved or copied."},
+		"value": {},
+	}}
+}
+
+// operationID returns the operation ID with the prefix, if there is one, so that the IDs of the same operation under
+// different routes are unique.
+func operationID(prefix, id string) string {
+	if prefix == "" {
+		return id
+	}
+
+	return prefix + strings.ToUpper(id[:1]) + id[1:]
+}
+
+// float returns a pointer to the number, for the bounds of schemas.
+func float(number float64) *float64 {
+	return &number
+}
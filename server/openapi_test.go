@@ -0,0 +1,110 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/cdempsie/payments-example/persist"
+	"github.com/gorilla/mux"
+)
+
+// openAPIFile is the published OpenAPI document, which the served document must match.
+const openAPIFile = "../doc/openapi.json"
+
+var update = flag.Bool("update", false, "rewrite "+openAPIFile+" from the served OpenAPI document")
+
+func TestOpenAPIDescribesEveryRoute(t *testing.T) {
+	srv := New(persist.NewInMemoryStore(), nil)
+
+	routes := map[string]bool{}
+	err := srv.router.Walk(func(route *mux.Route, router *mux.Router, ancestors []*mux.Route) error {
+		methods, err := route.GetMethods()
+		if err != nil {
+			// path prefixes of subrouters
+			return nil
+		}
+		path, err := route.GetPathTemplate()
+		if err != nil {
+			return err
+		}
+		for _, method := range methods {
+			routes[method+" "+path] = true
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("failed to walk routes: %v", err)
+	}
+
+	described := map[string]bool{}
+	operationIDs := map[string]string{}
+	for path, item := range srv.openAPI.Paths {
+		for method, operation := range item {
+			route := strings.ToUpper(method) + " " + path
+			described[route] = true
+			if other, ok := operationIDs[operation.OperationID]; ok {
+				t.Errorf("%s and %s have the same operation ID %s", other, route, operation.OperationID)
+			}
+			operationIDs[operation.OperationID] = route
+		}
+	}
+
+	for _, route := range sortedKeys(routes) {
+		if !described[route] {
+			t.Errorf("route %s is not described by the OpenAPI document", route)
+		}
+	}
+	for _, route := range sortedKeys(described) {
+		if !routes[route] {
+			t.Errorf("the OpenAPI document describes %s which is not routed", route)
+		}
+	}
+}
+
+func TestOpenAPIDocument(t *testing.T) {
+	srv := New(persist.NewInMemoryStore(), nil)
+	req, err := http.NewRequest(http.MethodGet, "/openapi.json", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	recorder := httptest.NewRecorder()
+	srv.ServeHTTP(recorder, req)
+	if status := recorder.Code; status != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v", status, http.StatusOK)
+	}
+
+	var served bytes.Buffer
+	if err := json.Indent(&served, recorder.Body.Bytes(), "", "  "); err != nil {
+		t.Fatalf("expected the document as JSON: %v", err)
+	}
+	if *update {
+		if err := ioutil.WriteFile(openAPIFile, served.Bytes(), 0644); err != nil {
+			t.Fatalf("failed to write %s: %v", openAPIFile, err)
+		}
+	}
+	published, err := ioutil.ReadFile(openAPIFile)
+	if err != nil {
+		t.Fatalf("failed to read %s: %v", openAPIFile, err)
+	}
+	if !bytes.Equal(served.Bytes(), published) {
+		t.Errorf("the served OpenAPI document does not match %s, if the routes or api types were changed on purpose "+
+			"run go test ./server -run TestOpenAPIDocument -update and review the difference", openAPIFile)
+	}
+}
+
+func sortedKeys(set map[string]bool) []string {
+	keys := make([]string, 0, len(set))
+	for key := range set {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
@@ -1,177 +1,1161 @@
-package main
+// Package server serves the payments API over HTTP, it can be run on its own with cmd/server or embedded in another
+// program with New.
+package server
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
-	"flag"
+	"errors"
 	"fmt"
-	"log"
+	"io"
+	"io/ioutil"
+	"log/slog"
+	"mime"
 	"net/http"
+	"net/url"
 	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/cdempsie/payments-example/api"
+	"github.com/cdempsie/payments-example/approval"
+	"github.com/cdempsie/payments-example/audit"
+	"github.com/cdempsie/payments-example/auth"
 	payment_handler "github.com/cdempsie/payments-example/handler"
+	"github.com/cdempsie/payments-example/idempotency"
+	"github.com/cdempsie/payments-example/logging"
+	"github.com/cdempsie/payments-example/openapi"
+	"github.com/cdempsie/payments-example/patch"
 	"github.com/cdempsie/payments-example/persist"
+	"github.com/google/uuid"
 	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.39.0"
+	"go.opentelemetry.io/otel/trace"
 )
 
-var (
-	handler *payment_handler.PaymentHandler
-	port    int
-	store   string
+const (
+	// defaultPageSize is the number of payments listed when the page size is not given.
+	defaultPageSize = 100
+	// maxPageSize is the largest number of payments that can be listed at once.
+	maxPageSize = 1000
+	// isoDate is the layout of the dates used by the API.
+	isoDate = "2006-01-02"
+	// maxIdempotencyKeyLength is the longest Idempotency-Key header accepted.
+	maxIdempotencyKeyLength = 255
+	// anonymousActor is recorded when a request does not say who is making it.
+	anonymousActor = "anonymous"
+	// requestIDHeader carries the ID of a request, recorded against the audit events it causes.
+	requestIDHeader = "X-Request-ID"
+	// maxRequestIDLength is the longest X-Request-ID header propagated, longer ones are replaced.
+	maxRequestIDLength = 128
+	// readinessTimeout is how long the store is given to answer a readiness check.
+	readinessTimeout = 2 * time.Second
+	// tracerName identifies the spans started by the server.
+	tracerName = "github.com/cdempsie/payments-example/server"
+	// DefaultMaxBodyBytes is the largest request body accepted unless the server is given another limit.
+	DefaultMaxBodyBytes = 1 << 20
 )
 
-func init() {
-	flag.StringVar(&store, "store", "in-memory", "The persitance store to use, the default and only option at the moment is in-memory")
-	flag.IntVar(&port, "port", 8000, "The port number to start the server on, defaults to 8000")
+// Statuses reported by the health endpoints.
+const (
+	healthOK          = "ok"
+	healthUnavailable = "unavailable"
+)
+
+// Codes identifying the kind of problem in error responses.
+const (
+	codeBadRequest         = "bad_request"
+	codeValidationFailed   = "validation_failed"
+	codeNotFound           = "not_found"
+	codeConflict           = "conflict"
+	codeAlreadyExists      = "already_exists"
+	codePreconditionFailed = "precondition_failed"
+	codeInvalid            = "invalid"
+	codeUnsupportedMedia   = "unsupported_media_type"
+	codeTooLarge           = "request_too_large"
+	codeKeyReused          = "idempotency_key_reused"
+	codeKeyInProgress      = "idempotency_key_in_progress"
+	codeIllegalTransition  = "illegal_transition"
+	codeNotDeleted         = "not_deleted"
+	codeUnauthenticated    = "unauthenticated"
+	codeForbidden          = "forbidden"
+	codePermissionDenied   = "permission_denied"
+	codeApprovalRequired   = "approval_required"
+	codeSelfApproval       = "self_approval"
+	codeNoPendingApproval  = "approval_not_pending"
+	codeInternalError      = "internal_error"
+	codeTimeout            = "timeout"
+	codeCancelled          = "cancelled"
+)
+
+// Server serves the payments API over HTTP. It is an http.Handler so it can be run with http.ListenAndServe, mounted
+// under another router or exercised with httptest.
+type Server struct {
+	store           persist.PaymentStore
+	handler         *payment_handler.PaymentHandler
+	handlerOptions  []payment_handler.Option
+	authenticator   auth.Authenticator
+	policy          *auth.Policy
+	idempotencyKeys *idempotency.Cache
+	maxBodyBytes    int64
+	requestTimeout  time.Duration
+	closers         []io.Closer
+	buildInfo       api.BuildInfo
+	metricsRegistry *prometheus.Registry
+	metrics         *serverMetrics
+	tracerProvider  trace.TracerProvider
+	tracer          trace.Tracer
+	propagator      propagation.TextMapPropagator
+	logger          *slog.Logger
+	openAPI         *openapi.Document
+	router          *mux.Router
+}
+
+// Option configures optional features of the server.
+type Option func(*Server)
+
+// WithAuthenticator identifies callers with the authenticator. Without it every request to the API is refused with a
+// 401 unauthorized, auth.Headers trusts the headers set by an authenticating gateway in front of the server.
+func WithAuthenticator(authenticator auth.Authenticator) Option {
+	return func(server *Server) {
+		server.authenticator = authenticator
+	}
+}
+
+// WithPolicy decides which roles can perform each operation on payments with the policy, instead of the default one.
+func WithPolicy(policy *auth.Policy) Option {
+	return func(server *Server) {
+		server.policy = policy
+	}
+}
+
+// WithIdempotencyTTL replays the response to a create with an Idempotency-Key for the TTL, instead of
+// idempotency.DefaultTTL.
+func WithIdempotencyTTL(ttl time.Duration) Option {
+	return func(server *Server) {
+		server.idempotencyKeys = idempotency.NewCache(ttl)
+	}
+}
+
+// WithAuditLog records the changes made to payments in the audit log, instead of in memory.
+func WithAuditLog(auditLog audit.Log) Option {
+	return func(server *Server) {
+		server.handlerOptions = append(server.handlerOptions, payment_handler.WithAuditLog(auditLog))
+	}
+}
+
+// WithApprovals requires payments above the thresholds to be approved by a second person, keeping the approvals in
+// the store.
+func WithApprovals(approvals approval.Store, thresholds approval.Thresholds) Option {
+	return func(server *Server) {
+		server.handlerOptions = append(server.handlerOptions, payment_handler.WithApprovals(approvals, thresholds))
+	}
+}
+
+// WithMaxBodyBytes rejects requests with bodies larger than the limit with a 413 request entity too large, instead of
+// those larger than DefaultMaxBodyBytes. A limit of 0 or less accepts bodies of any size.
+func WithMaxBodyBytes(limit int64) Option {
+	return func(server *Server) {
+		server.maxBodyBytes = limit
+	}
+}
+
+// WithRequestTimeout gives up on the work for a request, such as reading or changing payments in the store, once it
+// has taken longer than the timeout, sending a 503 service unavailable. By default requests are only abandoned when
+// the client goes away.
+func WithRequestTimeout(timeout time.Duration) Option {
+	return func(server *Server) {
+		server.requestTimeout = timeout
+	}
+}
+
+// WithCloser closes the closer, such as the payment store or audit log, when the server is closed so that it can
+// flush anything it holds to disk. Closers are closed in the reverse of the order they were given in.
+func WithCloser(closer io.Closer) Option {
+	return func(server *Server) {
+		server.closers = append(server.closers, closer)
+	}
+}
+
+// WithBuildInfo reports the build at /version, usually the version, commit and date set in the main package at link
+// time. The Go version is filled in if it is not given.
+func WithBuildInfo(buildInfo api.BuildInfo) Option {
+	return func(server *Server) {
+		server.buildInfo = buildInfo
+	}
+}
+
+// WithTracerProvider records a span for each request, and each operation on the payment store made for it, with the
+// provider instead of the global OpenTelemetry one.
+func WithTracerProvider(provider trace.TracerProvider) Option {
+	return func(server *Server) {
+		server.tracerProvider = provider
+	}
+}
+
+// New returns a server for the payments in the store, logging to the logger. A nil logger logs JSON to standard
+// error.
+func New(store persist.PaymentStore, logger *slog.Logger, options ...Option) *Server {
+	if logger == nil {
+		logger = logging.NewJSON(os.Stderr)
+	}
+	server := &Server{
+		store:           store,
+		authenticator:   auth.Chain{},
+		policy:          auth.DefaultPolicy(),
+		idempotencyKeys: idempotency.NewCache(idempotency.DefaultTTL),
+		maxBodyBytes:    DefaultMaxBodyBytes,
+		tracerProvider:  otel.GetTracerProvider(),
+		propagator:      propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}),
+		logger:          logger,
+	}
+	for _, option := range options {
+		option(server)
+	}
+	if server.buildInfo.GoVersion == "" {
+		server.buildInfo.GoVersion = runtime.Version()
+	}
+	if server.metricsRegistry == nil {
+		server.metricsRegistry = prometheus.NewRegistry()
+	}
+	server.metrics = newServerMetrics(server.metricsRegistry)
+	server.tracer = server.tracerProvider.Tracer(tracerName)
+	instrumented := persist.NewInstrumentedStore(store, server.metrics.observeStore)
+	server.handler = payment_handler.NewPaymentHandler(persist.NewTracedStore(instrumented, server.tracer),
+		server.handlerOptions...)
+	if counter, ok := store.(persist.PaymentCounter); ok {
+		server.metricsRegistry.MustRegister(newPaymentCounts(counter, server.logger))
+	}
+	server.openAPI = newOpenAPIDocument(server.buildInfo.Version)
+	server.router = server.newRouter()
+
+	return server
+}
+
+// ServeHTTP serves a request to the API.
+func (server *Server) ServeHTTP(responseWriter http.ResponseWriter, request *http.Request) {
+	server.router.ServeHTTP(responseWriter, request)
+}
+
+// Close closes everything given to the server with WithCloser, it should be called once the server has stopped
+// handling requests. The first error is returned but every closer is closed.
+func (server *Server) Close() error {
+	var firstErr error
+	for i := len(server.closers) - 1; i >= 0; i-- {
+		if err := server.closers[i].Close(); err != nil {
+			server.logger.Error("Failed to close", "error", err)
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+	server.closers = nil
+
+	return firstErr
 }
 
-func main() {
+// newRouter returns the router serving the API. Callers reach the payments of their own organisation under
+// /v1/organisations/{org-id}/payments, the original routes reach the payments of every organisation so are only open
+// to admin callers. The health and version endpoints are open to anyone so that orchestrators can use them, as is the
+// OpenAPI document describing every route, which must be kept in step with them.
+func (server *Server) newRouter() *mux.Router {
 	router := mux.NewRouter()
-	paymentSubRoute := router.PathPrefix("/v1/payment").Subrouter()
+	router.Use(server.traceRequests, server.logRequests, server.measureRequests)
+	router.HandleFunc("/healthz", server.healthHandler).Methods(http.MethodGet)
+	router.HandleFunc("/readyz", server.readyHandler).Methods(http.MethodGet)
+	router.HandleFunc("/version", server.versionHandler).Methods(http.MethodGet)
+	router.Handle("/metrics", promhttp.HandlerFor(server.metricsRegistry, promhttp.HandlerOpts{})).Methods(http.MethodGet)
+	router.HandleFunc("/openapi.json", server.openAPIHandler).Methods(http.MethodGet)
+
+	apiRoute := router.PathPrefix("/v1").Subrouter()
+	apiRoute.Use(server.limitBody, server.limitTime, server.authenticate)
+
+	// Payments of a single organisation
+	organisationSubRoute := apiRoute.PathPrefix("/organisations/{org-id}/payments").Subrouter()
+	organisationSubRoute.Use(requireOrganisation)
+	organisationSubRoute.HandleFunc("", server.authorize(auth.OperationRead, server.listPaymentsHandler)).
+		Methods(http.MethodGet)
+	server.paymentRoutes(organisationSubRoute)
+
+	// Payments of every organisation
+	paymentSubRoute := apiRoute.PathPrefix("/payment").Subrouter()
+	paymentSubRoute.Use(requireAdmin)
+	server.paymentRoutes(paymentSubRoute)
+	apiRoute.Handle("/payments", requireAdmin(server.authorize(auth.OperationRead, server.listPaymentsHandler))).
+		Methods(http.MethodGet)
+
+	return router
+}
+
+// healthHandler reports the server is alive, it does not check the store so that a store outage does not get the
+// server restarted.
+func (server *Server) healthHandler(responseWriter http.ResponseWriter, request *http.Request) {
+	writeJSON(responseWriter, http.StatusOK, &api.Health{Status: healthOK})
+}
+
+// readyHandler reports whether the server can serve requests, pinging the store if it can report its health. If the
+// store does not answer a 503 service unavailable is returned, why is only logged as the endpoint is open to anyone.
+func (server *Server) readyHandler(responseWriter http.ResponseWriter, request *http.Request) {
+	if checker, ok := server.store.(persist.HealthChecker); ok {
+		ctx, cancel := context.WithTimeout(request.Context(), readinessTimeout)
+		defer cancel()
+		if err := checker.Ping(ctx); err != nil {
+			logging.FromContext(request.Context()).Warn("Store is not ready", "error", err)
+			writeJSON(responseWriter, http.StatusServiceUnavailable, &api.Health{Status: healthUnavailable})
+			return
+		}
+	}
+
+	writeJSON(responseWriter, http.StatusOK, &api.Health{Status: healthOK})
+}
+
+// versionHandler returns the build of the server.
+func (server *Server) versionHandler(responseWriter http.ResponseWriter, request *http.Request) {
+	writeResult(responseWriter, &server.buildInfo)
+}
+
+// transitions maps the action in the path of each lifecycle endpoint to the status it moves the payment to. The
+// routes are added from it by paymentRoutes and described from it in the OpenAPI document.
+var transitions = map[string]api.Status{
+	"validate": api.StatusValidated,
+	"submit":   api.StatusSubmitted,
+	"settle":   api.StatusSettled,
+	"reject":   api.StatusRejected,
+	"return":   api.StatusReturned,
+	"cancel":   api.StatusCancelled,
+}
+
+// paymentRoutes adds the routes for creating and changing single payments to the router, each checking the caller
+// can perform its operation.
+func (server *Server) paymentRoutes(router *mux.Router) {
 	// CRUD for payment
-	paymentSubRoute.HandleFunc("", createPaymentHandler).Methods(http.MethodPost)
-	paymentSubRoute.HandleFunc("", updatePaymentHandler).Methods(http.MethodPut)
-	paymentSubRoute.HandleFunc("/{payment-id}", getPaymentHandler).Methods(http.MethodGet)
-	paymentSubRoute.HandleFunc("/{payment-id}", deletePaymentHandler).Methods(http.MethodDelete)
+	router.HandleFunc("", server.authorize(auth.OperationCreate, server.idempotent(server.createPaymentHandler))).
+		Methods(http.MethodPost)
+	router.HandleFunc("", server.authorize(auth.OperationUpdate, server.updatePaymentHandler)).Methods(http.MethodPut)
+	router.HandleFunc("/{payment-id}", server.authorize(auth.OperationRead, server.getPaymentHandler)).
+		Methods(http.MethodGet)
+	router.HandleFunc("/{payment-id}", server.authorize(auth.OperationUpdate, server.patchPaymentHandler)).
+		Methods(http.MethodPatch)
+	router.HandleFunc("/{payment-id}", server.authorize(auth.OperationDelete, server.deletePaymentHandler)).
+		Methods(http.MethodDelete)
+	router.HandleFunc("/{payment-id}/history", server.authorize(auth.OperationRead, server.historyHandler)).
+		Methods(http.MethodGet)
+	router.HandleFunc("/{payment-id}/restore", server.authorize(auth.OperationRestore, server.restorePaymentHandler)).
+		Methods(http.MethodPost)
+
+	// Four-eyes approval of high value payments
+	router.HandleFunc("/{payment-id}/approval", server.authorize(auth.OperationRead, server.approvalHandler)).
+		Methods(http.MethodGet)
+	router.HandleFunc("/{payment-id}/approval/approve",
+		server.authorize(auth.OperationApprove, server.decisionHandler(true))).Methods(http.MethodPost)
+	router.HandleFunc("/{payment-id}/approval/reject",
+		server.authorize(auth.OperationApprove, server.decisionHandler(false))).Methods(http.MethodPost)
+
+	// Lifecycle of a payment
+	for action, status := range transitions {
+		router.HandleFunc("/{payment-id}/"+action,
+			server.authorize(auth.OperationTransition, server.transitionHandler(status))).Methods(http.MethodPost)
+	}
+}
+
+// createPaymentHandler creates a new payment with the given details.
+// If the request is badly formed a 400 bad request is returned.
+func (server *Server) createPaymentHandler(responseWriter http.ResponseWriter, request *http.Request) {
+	payment, ok := decodePayment(responseWriter, request)
+	if !ok {
+		return
+	}
+
+	err := server.requestHandler(request).Create(requestContext(request), payment)
+	if err != nil {
+		writeStoreError(responseWriter, err, "failed to create payment")
+		return
+	}
+
+	writePayment(responseWriter, request, payment)
+}
+
+// idempotent makes a handler safe to retry when the request has an Idempotency-Key header.
+// The first request made with a key is handled and its response remembered, a retry with the same method, path and
+// body is sent the remembered response, marked with an Idempotent-Replayed header, without being handled again.
+// A key reused for a different request returns 422 unprocessable entity and one used while the first request is still
+// being handled 409 conflict. Server errors are not remembered so the request can be retried.
+func (server *Server) idempotent(next http.HandlerFunc) http.HandlerFunc {
+	return func(responseWriter http.ResponseWriter, request *http.Request) {
+		key := request.Header.Get("Idempotency-Key")
+		if key == "" {
+			next(responseWriter, request)
+			return
+		}
+		if len(key) > maxIdempotencyKeyLength {
+			writeError(responseWriter, http.StatusBadRequest, codeBadRequest,
+				fmt.Sprintf("the Idempotency-Key header must be at most %d characters", maxIdempotencyKeyLength), nil)
+			return
+		}
 
-	// Collection of payments
-	router.HandleFunc("/v1/payments", listPaymentsHandler).Methods(http.MethodGet)
+		var body []byte
+		if request.Body != nil {
+			var err error
+			if body, err = ioutil.ReadAll(request.Body); err != nil {
+				writeBodyError(responseWriter, err)
+				return
+			}
+			request.Body = ioutil.NopCloser(bytes.NewReader(body))
+		}
 
-	if err := configure(); err != nil {
-		fmt.Println(err)
-		os.Exit(1)
+		// keys are chosen by callers so each organisation has its own
+		key = requestPrincipal(request).OrganisationID + " " + key
+		fingerprint := idempotency.Fingerprint([]byte(request.Method), []byte(request.URL.Path), body)
+		replay, err := server.idempotencyKeys.Start(key, fingerprint)
+		switch {
+		case errors.Is(err, idempotency.ErrKeyReused):
+			writeError(responseWriter, http.StatusUnprocessableEntity, codeKeyReused, err.Error(), nil)
+			return
+		case errors.Is(err, idempotency.ErrInProgress):
+			writeError(responseWriter, http.StatusConflict, codeKeyInProgress, err.Error(), nil)
+			return
+		case replay != nil:
+			for name, values := range replay.Header {
+				responseWriter.Header()[name] = values
+			}
+			responseWriter.Header().Set("Idempotent-Replayed", "true")
+			responseWriter.WriteHeader(replay.Status)
+			responseWriter.Write(replay.Body)
+			return
+		}
+
+		finished := false
+		defer func() {
+			// released when the request failed, or the handler panicked, so that it can be retried
+			if !finished {
+				server.idempotencyKeys.Abandon(key)
+			}
+		}()
+		recorder := &responseRecorder{ResponseWriter: responseWriter, status: http.StatusOK}
+		next(recorder, request)
+		if recorder.status >= http.StatusInternalServerError {
+			return
+		}
+		header := http.Header{}
+		for _, name := range []string{"Content-Type", "ETag"} {
+			if value := responseWriter.Header().Get(name); value != "" {
+				header.Set(name, value)
+			}
+		}
+		server.idempotencyKeys.Finish(key, idempotency.Response{Status: recorder.status, Header: header,
+			Body: recorder.body.Bytes()})
+		finished = true
 	}
+}
 
-	// start the server, defaults to :8000
-	portStr := fmt.Sprintf(":%d", port)
-	log.Fatal(http.ListenAndServe(portStr, router))
+// requestLog collects what the access log line of a request reports as it is handled. Layers that learn more about
+// the request, such as who made it or the ID of a payment it created, fill it in.
+type requestLog struct {
+	paymentID      string
+	organisationID string
 }
 
-// configure will parse the command line flags and setup the handler with the requested persistent store type.
-func configure() error {
-	if err := parseFlags(); err != nil {
-		return err
+type contextKey int
+
+const requestLogKey contextKey = iota
+
+// noteRequest records the payment ID and organisation of the request for its access log, empty values are ignored.
+func noteRequest(request *http.Request, paymentID, organisationID string) {
+	entry, ok := request.Context().Value(requestLogKey).(*requestLog)
+	if !ok {
+		return
+	}
+	if paymentID != "" {
+		entry.paymentID = paymentID
+	}
+	if organisationID != "" && entry.organisationID == "" {
+		entry.organisationID = organisationID
 	}
+}
 
-	// more store types could be added here for example DB, file, etc
-	if store == "in-memory" {
-		handler = payment_handler.NewPaymentHandler(persist.NewInMemoryStore())
-	} else {
-		return fmt.Errorf("unknown store type requested: %s", store)
+// logRequests gives each request an ID, taken from its X-Request-ID header or generated, which is sent back in the
+// response, recorded against the audit events it causes and added to every line logged while handling it. Once the
+// request has been handled a JSON access log line reports its method, route, status, latency, payment and
+// organisation.
+func (server *Server) logRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(responseWriter http.ResponseWriter, request *http.Request) {
+		start := time.Now()
+		requestID := request.Header.Get(requestIDHeader)
+		if !validRequestID(requestID) {
+			requestID = uuid.New().String()
+		}
+		responseWriter.Header().Set(requestIDHeader, requestID)
+
+		route := routeTemplate(request)
+		vars := mux.Vars(request)
+		entry := &requestLog{paymentID: vars["payment-id"], organisationID: vars["org-id"]}
+		logger := server.logger.With("request_id", requestID)
+		if spanContext := trace.SpanContextFromContext(request.Context()); spanContext.IsValid() {
+			logger = logger.With("trace_id", spanContext.TraceID().String(), "span_id", spanContext.SpanID().String())
+		}
+		ctx := logging.WithLogger(audit.WithRequestID(request.Context(), requestID), logger)
+		ctx = context.WithValue(ctx, requestLogKey, entry)
+
+		writer := &statusWriter{ResponseWriter: responseWriter, status: http.StatusOK}
+		next.ServeHTTP(writer, request.WithContext(ctx))
+
+		level := slog.LevelInfo
+		if writer.status >= http.StatusInternalServerError {
+			level = slog.LevelError
+		}
+		logger.LogAttrs(ctx, level, "Handled request",
+			slog.String("method", request.Method),
+			slog.String("route", route),
+			slog.Int("status", writer.status),
+			slog.Float64("latency_ms", float64(time.Since(start).Microseconds())/1000),
+			slog.String("payment_id", entry.paymentID),
+			slog.String("organisation_id", entry.organisationID),
+		)
+	})
+}
+
+// traceRequests records a span for each request, named after its route, continuing the trace of the caller when the
+// request has a W3C traceparent header. Requests that fail with a 5xx status are marked as errors.
+func (server *Server) traceRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(responseWriter http.ResponseWriter, request *http.Request) {
+		route := routeTemplate(request)
+		ctx := server.propagator.Extract(request.Context(), propagation.HeaderCarrier(request.Header))
+		ctx, span := server.tracer.Start(ctx, request.Method+" "+route,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				semconv.HTTPRequestMethodKey.String(request.Method),
+				semconv.HTTPRoute(route),
+				semconv.URLPath(request.URL.Path),
+			))
+		defer span.End()
+
+		writer := &statusWriter{ResponseWriter: responseWriter, status: http.StatusOK}
+		next.ServeHTTP(writer, request.WithContext(ctx))
+
+		span.SetAttributes(semconv.HTTPResponseStatusCode(writer.status))
+		if writer.status >= http.StatusInternalServerError {
+			span.SetStatus(codes.Error, http.StatusText(writer.status))
+		}
+	})
+}
+
+// routeTemplate returns the template of the route the request matched, such as /v1/payment/{payment-id}, so that
+// requests for different payments are reported together. The path is returned if no route matched.
+func routeTemplate(request *http.Request) string {
+	if current := mux.CurrentRoute(request); current != nil {
+		if template, err := current.GetPathTemplate(); err == nil {
+			return template
+		}
 	}
-	return nil
+
+	return request.URL.Path
 }
 
-// parseFlags parses the command line flags returning any errors.
-func parseFlags() error {
-	flag.Parse()
-	if store != "in-memory" {
-		return fmt.Errorf("invalid store value: %s only \"in-memory\" is currently supported", store)
+// validRequestID reports whether a request ID sent by a client is safe to propagate: not empty, not too long and only
+// printable ASCII so that it can not forge log lines.
+func validRequestID(requestID string) bool {
+	if requestID == "" || len(requestID) > maxRequestIDLength {
+		return false
+	}
+	for i := 0; i < len(requestID); i++ {
+		if requestID[i] < ' ' || requestID[i] > '~' {
+			return false
+		}
 	}
 
-	return nil
+	return true
 }
 
-// createPaymentHandler creates a new payment with the given details.
+// statusWriter passes a response through to the client while keeping its status.
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (writer *statusWriter) WriteHeader(status int) {
+	writer.status = status
+	writer.ResponseWriter.WriteHeader(status)
+}
+
+// limitBody stops the handlers reading more than the maximum body size from a request.
+func (server *Server) limitBody(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(responseWriter http.ResponseWriter, request *http.Request) {
+		if server.maxBodyBytes > 0 && request.Body != nil {
+			request.Body = http.MaxBytesReader(responseWriter, request.Body, server.maxBodyBytes)
+		}
+		next.ServeHTTP(responseWriter, request)
+	})
+}
+
+// limitTime cancels the context of each request once it has run for longer than the request timeout, if there is one,
+// so that the store gives up on it.
+func (server *Server) limitTime(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(responseWriter http.ResponseWriter, request *http.Request) {
+		if server.requestTimeout > 0 {
+			ctx, cancel := context.WithTimeout(request.Context(), server.requestTimeout)
+			defer cancel()
+			request = request.WithContext(ctx)
+		}
+		next.ServeHTTP(responseWriter, request)
+	})
+}
+
+// responseRecorder passes a response through to the client while keeping a copy of its status and body.
+type responseRecorder struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (recorder *responseRecorder) WriteHeader(status int) {
+	recorder.status = status
+	recorder.ResponseWriter.WriteHeader(status)
+}
+
+func (recorder *responseRecorder) Write(data []byte) (int, error) {
+	recorder.body.Write(data)
+	return recorder.ResponseWriter.Write(data)
+}
+
+// updatePaymentHandler updates the payment with the given details.
 // If the request is badly formed a 400 bad request is returned.
-func createPaymentHandler(responseWriter http.ResponseWriter, request *http.Request) {
+// The version being updated is taken from the If-Match header if one is given, otherwise from the payment itself.
+// If the version is not the current version of the payment a 409 conflict is returned, or a 412 precondition failed
+// when If-Match was used.
+func (server *Server) updatePaymentHandler(responseWriter http.ResponseWriter, request *http.Request) {
+	payment, ok := decodePayment(responseWriter, request)
+	if !ok {
+		return
+	}
+
+	ifMatch := strings.TrimSpace(request.Header.Get("If-Match"))
+	if ifMatch == "*" {
+		// matches whatever the current version is, as long as the payment exists
+		current, err := server.requestHandler(request).Load(requestContext(request), payment.ID)
+		if errors.Is(err, persist.ErrNotFound) {
+			writeError(responseWriter, http.StatusPreconditionFailed, codePreconditionFailed,
+				fmt.Sprintf("failed to update payment: %v", err), nil)
+			return
+		}
+		if err != nil {
+			writeStoreError(responseWriter, err, "failed to update payment")
+			return
+		}
+		payment.Version = current.Version
+	} else if ifMatch != "" {
+		version, err := ifMatchVersion(ifMatch)
+		if err != nil {
+			writeError(responseWriter, http.StatusBadRequest, codeBadRequest, err.Error(), nil)
+			return
+		}
+		payment.Version = version
+	}
+
+	if !server.updatePayment(responseWriter, request, payment, ifMatch) {
+		return
+	}
+
+	writePayment(responseWriter, request, payment)
+}
+
+// patchPaymentHandler changes some fields of the payment with the given ID.
+// The body is an RFC 7396 merge patch, sent as application/merge-patch+json or application/json, or an RFC 6902 JSON
+// patch, sent as application/json-patch+json. The patched payment is validated and the version checked exactly as for
+// an update, the version being taken from the If-Match header if one is given, otherwise from the patched payment.
+// A badly formed patch returns 400 bad request, a patch that can not be applied 422 unprocessable entity and one that
+// tries to change the payment ID 400 bad request.
+func (server *Server) patchPaymentHandler(responseWriter http.ResponseWriter, request *http.Request) {
+	paymentID, ok := validPaymentID(responseWriter, request)
+	if !ok {
+		return
+	}
+
+	applyPatch := patch.Merge
+	mediaType, _, _ := mime.ParseMediaType(request.Header.Get("Content-Type"))
+	switch mediaType {
+	case patch.MergePatchType, "application/json", "":
+	case patch.JSONPatchType:
+		applyPatch = patch.Apply
+	default:
+		writeError(responseWriter, http.StatusUnsupportedMediaType, codeUnsupportedMedia,
+			fmt.Sprintf("patches must be sent as %s or %s", patch.MergePatchType, patch.JSONPatchType), nil)
+		return
+	}
 	if request.Body == nil {
-		responseWriter.WriteHeader(http.StatusBadRequest)
-		fmt.Fprint(responseWriter, "Badly formed request: empty body")
+		writeError(responseWriter, http.StatusBadRequest, codeBadRequest, "Badly formed request: empty body", nil)
+		return
+	}
+	changes, err := ioutil.ReadAll(request.Body)
+	if err != nil {
+		writeBodyError(responseWriter, err)
 		return
 	}
 
-	dec := json.NewDecoder(request.Body)
-	payment := &api.Payment{}
-	err := dec.Decode(payment)
+	current, err := server.requestHandler(request).Load(requestContext(request), paymentID)
 	if err != nil {
-		responseWriter.WriteHeader(http.StatusBadRequest)
-		fmt.Fprintf(responseWriter, "Badly formed request: %v", err)
+		writeStoreError(responseWriter, err, "failed to patch payment")
+		return
+	}
+	document, err := json.Marshal(current)
+	if err != nil {
+		writeError(responseWriter, http.StatusInternalServerError, codeInternalError,
+			fmt.Sprintf("failed to patch payment: %v", err), nil)
+		return
+	}
+	patched, err := applyPatch(document, changes)
+	if errors.Is(err, patch.ErrNotApplicable) {
+		writeError(responseWriter, http.StatusUnprocessableEntity, codeInvalid,
+			fmt.Sprintf("failed to patch payment: %v", err), nil)
+		return
+	}
+	if err != nil {
+		writeError(responseWriter, http.StatusBadRequest, codeBadRequest,
+			fmt.Sprintf("Badly formed request: %v", err), nil)
 		return
 	}
 
-	if ok, msg := payment.Valid(); !ok {
-		http.Error(responseWriter, msg, http.StatusBadRequest)
+	payment := &api.Payment{}
+	if err := json.Unmarshal(patched, payment); err != nil {
+		writeError(responseWriter, http.StatusBadRequest, codeBadRequest,
+			fmt.Sprintf("Badly formed request: patched payment is not valid: %v", err), nil)
+		return
+	}
+	if payment.ID != current.ID {
+		writeError(responseWriter, http.StatusBadRequest, codeBadRequest, "the payment ID can not be changed",
+			&api.ErrorSource{Pointer: "/id"})
+		return
+	}
+	if errs := payment.Validate(); len(errs) > 0 {
+		writeValidationErrors(responseWriter, errs)
 		return
 	}
 
-	err = handler.Create(payment)
-	if err != nil {
-		responseWriter.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintf(responseWriter, "failed to create payment: %v", err)
+	ifMatch := strings.TrimSpace(request.Header.Get("If-Match"))
+	if ifMatch == "*" {
+		payment.Version = current.Version
+	} else if ifMatch != "" {
+		version, err := ifMatchVersion(ifMatch)
+		if err != nil {
+			writeError(responseWriter, http.StatusBadRequest, codeBadRequest, err.Error(), nil)
+			return
+		}
+		payment.Version = version
+	}
+
+	if !server.updatePayment(responseWriter, request, payment, ifMatch) {
 		return
 	}
 
-	writeResult(responseWriter, payment)
+	writePayment(responseWriter, request, payment)
 }
 
-// updatePaymentHandler creates a new payment with the given details.
-// If the request is badly formed a 400 bad request is returned.
-func updatePaymentHandler(responseWriter http.ResponseWriter, request *http.Request) {
+// updatePayment updates the payment in the store. If the update fails the error is written, as a 412 precondition
+// failed for a version conflict when an If-Match header was given, and false returned.
+func (server *Server) updatePayment(responseWriter http.ResponseWriter, request *http.Request, payment *api.Payment,
+	ifMatch string) bool {
+	err := server.requestHandler(request).Update(requestContext(request), payment)
+	if errors.Is(err, persist.ErrConflict) && ifMatch != "" {
+		writeError(responseWriter, http.StatusPreconditionFailed, codePreconditionFailed,
+			fmt.Sprintf("failed to update payment: %v", err), nil)
+		return false
+	}
+	if err != nil {
+		writeStoreError(responseWriter, err, "failed to update payment")
+		return false
+	}
+
+	return true
+}
+
+// decodePayment decodes the payment in the request body and checks it is valid.
+// If the body is missing, badly formed or the payment is not valid a 400 bad request is sent and false returned.
+// Validation failures are reported with an error for each field pointing at the field in the request body.
+func decodePayment(responseWriter http.ResponseWriter, request *http.Request) (payment *api.Payment, ok bool) {
 	if request.Body == nil {
-		responseWriter.WriteHeader(http.StatusBadRequest)
-		fmt.Fprint(responseWriter, "Badly formed request: empty body")
-		return
+		writeError(responseWriter, http.StatusBadRequest, codeBadRequest, "Badly formed request: empty body", nil)
+		return nil, false
 	}
 
 	dec := json.NewDecoder(request.Body)
-	payment := &api.Payment{}
+	payment = &api.Payment{}
 	err := dec.Decode(payment)
 	if err != nil {
-		responseWriter.WriteHeader(http.StatusBadRequest)
-		fmt.Fprintf(responseWriter, "Badly formed request: %v", err)
-		return
+		writeBodyError(responseWriter, err)
+		return nil, false
+	}
+
+	if errs := payment.Validate(); len(errs) > 0 {
+		writeValidationErrors(responseWriter, errs)
+		return nil, false
 	}
 
-	if ok, msg := payment.Valid(); !ok {
-		http.Error(responseWriter, msg, http.StatusBadRequest)
+	return payment, true
+}
+
+// etag returns the entity tag for the given payment version.
+func etag(version int) string {
+	return strconv.Quote(strconv.Itoa(version))
+}
+
+// ifMatchVersion returns the payment version named by an If-Match header value holding a single entity tag.
+func ifMatchVersion(ifMatch string) (int, error) {
+	tag, err := strconv.Unquote(strings.TrimPrefix(ifMatch, "W/"))
+	if err != nil {
+		return 0, fmt.Errorf("invalid If-Match header: %s", ifMatch)
+	}
+	version, err := strconv.Atoi(tag)
+	if err != nil {
+		return 0, fmt.Errorf("invalid If-Match header: %s", ifMatch)
+	}
+
+	return version, nil
+}
+
+// getPaymentHandler fetches the payment with the given ID. If the ID is missing a 400 bad request is returned and if
+// there is no payment with the ID a 404 not found.
+// An earlier version of the payment can be fetched with the version query parameter, it is found from the audit trail
+// so is available even if the payment has since been deleted.
+func (server *Server) getPaymentHandler(responseWriter http.ResponseWriter, request *http.Request) {
+	paymentID, ok := validPaymentID(responseWriter, request)
+	if !ok {
 		return
 	}
 
-	err = handler.Update(payment)
+	var payment *api.Payment
+	var err error
+	if value := request.URL.Query().Get("version"); value != "" {
+		version, convErr := strconv.Atoi(value)
+		if convErr != nil || version < 0 {
+			writeError(responseWriter, http.StatusBadRequest, codeBadRequest, "version must be a number from 0",
+				&api.ErrorSource{Parameter: "version"})
+			return
+		}
+		payment, err = server.requestHandler(request).LoadVersion(requestContext(request), paymentID, version)
+	} else {
+		payment, err = server.requestHandler(request).Load(requestContext(request), paymentID)
+	}
 	if err != nil {
-		responseWriter.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintf(responseWriter, "failed to update payment: %v", err)
+		writeStoreError(responseWriter, err, "failed to get payment")
 		return
 	}
 
-	writeResult(responseWriter, payment)
+	writePayment(responseWriter, request, payment)
 }
 
-// getPaymentHandler fetches the payment with the given ID. If the ID is missing a 400 bad request is returned.
-func getPaymentHandler(responseWriter http.ResponseWriter, request *http.Request) {
+// deletePaymentHandler soft deletes the payment with the given ID, it is kept, recording when and by whom it was
+// deleted, until the retention period has passed so it can be restored. If the ID is missing a 400 bad request is
+// returned and if there is no payment with the ID a 404 not found.
+func (server *Server) deletePaymentHandler(responseWriter http.ResponseWriter, request *http.Request) {
 	paymentID, ok := validPaymentID(responseWriter, request)
 	if !ok {
 		return
 	}
 
-	log.Printf("Got payment ID: %s", paymentID)
+	err := server.requestHandler(request).Delete(requestContext(request), paymentID)
+	if err != nil {
+		writeStoreError(responseWriter, err, "failed to delete payment")
+		return
+	}
+}
+
+// restorePaymentHandler restores the soft deleted payment with the given ID. If there is no payment with the ID, or
+// it has been purged, a 404 not found is returned and if it is not deleted a 409 conflict.
+func (server *Server) restorePaymentHandler(responseWriter http.ResponseWriter, request *http.Request) {
+	paymentID, ok := validPaymentID(responseWriter, request)
+	if !ok {
+		return
+	}
 
-	payment, err := handler.Load(paymentID)
+	payment, err := server.requestHandler(request).Restore(requestContext(request), paymentID)
 	if err != nil {
-		responseWriter.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintf(responseWriter, "failed to get payment: %v", err)
+		writeStoreError(responseWriter, err, "failed to restore payment")
 		return
 	}
 
-	writeResult(responseWriter, payment)
+	writePayment(responseWriter, request, payment)
 }
 
-// deletePaymentHandler deleted the payment with the given ID. If the ID is missing a 400 bad request is returned.
-func deletePaymentHandler(responseWriter http.ResponseWriter, request *http.Request) {
+// approvalHandler returns the approval of the payment with the given ID. If the payment does not need approval a 404
+// not found is returned.
+func (server *Server) approvalHandler(responseWriter http.ResponseWriter, request *http.Request) {
 	paymentID, ok := validPaymentID(responseWriter, request)
 	if !ok {
 		return
 	}
 
-	log.Printf("Got payment ID: %s", paymentID)
+	approval, err := server.requestHandler(request).Approval(requestContext(request), paymentID)
+	if err != nil {
+		writeStoreError(responseWriter, err, "failed to get payment approval")
+		return
+	}
+
+	writeApproval(responseWriter, request, approval)
+}
+
+// decisionHandler returns a handler that approves, or rejects, the payment with the given ID. The body may give a
+// reason for the decision, {"reason": "..."}. If the caller created or changed the payment a 403 forbidden is
+// returned and if the payment is not waiting for approval a 409 conflict.
+func (server *Server) decisionHandler(approve bool) http.HandlerFunc {
+	return func(responseWriter http.ResponseWriter, request *http.Request) {
+		paymentID, ok := validPaymentID(responseWriter, request)
+		if !ok {
+			return
+		}
+
+		body := &api.ApprovalDecisionRequest{}
+		if request.Body != nil {
+			err := json.NewDecoder(request.Body).Decode(body)
+			if err != nil && !errors.Is(err, io.EOF) {
+				writeBodyError(responseWriter, err)
+				return
+			}
+		}
+
+		paymentHandler, ctx := server.requestHandler(request), requestContext(request)
+		decide, message := paymentHandler.Approve, "failed to approve payment"
+		if !approve {
+			decide, message = paymentHandler.RejectApproval, "failed to reject payment"
+		}
+		approval, err := decide(ctx, paymentID, body.Reason)
+		if err != nil {
+			writeStoreError(responseWriter, err, message)
+			return
+		}
+
+		writeApproval(responseWriter, request, approval)
+	}
+}
+
+// writeApproval writes the approval, along with a link to it, as the response.
+func writeApproval(responseWriter http.ResponseWriter, request *http.Request, approval *api.Approval) {
+	writeResult(responseWriter, &api.ApprovalHolder{
+		Data:  *approval,
+		Links: api.Links{Self: paymentPath(request, approval.PaymentID) + "/approval"},
+	})
+}
+
+// PurgeDeleted permanently removes the payments deleted longer ago than the retention period, checking every
+// interval. It runs until the context is done.
+func (server *Server) PurgeDeleted(ctx context.Context, interval, retention time.Duration) {
+	ctx = logging.WithLogger(audit.WithActor(ctx, "purge"), server.logger)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		purged, err := server.handler.Purge(ctx, retention)
+		if err != nil {
+			server.logger.Error("Failed to purge deleted payments", "error", err)
+		}
+		if purged > 0 {
+			server.logger.Info("Purged deleted payments", "purged", purged)
+		}
+	}
+}
 
-	err := handler.Delete(paymentID)
+// historyHandler returns the audit trail of the payment with the given ID, oldest change first. Each event gives who
+// made the change, when, the request it was made in and the fields that changed. The history of a deleted payment is
+// still available. If there is no record of the payment a 404 not found is returned.
+func (server *Server) historyHandler(responseWriter http.ResponseWriter, request *http.Request) {
+	paymentID, ok := validPaymentID(responseWriter, request)
+	if !ok {
+		return
+	}
+
+	events, err := server.requestHandler(request).History(requestContext(request), paymentID)
 	if err != nil {
-		responseWriter.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintf(responseWriter, "failed to delete payment: %v", err)
+		writeStoreError(responseWriter, err, "failed to get payment history")
 		return
 	}
+	for i := range events {
+		// the whole payment at each version is fetched with the version query parameter
+		events[i].Payment = nil
+	}
+
+	writeResult(responseWriter, &api.HistoryHolder{
+		Data:  events,
+		Links: api.Links{Self: paymentPath(request, paymentID) + "/history"},
+	})
+}
+
+// transitionHandler returns a handler that moves the payment with the given ID to the status. The body may give a
+// reason for the change, {"reason": "..."}, and who is making it is the authenticated caller.
+// If the payment can not move to the status from its current one a 409 conflict is returned. If an If-Match header is
+// given and the payment is not at that version a 412 precondition failed is returned.
+func (server *Server) transitionHandler(status api.Status) http.HandlerFunc {
+	return func(responseWriter http.ResponseWriter, request *http.Request) {
+		paymentID, ok := validPaymentID(responseWriter, request)
+		if !ok {
+			return
+		}
+
+		body := &api.StatusChangeRequest{}
+		if request.Body != nil {
+			err := json.NewDecoder(request.Body).Decode(body)
+			if err != nil && !errors.Is(err, io.EOF) {
+				writeBodyError(responseWriter, err)
+				return
+			}
+		}
+
+		transition := payment_handler.TransitionRequest{To: status, Reason: body.Reason}
+		ifMatch := strings.TrimSpace(request.Header.Get("If-Match"))
+		if ifMatch != "" && ifMatch != "*" {
+			version, err := ifMatchVersion(ifMatch)
+			if err != nil {
+				writeError(responseWriter, http.StatusBadRequest, codeBadRequest, err.Error(), nil)
+				return
+			}
+			transition.Version = &version
+		}
+
+		payment, err := server.requestHandler(request).Transition(requestContext(request), paymentID, transition)
+		if errors.Is(err, persist.ErrConflict) && transition.Version != nil {
+			writeError(responseWriter, http.StatusPreconditionFailed, codePreconditionFailed,
+				fmt.Sprintf("failed to change payment status: %v", err), nil)
+			return
+		}
+		if err != nil {
+			writeStoreError(responseWriter, err, "failed to change payment status")
+			return
+		}
+
+		writePayment(responseWriter, request, payment)
+	}
+}
+
+// requestContext returns the context of the request along with who is making it and its ID, every call to the payment
+// handler is made with it so that the work is abandoned with the request, traced and logged with its ID. Requests
+// that have not been through logRequests, which only happens when handlers are called directly, take their ID from
+// the X-Request-ID header or are given a new one.
+func requestContext(request *http.Request) context.Context {
+	ctx := request.Context()
+	if audit.RequestID(ctx) == "" {
+		requestID := request.Header.Get(requestIDHeader)
+		if !validRequestID(requestID) {
+			requestID = uuid.New().String()
+		}
+		ctx = audit.WithRequestID(ctx, requestID)
+	}
+
+	return audit.WithActor(ctx, requestActor(request))
+}
+
+// requestActor returns who is making the request.
+func requestActor(request *http.Request) string {
+	if subject := requestPrincipal(request).Subject; subject != "" {
+		return subject
+	}
+
+	return anonymousActor
+}
+
+// authenticate identifies the caller of each request, which is recorded in the request context. Requests without
+// valid credentials, including every request when no authenticator was given, are sent a 401 unauthorized.
+func (server *Server) authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(responseWriter http.ResponseWriter, request *http.Request) {
+		principal, err := server.authenticator.Authenticate(request)
+		if err != nil {
+			responseWriter.Header().Set("WWW-Authenticate", `Bearer realm="payments"`)
+			writeError(responseWriter, http.StatusUnauthorized, codeUnauthenticated,
+				fmt.Sprintf("failed to authenticate: %v", err), nil)
+			return
+		}
+		noteRequest(request, "", principal.OrganisationID)
+		next.ServeHTTP(responseWriter, request.WithContext(auth.WithPrincipal(request.Context(), principal)))
+	})
+}
+
+// requestPrincipal returns the caller of the request. Requests that have not been through authenticate, which only
+// happens when handlers are called directly rather than through the router, are taken to come from a trusted gateway.
+func requestPrincipal(request *http.Request) auth.Principal {
+	if principal, ok := auth.PrincipalFrom(request.Context()); ok {
+		return principal
+	}
+	principal, _ := auth.Headers{}.Authenticate(request)
+
+	return principal
+}
+
+// authorize only lets through callers whose roles allow them to perform the operation, others are sent a 403
+// forbidden naming the roles that would.
+func (server *Server) authorize(operation auth.Operation, next http.HandlerFunc) http.HandlerFunc {
+	return func(responseWriter http.ResponseWriter, request *http.Request) {
+		principal := requestPrincipal(request)
+		if !server.policy.Allows(principal, operation) {
+			writeErrors(responseWriter, http.StatusForbidden, api.Error{
+				Code:   codePermissionDenied,
+				Title:  http.StatusText(http.StatusForbidden),
+				Detail: fmt.Sprintf("%s can not %s payments", describeCaller(principal), operation),
+				Meta: &api.ErrorMeta{
+					Operation:     string(operation),
+					RequiredRoles: server.policy.RolesAllowing(operation),
+				},
+			})
+			return
+		}
+		next(responseWriter, request)
+	}
+}
+
+// describeCaller names the caller and their roles for error messages.
+func describeCaller(principal auth.Principal) string {
+	caller := principal.Subject
+	if caller == "" {
+		caller = anonymousActor
+	}
+	if len(principal.Roles) == 0 {
+		return caller + " with no roles"
+	}
+
+	return fmt.Sprintf("%s with roles %s", caller, strings.Join(principal.Roles, ", "))
+}
+
+// requireOrganisation only lets through callers that can reach the payments of the organisation in the path, others
+// are sent a 403 forbidden.
+func requireOrganisation(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(responseWriter http.ResponseWriter, request *http.Request) {
+		organisationID := mux.Vars(request)["org-id"]
+		if !requestPrincipal(request).CanAccess(organisationID) {
+			writeError(responseWriter, http.StatusForbidden, codeForbidden,
+				fmt.Sprintf("the payments of organisation %s can not be reached by the caller", organisationID), nil)
+			return
+		}
+		next.ServeHTTP(responseWriter, request)
+	})
+}
+
+// requireAdmin only lets through admin callers, others are sent a 403 forbidden.
+func requireAdmin(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(responseWriter http.ResponseWriter, request *http.Request) {
+		if !requestPrincipal(request).Admin {
+			writeError(responseWriter, http.StatusForbidden, codeForbidden,
+				"only admins can reach the payments of every organisation, use /v1/organisations/{org-id}/payments",
+				nil)
+			return
+		}
+		next.ServeHTTP(responseWriter, request)
+	})
+}
+
+// requestHandler returns the handler for the request, restricted to the organisation in the path when there is one.
+func (server *Server) requestHandler(request *http.Request) *payment_handler.PaymentHandler {
+	if organisationID := mux.Vars(request)["org-id"]; organisationID != "" {
+		return server.handler.ForOrganisation(organisationID)
+	}
+
+	return server.handler
 }
 
 // validPaymentID checks for the presence of the payment ID in the path.
@@ -181,33 +1165,268 @@ func validPaymentID(responseWriter http.ResponseWriter, request *http.Request) (
 	vars := mux.Vars(request)
 	paymentID = vars["payment-id"]
 	if paymentID == "" {
-		responseWriter.WriteHeader(http.StatusBadRequest)
+		writeError(responseWriter, http.StatusBadRequest, codeBadRequest, "payment ID is missing from the path", nil)
 		return "", false
 	}
 
 	return paymentID, true
 }
 
-// listPaymentsHandler returns a list of payments.
-func listPaymentsHandler(responseWriter http.ResponseWriter, request *http.Request) {
-	payments, err := handler.List()
+// listPaymentsHandler returns a page of payments along with links to the other pages.
+// The page is chosen with the page[number], counting from 0, and page[size] query parameters. Payments can be filtered
+// with filter[organisation_id], filter[currency], filter[payment_scheme], filter[payment_type],
+// filter[processing_date_from], filter[processing_date_to] and filter[include_deleted], true to list soft deleted
+// payments too, and ordered with sort, one of id, processing_date or
+// amount with a leading - for descending order. If any of the parameters are invalid a 400 bad request is returned.
+func (server *Server) listPaymentsHandler(responseWriter http.ResponseWriter, request *http.Request) {
+	query, pageNumber, paramErr := parseListQuery(request.URL.Query())
+	if paramErr != nil {
+		writeError(responseWriter, http.StatusBadRequest, codeBadRequest, paramErr.Error(),
+			&api.ErrorSource{Parameter: paramErr.parameter})
+		return
+	}
+
+	payments, err := server.requestHandler(request).List(requestContext(request), query)
 	if err != nil {
-		responseWriter.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintf(responseWriter, "failed to list payments: %v", err)
+		writeStoreError(responseWriter, err, "failed to list payments")
 		return
 	}
 
+	payments.Links = pageLinks(request.URL, pageNumber, query.Limit, len(payments.Data), payments.Meta)
 	writeResult(responseWriter, payments)
 }
 
-// writeResult writes the value as JSON to the response. If the encoding fails 500 is returned with a message.
+// parameterError describes a query parameter with an invalid value.
+type parameterError struct {
+	parameter string
+	message   string
+}
+
+func (err *parameterError) Error() string {
+	return err.parameter + " " + err.message
+}
+
+// parseListQuery builds the store query and page number from the list query parameters.
+func parseListQuery(values url.Values) (query persist.ListQuery, pageNumber int, paramErr *parameterError) {
+	var err error
+	query.Limit = defaultPageSize
+	if size := values.Get("page[size]"); size != "" {
+		query.Limit, err = strconv.Atoi(size)
+		if err != nil || query.Limit < 1 || query.Limit > maxPageSize {
+			return query, 0, &parameterError{"page[size]", fmt.Sprintf("must be a number from 1 to %d", maxPageSize)}
+		}
+	}
+	if number := values.Get("page[number]"); number != "" {
+		pageNumber, err = strconv.Atoi(number)
+		if err != nil || pageNumber < 0 {
+			return query, 0, &parameterError{"page[number]", "must be a number from 0"}
+		}
+	}
+	query.Offset = pageNumber * query.Limit
+
+	if sortBy := values.Get("sort"); sortBy != "" {
+		query.Descending = strings.HasPrefix(sortBy, "-")
+		query.Sort = persist.SortField(strings.TrimPrefix(sortBy, "-"))
+		switch query.Sort {
+		case persist.SortByID, persist.SortByProcessingDate, persist.SortByAmount:
+		default:
+			return query, 0, &parameterError{"sort", "must be one of id, processing_date or amount"}
+		}
+	}
+
+	query.Filter = persist.ListFilter{
+		OrganisationID:     values.Get("filter[organisation_id]"),
+		Currency:           values.Get("filter[currency]"),
+		PaymentScheme:      values.Get("filter[payment_scheme]"),
+		PaymentType:        values.Get("filter[payment_type]"),
+		ProcessingDateFrom: values.Get("filter[processing_date_from]"),
+		ProcessingDateTo:   values.Get("filter[processing_date_to]"),
+	}
+	for name, date := range map[string]string{
+		"filter[processing_date_from]": query.Filter.ProcessingDateFrom,
+		"filter[processing_date_to]":   query.Filter.ProcessingDateTo,
+	} {
+		if _, err := time.Parse(isoDate, date); date != "" && err != nil {
+			return query, 0, &parameterError{name, "must be a date in the form YYYY-MM-DD"}
+		}
+	}
+	switch values.Get("filter[include_deleted]") {
+	case "", "false":
+	case "true":
+		query.Filter.IncludeDeleted = true
+	default:
+		return query, 0, &parameterError{"filter[include_deleted]", "must be true or false"}
+	}
+
+	return query, pageNumber, nil
+}
+
+// pageLinks returns the links to the current, first, previous, next and last pages of a list.
+// If the store did not report the total number of payments there is no last link and a next link is given as long
+// as the current page is full.
+func pageLinks(requestURL *url.URL, pageNumber, pageSize, pageLength int, meta *api.ListMeta) api.Links {
+	link := func(number int) string {
+		values := requestURL.Query()
+		values.Set("page[number]", strconv.Itoa(number))
+		values.Set("page[size]", strconv.Itoa(pageSize))
+		return requestURL.Path + "?" + values.Encode()
+	}
+
+	links := api.Links{Self: requestURL.RequestURI(), First: link(0)}
+	if pageNumber > 0 {
+		links.Prev = link(pageNumber - 1)
+	}
+	if meta == nil {
+		if pageLength == pageSize {
+			links.Next = link(pageNumber + 1)
+		}
+		return links
+	}
+
+	lastPage := 0
+	if meta.TotalCount > 0 {
+		lastPage = (meta.TotalCount - 1) / pageSize
+	}
+	links.Last = link(lastPage)
+	if pageNumber < lastPage {
+		links.Next = link(pageNumber + 1)
+	}
+
+	return links
+}
+
+// storeErrorStatus returns the HTTP status code for an error returned by the payment store or handler.
+// Errors that are not one of the persist or handler errors are treated as internal failures.
+func storeErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, persist.ErrNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, persist.ErrConflict), errors.Is(err, persist.ErrAlreadyExists),
+		errors.Is(err, payment_handler.ErrIllegalTransition), errors.Is(err, payment_handler.ErrNotDeleted),
+		errors.Is(err, payment_handler.ErrApprovalRequired), errors.Is(err, payment_handler.ErrNoPendingApproval):
+		return http.StatusConflict
+	case errors.Is(err, payment_handler.ErrSelfApproval):
+		return http.StatusForbidden
+	case errors.Is(err, persist.ErrInvalid):
+		return http.StatusUnprocessableEntity
+	case errors.Is(err, context.DeadlineExceeded), errors.Is(err, context.Canceled):
+		return http.StatusServiceUnavailable
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// storeErrorCode returns the error code for an error returned by the payment store or handler.
+func storeErrorCode(err error) string {
+	switch {
+	case errors.Is(err, payment_handler.ErrIllegalTransition):
+		return codeIllegalTransition
+	case errors.Is(err, payment_handler.ErrNotDeleted):
+		return codeNotDeleted
+	case errors.Is(err, payment_handler.ErrApprovalRequired):
+		return codeApprovalRequired
+	case errors.Is(err, payment_handler.ErrSelfApproval):
+		return codeSelfApproval
+	case errors.Is(err, payment_handler.ErrNoPendingApproval):
+		return codeNoPendingApproval
+	case errors.Is(err, persist.ErrNotFound):
+		return codeNotFound
+	case errors.Is(err, persist.ErrConflict):
+		return codeConflict
+	case errors.Is(err, persist.ErrAlreadyExists):
+		return codeAlreadyExists
+	case errors.Is(err, persist.ErrInvalid):
+		return codeInvalid
+	case errors.Is(err, context.DeadlineExceeded):
+		return codeTimeout
+	case errors.Is(err, context.Canceled):
+		return codeCancelled
+	default:
+		return codeInternalError
+	}
+}
+
+// writeStoreError writes the status code and error matching the store error along with the message and error.
+func writeStoreError(responseWriter http.ResponseWriter, err error, message string) {
+	writeError(responseWriter, storeErrorStatus(err), storeErrorCode(err), fmt.Sprintf("%s: %v", message, err), nil)
+}
+
+// writeBodyError writes the error reading the request body, a 413 request entity too large if the body is over the
+// size limit or otherwise a 400 bad request.
+func writeBodyError(responseWriter http.ResponseWriter, err error) {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		writeError(responseWriter, http.StatusRequestEntityTooLarge, codeTooLarge,
+			fmt.Sprintf("the request body must be at most %d bytes", tooLarge.Limit), nil)
+		return
+	}
+
+	writeError(responseWriter, http.StatusBadRequest, codeBadRequest, fmt.Sprintf("Badly formed request: %v", err), nil)
+}
+
+// writeError writes a single error with the status code, code and detail. The source may be nil.
+func writeError(responseWriter http.ResponseWriter, status int, code string, detail string, source *api.ErrorSource) {
+	writeErrors(responseWriter, status,
+		api.Error{Code: code, Title: http.StatusText(status), Detail: detail, Source: source})
+}
+
+// writeErrors writes the errors with the status code.
+func writeErrors(responseWriter http.ResponseWriter, status int, errs ...api.Error) {
+	writeJSON(responseWriter, status, &api.ErrorHolder{Errors: errs})
+}
+
+// writeValidationErrors writes a 400 bad request with an error for each field that failed validation.
+func writeValidationErrors(responseWriter http.ResponseWriter, errs api.ValidationErrors) {
+	apiErrors := make([]api.Error, 0, len(errs))
+	for _, err := range errs {
+		apiErrors = append(apiErrors, api.Error{
+			Code:   codeValidationFailed,
+			Title:  "Invalid field",
+			Detail: err.Error(),
+			Source: &api.ErrorSource{Pointer: err.Pointer},
+		})
+	}
+	writeErrors(responseWriter, http.StatusBadRequest, apiErrors...)
+}
+
+// writePayment writes the payment, along with its ETag and a link to it, as the response.
+func writePayment(responseWriter http.ResponseWriter, request *http.Request, payment *api.Payment) {
+	noteRequest(request, payment.ID, payment.OrganisationID)
+	responseWriter.Header().Set("ETag", etag(payment.Version))
+	writeResult(responseWriter, &api.PaymentHolder{
+		Data:  *payment,
+		Links: api.Links{Self: paymentPath(request, payment.ID)},
+	})
+}
+
+// paymentPath returns the path of the payment with the given ID under the route the request was made through.
+func paymentPath(request *http.Request, paymentID string) string {
+	if organisationID := mux.Vars(request)["org-id"]; organisationID != "" {
+		return fmt.Sprintf("/v1/organisations/%s/payments/%s", organisationID, paymentID)
+	}
+
+	return fmt.Sprintf("/v1/payment/%s", paymentID)
+}
+
+// writeResult writes the value as JSON to the response. If the encoding fails 500 is returned with an error.
 func writeResult(responseWriter http.ResponseWriter, val interface{}) {
-	enc := json.NewEncoder(responseWriter)
-	err := enc.Encode(val)
+	writeJSON(responseWriter, http.StatusOK, val)
+}
+
+// writeJSON writes the value as JSON to the response with the status code.
+// The value is encoded before anything is written so that an encoding failure can still be reported as a 500.
+func writeJSON(responseWriter http.ResponseWriter, status int, val interface{}) {
+	body, err := json.Marshal(val)
 	if err != nil {
-		responseWriter.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintf(responseWriter, "failed to encode response: %v", err)
+		status = http.StatusInternalServerError
+		body, _ = json.Marshal(&api.ErrorHolder{Errors: []api.Error{{
+			Code:   codeInternalError,
+			Title:  http.StatusText(status),
+			Detail: fmt.Sprintf("failed to encode response: %v", err),
+		}}})
 	}
 
-	responseWriter.Header().Add("Content-Type", "application/json")
+	responseWriter.Header().Set("Content-Type", "application/json")
+	responseWriter.WriteHeader(status)
+	responseWriter.Write(append(body, '\n'))
 }
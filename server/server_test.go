@@ -1,6 +1,10 @@
-package main
+package server
 
 import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -8,14 +12,25 @@ import (
 	"net/http/httptest"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/cdempsie/payments-example/api"
+	"github.com/cdempsie/payments-example/approval"
+	"github.com/cdempsie/payments-example/audit"
+	"github.com/cdempsie/payments-example/auth"
 	payment_handler "github.com/cdempsie/payments-example/handler"
+	"github.com/cdempsie/payments-example/logging"
+	"github.com/cdempsie/payments-example/money"
+	"github.com/cdempsie/payments-example/patch"
+	"github.com/cdempsie/payments-example/persist"
 	"github.com/cdempsie/payments-example/persist/mocks"
 	"github.com/cdempsie/payments-example/test"
 	"github.com/google/uuid"
 	"github.com/gorilla/mux"
 	"github.com/stretchr/testify/mock"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
 )
 
 const APIBase = "/v1/payment"
@@ -23,15 +38,15 @@ const APIBase = "/v1/payment"
 func TestCreateRequest(t *testing.T) {
 	// Pass a mock store to the handler
 	mockStore := &mocks.PaymentStore{}
-	mockStore.On("Create", mock.Anything).Return(nil)
-	handler = payment_handler.NewPaymentHandler(mockStore)
+	mockStore.On("Create", mock.Anything, mock.Anything).Return(nil)
+	srv := New(mockStore, nil)
 	req, err := http.NewRequest(http.MethodPost, APIBase, strings.NewReader(test.CreatePayment))
 	if err != nil {
 		t.Fatal(err)
 	}
 
 	recorder := httptest.NewRecorder()
-	createPaymentHandler(recorder, req)
+	srv.createPaymentHandler(recorder, req)
 
 	// Check the status code is what we expect.
 	if status := recorder.Code; status != http.StatusOK {
@@ -39,17 +54,85 @@ func TestCreateRequest(t *testing.T) {
 	}
 }
 
+func TestCreateRequestIdempotent(t *testing.T) {
+	// Pass a mock store to the handler, a retry must not create the payment again
+	mockStore := &mocks.PaymentStore{}
+	mockStore.On("Create", mock.Anything, mock.Anything).Return(nil)
+	srv := New(mockStore, nil, WithIdempotencyTTL(time.Hour))
+	create := func(body string) *httptest.ResponseRecorder {
+		req, err := http.NewRequest(http.MethodPost, APIBase, strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Idempotency-Key", "create-once")
+
+		recorder := httptest.NewRecorder()
+		srv.idempotent(srv.createPaymentHandler)(recorder, req)
+		return recorder
+	}
+
+	first := create(test.CreatePayment)
+	retry := create(test.CreatePayment)
+
+	// Check the retry is sent the original response.
+	if status := retry.Code; status != http.StatusOK {
+		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusOK)
+	}
+	if retry.Header().Get("Idempotent-Replayed") != "true" {
+		t.Errorf("handler did not mark the response as replayed")
+	}
+	if retry.Body.String() != first.Body.String() {
+		t.Errorf("handler replayed the wrong body: got %s want %s", retry.Body, first.Body)
+	}
+	mockStore.AssertNumberOfCalls(t, "Create", 1)
+
+	// Check the key can not be used for a different payment.
+	changed := strings.Replace(test.CreatePayment, `"amount":"100.21"`, `"amount":"200.42"`, 1)
+	if status := create(changed).Code; status != http.StatusUnprocessableEntity {
+		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusUnprocessableEntity)
+	}
+}
+
+func TestIdempotencyKeyReleasedOnPanic(t *testing.T) {
+	mockStore := &mocks.PaymentStore{}
+	mockStore.On("Create", mock.Anything, mock.Anything).Return(nil)
+	srv := New(mockStore, nil, WithIdempotencyTTL(time.Hour))
+	create := func(handler http.HandlerFunc) *httptest.ResponseRecorder {
+		req, err := http.NewRequest(http.MethodPost, APIBase, strings.NewReader(test.CreatePayment))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Idempotency-Key", "create-once")
+
+		recorder := httptest.NewRecorder()
+		srv.idempotent(handler)(recorder, req)
+		return recorder
+	}
+
+	func() {
+		// net/http recovers panics in handlers and carries on serving
+		defer func() { recover() }()
+		create(func(http.ResponseWriter, *http.Request) { panic("handler failed") })
+	}()
+
+	// Check the key can be used again by a retry.
+	if status := create(srv.createPaymentHandler).Code; status != http.StatusOK {
+		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusOK)
+	}
+}
+
 func TestCreateBadRequestNilBody(t *testing.T) {
 	// Pass a mock store to the handler
 	mockStore := &mocks.PaymentStore{}
-	mockStore.On("Create", mock.Anything).Return(nil)
+	mockStore.On("Create", mock.Anything, mock.Anything).Return(nil)
+	srv := New(mockStore, nil)
 	req, err := http.NewRequest(http.MethodPost, APIBase, nil)
 	if err != nil {
 		t.Fatal(err)
 	}
 
 	recorder := httptest.NewRecorder()
-	createPaymentHandler(recorder, req)
+	srv.createPaymentHandler(recorder, req)
 
 	//response := recorder.Result()
 
@@ -62,8 +145,8 @@ func TestCreateBadRequestNilBody(t *testing.T) {
 func TestCreateBadRequestEmptyBody(t *testing.T) {
 	// Pass a mock store to the handler
 	mockStore := &mocks.PaymentStore{}
-	mockStore.On("Create", mock.Anything).Return(nil)
-	handler = payment_handler.NewPaymentHandler(mockStore)
+	mockStore.On("Create", mock.Anything, mock.Anything).Return(nil)
+	srv := New(mockStore, nil)
 
 	req, err := http.NewRequest(http.MethodPost, APIBase, strings.NewReader("{}"))
 	if err != nil {
@@ -71,7 +154,7 @@ func TestCreateBadRequestEmptyBody(t *testing.T) {
 	}
 
 	recorder := httptest.NewRecorder()
-	createPaymentHandler(recorder, req)
+	srv.createPaymentHandler(recorder, req)
 
 	// Check the status code is what we expect.
 	if status := recorder.Code; status != http.StatusBadRequest {
@@ -79,18 +162,331 @@ func TestCreateBadRequestEmptyBody(t *testing.T) {
 	}
 }
 
+func TestCreateBadRequestInvalidFields(t *testing.T) {
+	// Pass a mock store to the handler
+	mockStore := &mocks.PaymentStore{}
+	mockStore.On("Create", mock.Anything, mock.Anything).Return(nil)
+	srv := New(mockStore, nil)
+
+	body := strings.Replace(test.CreatePayment, `"currency":"GBP"`, `"currency":"XXX"`, 1)
+	body = strings.Replace(body, `"bearer_code":"SHAR"`, `"bearer_code":"NONE"`, 1)
+	req, err := http.NewRequest(http.MethodPost, APIBase, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	recorder := httptest.NewRecorder()
+	srv.createPaymentHandler(recorder, req)
+
+	// Check the status code is what we expect.
+	if status := recorder.Code; status != http.StatusBadRequest {
+		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusBadRequest)
+	}
+
+	// Check there is an error pointing at each bad field.
+	response := &api.ErrorHolder{}
+	if err := json.NewDecoder(recorder.Body).Decode(response); err != nil {
+		t.Fatal(err)
+	}
+	pointers := map[string]bool{}
+	for _, apiErr := range response.Errors {
+		if apiErr.Source != nil {
+			pointers[apiErr.Source.Pointer] = true
+		}
+	}
+	for _, pointer := range []string{"/attributes/charges_information/sender_charges/0/currency",
+		"/attributes/charges_information/bearer_code"} {
+		if !pointers[pointer] {
+			t.Errorf("expected an error for %s but got %+v", pointer, response.Errors)
+		}
+	}
+}
+
+func TestCreateRequestTooLarge(t *testing.T) {
+	// Pass a mock store to the handler, a body over the limit must not be created
+	mockStore := &mocks.PaymentStore{}
+	srv := New(mockStore, nil, WithMaxBodyBytes(64), WithAuthenticator(auth.Headers{}))
+	req, err := http.NewRequest(http.MethodPost, APIBase, strings.NewReader(test.CreatePayment))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("X-Roles", "operator")
+	req.Header.Set("X-Admin", "true")
+
+	recorder := httptest.NewRecorder()
+	srv.ServeHTTP(recorder, req)
+
+	// Check the status code is what we expect.
+	if status := recorder.Code; status != http.StatusRequestEntityTooLarge {
+		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusRequestEntityTooLarge)
+	}
+	mockStore.AssertNotCalled(t, "Create", mock.Anything, mock.Anything)
+}
+
+// closerFunc closes by calling the function.
+type closerFunc func() error
+
+func (fn closerFunc) Close() error {
+	return fn()
+}
+
+func TestClose(t *testing.T) {
+	var closed []string
+	closer := func(name string, err error) closerFunc {
+		return func() error {
+			closed = append(closed, name)
+			return err
+		}
+	}
+	srv := New(persist.NewInMemoryStore(), nil, WithCloser(closer("store", errors.New("store failed"))),
+		WithCloser(closer("audit", nil)))
+
+	// Check every closer is closed, last given first, and the error returned.
+	if err := srv.Close(); err == nil || err.Error() != "store failed" {
+		t.Errorf("close returned wrong error: got %v want store failed", err)
+	}
+	if strings.Join(closed, ",") != "audit,store" {
+		t.Errorf("closed in wrong order: got %v want [audit store]", closed)
+	}
+}
+
+// pingStore is an in-memory store whose health is reported by the ping function.
+type pingStore struct {
+	*persist.InMemoryStore
+	ping func(ctx context.Context) error
+}
+
+func (store pingStore) Ping(ctx context.Context) error {
+	return store.ping(ctx)
+}
+
+// slowStore is an in-memory store whose loads do not finish until the context is done.
+type slowStore struct {
+	*persist.InMemoryStore
+}
+
+func (store slowStore) Load(ctx context.Context, paymentUID string) (*api.Payment, error) {
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func TestRequestTimeout(t *testing.T) {
+	srv := New(slowStore{persist.NewInMemoryStore()}, nil, WithRequestTimeout(10*time.Millisecond),
+		WithAuthenticator(auth.Headers{}))
+	req, err := http.NewRequest(http.MethodGet, APIBase+"/"+uuid.New().String(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("X-Roles", "viewer")
+	req.Header.Set("X-Admin", "true")
+
+	recorder := httptest.NewRecorder()
+	srv.ServeHTTP(recorder, req)
+
+	if status := recorder.Code; status != http.StatusServiceUnavailable {
+		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusServiceUnavailable)
+	}
+	if !strings.Contains(recorder.Body.String(), `"code":"timeout"`) {
+		t.Errorf("expected a timeout error, got %s", recorder.Body)
+	}
+}
+
+func TestHealthEndpoints(t *testing.T) {
+	healthy := pingStore{persist.NewInMemoryStore(), func(context.Context) error { return nil }}
+	unhealthy := pingStore{persist.NewInMemoryStore(), func(context.Context) error { return errors.New("disk gone") }}
+	buildInfo := WithBuildInfo(api.BuildInfo{Version: "1.2.0", Commit: "abc123", BuildDate: "2020-01-02T03:04:05Z"})
+
+	tests := []struct {
+		name   string
+		store  persist.PaymentStore
+		path   string
+		status int
+		body   string
+	}{
+		{"alive", healthy, "/healthz", http.StatusOK, `"status":"ok"`},
+		{"alive with store down", unhealthy, "/healthz", http.StatusOK, `"status":"ok"`},
+		{"ready", healthy, "/readyz", http.StatusOK, `"status":"ok"`},
+		{"ready without health check", persist.NewInMemoryStore(), "/readyz", http.StatusOK, `"status":"ok"`},
+		{"not ready", unhealthy, "/readyz", http.StatusServiceUnavailable, `{"status":"unavailable"}`},
+		{"version", healthy, "/version", http.StatusOK, `"version":"1.2.0","commit":"abc123"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// no credentials are sent, the endpoints must be open to the orchestrator
+			req, err := http.NewRequest(http.MethodGet, tt.path, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			recorder := httptest.NewRecorder()
+			New(tt.store, nil, buildInfo, WithAuthenticator(auth.Chain{})).ServeHTTP(recorder, req)
+
+			// Check the status code is what we expect.
+			if status := recorder.Code; status != tt.status {
+				t.Errorf("handler returned wrong status code: got %v want %v", status, tt.status)
+			}
+			if !strings.Contains(recorder.Body.String(), tt.body) {
+				t.Errorf("handler returned wrong body: got %s want it to contain %s", recorder.Body, tt.body)
+			}
+		})
+	}
+}
+
+func TestRequestLogging(t *testing.T) {
+	payment := decodeSample(t)
+	var out bytes.Buffer
+	srv := New(persist.NewInMemoryStore(), logging.NewJSON(&out), WithAuthenticator(auth.Headers{}))
+
+	path := "/v1/organisations/" + payment.OrganisationID + "/payments"
+	req, err := http.NewRequest(http.MethodPost, path, strings.NewReader(test.CreatePayment))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("X-Organisation-ID", payment.OrganisationID)
+	req.Header.Set("X-Roles", "operator")
+	req.Header.Set("X-Request-ID", "req-123")
+	recorder := httptest.NewRecorder()
+	srv.ServeHTTP(recorder, req)
+	if status := recorder.Code; status != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v: %s", status, http.StatusOK, recorder.Body)
+	}
+	if id := recorder.Header().Get("X-Request-ID"); id != "req-123" {
+		t.Errorf("handler returned wrong request ID: got %v want req-123", id)
+	}
+	created := &api.PaymentHolder{}
+	if err := json.NewDecoder(recorder.Body).Decode(created); err != nil {
+		t.Fatal(err)
+	}
+
+	// Check every line is tied to the request and the access log describes it.
+	var lines []map[string]interface{}
+	for _, data := range bytes.Split(bytes.TrimSpace(out.Bytes()), []byte("\n")) {
+		line := map[string]interface{}{}
+		if err := json.Unmarshal(data, &line); err != nil {
+			t.Fatalf("expected JSON log lines, got %s: %v", data, err)
+		}
+		if line["request_id"] != "req-123" {
+			t.Errorf("log line is not tied to the request: %s", data)
+		}
+		lines = append(lines, line)
+	}
+	if len(lines) != 2 || lines[0]["msg"] != "Recorded payment change" {
+		t.Fatalf("expected the change and the access log to be logged, got %s", out.String())
+	}
+	access := lines[1]
+	for field, expected := range map[string]interface{}{
+		"method":          http.MethodPost,
+		"route":           "/v1/organisations/{org-id}/payments",
+		"status":          float64(http.StatusOK),
+		"payment_id":      created.Data.ID,
+		"organisation_id": payment.OrganisationID,
+	} {
+		if access[field] != expected {
+			t.Errorf("access log has wrong %s: got %v want %v", field, access[field], expected)
+		}
+	}
+	if _, ok := access["latency_ms"].(float64); !ok {
+		t.Errorf("access log has no latency: %v", access)
+	}
+
+	// Check a request ID that could forge log lines is replaced.
+	req, err = http.NewRequest(http.MethodGet, "/healthz", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("X-Request-ID", "bad\nid")
+	recorder = httptest.NewRecorder()
+	srv.ServeHTTP(recorder, req)
+	if _, err := uuid.Parse(recorder.Header().Get("X-Request-ID")); err != nil {
+		t.Errorf("handler did not replace the request ID: got %q", recorder.Header().Get("X-Request-ID"))
+	}
+}
+
+func TestMetrics(t *testing.T) {
+	srv := New(persist.NewInMemoryStore(), nil, WithAuthenticator(auth.Headers{}))
+	send := func(method, path, body, roles string) *httptest.ResponseRecorder {
+		req, err := http.NewRequest(method, path, strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("X-Roles", roles)
+		req.Header.Set("X-Admin", "true")
+		recorder := httptest.NewRecorder()
+		srv.ServeHTTP(recorder, req)
+		return recorder
+	}
+	send(http.MethodPost, APIBase, test.CreatePayment, "operator")
+	send(http.MethodGet, APIBase+"/"+uuid.New().String(), "", "viewer")
+
+	recorder := send(http.MethodGet, "/metrics", "", "")
+	if status := recorder.Code; status != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v", status, http.StatusOK)
+	}
+	for _, expected := range []string{
+		`http_requests_total{method="POST",route="/v1/payment",status="200"} 1`,
+		`http_requests_total{method="GET",route="/v1/payment/{payment-id}",status="404"} 1`,
+		`http_request_duration_seconds_count{method="POST",route="/v1/payment"} 1`,
+		`payment_store_operation_duration_seconds_count{operation="create"} 1`,
+		`payment_store_errors_total{error="not_found",operation="load"} 1`,
+		`payments{currency="GBP",payment_scheme="FPS"} 1`,
+	} {
+		if !strings.Contains(recorder.Body.String(), expected) {
+			t.Errorf("metrics are missing %s:\n%s", expected, recorder.Body)
+		}
+	}
+}
+
+func TestTracing(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	var logs bytes.Buffer
+	srv := New(persist.NewInMemoryStore(), logging.NewJSON(&logs),
+		WithTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))),
+		WithAuthenticator(auth.Headers{}))
+	traceID, parentID := "4bf92f3577b34da6a3ce929d0e0e4736", "00f067aa0ba902b7"
+	req, err := http.NewRequest(http.MethodGet, APIBase+"/"+uuid.New().String(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("traceparent", "00-"+traceID+"-"+parentID+"-01")
+	req.Header.Set("X-Roles", "viewer")
+	req.Header.Set("X-Admin", "true")
+	srv.ServeHTTP(httptest.NewRecorder(), req)
+
+	spans := recorder.Ended()
+	if len(spans) != 2 {
+		t.Fatalf("expected spans for the request and the store but got %d", len(spans))
+	}
+	load, request := spans[0], spans[1]
+	if request.Name() != "GET /v1/payment/{payment-id}" || load.Name() != "payment_store.load" {
+		t.Errorf("expected request and load spans but got %s and %s", request.Name(), load.Name())
+	}
+	if request.SpanContext().TraceID().String() != traceID || request.Parent().SpanID().String() != parentID {
+		t.Errorf("expected the request to continue the trace of the caller but got %v", request.Parent())
+	}
+	if load.Parent().SpanID() != request.SpanContext().SpanID() {
+		t.Errorf("expected the load to be a child of the request span")
+	}
+	if load.Status().Code != codes.Error || request.Status().Code == codes.Error {
+		t.Errorf("expected only the load of the unknown payment to fail but got %v and %v", load.Status(),
+			request.Status())
+	}
+	if !strings.Contains(logs.String(), `"trace_id":"`+traceID+`"`) {
+		t.Errorf("expected the trace ID in the request logs: %s", logs.String())
+	}
+}
+
 func TestUpdateRequest(t *testing.T) {
 	// Pass a mock store to the handler
 	mockStore := &mocks.PaymentStore{}
-	mockStore.On("Update", mock.Anything).Return(nil)
-	handler = payment_handler.NewPaymentHandler(mockStore)
+	mockStore.On("Load", mock.Anything, mock.Anything).Return(decodeSample(t), nil)
+	mockStore.On("Update", mock.Anything, mock.Anything).Return(nil)
+	srv := New(mockStore, nil)
 	req, err := http.NewRequest(http.MethodPut, APIBase, strings.NewReader(test.Payment))
 	if err != nil {
 		t.Fatal(err)
 	}
 
 	recorder := httptest.NewRecorder()
-	updatePaymentHandler(recorder, req)
+	srv.updatePaymentHandler(recorder, req)
 
 	// Check the status code is what we expect.
 	if status := recorder.Code; status != http.StatusOK {
@@ -101,15 +497,16 @@ func TestUpdateRequest(t *testing.T) {
 func TestUpdateRequestFails(t *testing.T) {
 	// Pass a mock store to the handler
 	mockStore := &mocks.PaymentStore{}
-	mockStore.On("Update", mock.Anything).Return(errors.New("failed to update"))
-	handler = payment_handler.NewPaymentHandler(mockStore)
+	mockStore.On("Load", mock.Anything, mock.Anything).Return(decodeSample(t), nil)
+	mockStore.On("Update", mock.Anything, mock.Anything).Return(errors.New("failed to update"))
+	srv := New(mockStore, nil)
 	req, err := http.NewRequest(http.MethodPut, APIBase, strings.NewReader(test.Payment))
 	if err != nil {
 		t.Fatal(err)
 	}
 
 	recorder := httptest.NewRecorder()
-	updatePaymentHandler(recorder, req)
+	srv.updatePaymentHandler(recorder, req)
 
 	// Check the status code is what we expect.
 	if status := recorder.Code; status != http.StatusInternalServerError {
@@ -117,6 +514,202 @@ func TestUpdateRequestFails(t *testing.T) {
 	}
 }
 
+func TestUpdateRequestConflict(t *testing.T) {
+	// Pass a mock store to the handler
+	mockStore := &mocks.PaymentStore{}
+	mockStore.On("Load", mock.Anything, mock.Anything).Return(decodeSample(t), nil)
+	mockStore.On("Update", mock.Anything, mock.Anything).Return(fmt.Errorf("stale version: %w", persist.ErrConflict))
+	srv := New(mockStore, nil)
+	req, err := http.NewRequest(http.MethodPut, APIBase, strings.NewReader(test.Payment))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	recorder := httptest.NewRecorder()
+	srv.updatePaymentHandler(recorder, req)
+
+	// Check the status code is what we expect.
+	if status := recorder.Code; status != http.StatusConflict {
+		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusConflict)
+	}
+}
+
+func TestUpdateRequestIfMatch(t *testing.T) {
+	// Pass a mock store to the handler, the version should come from the If-Match header
+	mockStore := &mocks.PaymentStore{}
+	mockStore.On("Load", mock.Anything, mock.Anything).Return(decodeSample(t), nil)
+	mockStore.On("Update", mock.Anything, mock.MatchedBy(func(payment *api.Payment) bool {
+		return payment.Version == 3
+	})).Return(func(ctx context.Context, payment *api.Payment) error {
+		payment.Version++
+		return nil
+	})
+	srv := New(mockStore, nil)
+	req, err := http.NewRequest(http.MethodPut, APIBase, strings.NewReader(test.Payment))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("If-Match", `"3"`)
+
+	recorder := httptest.NewRecorder()
+	srv.updatePaymentHandler(recorder, req)
+
+	// Check the status code is what we expect.
+	if status := recorder.Code; status != http.StatusOK {
+		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusOK)
+	}
+	if tag := recorder.Header().Get("ETag"); tag != `"4"` {
+		t.Errorf("handler returned wrong ETag: got %v want %v", tag, `"4"`)
+	}
+}
+
+func TestUpdateRequestIfMatchFails(t *testing.T) {
+	// Pass a mock store to the handler
+	mockStore := &mocks.PaymentStore{}
+	mockStore.On("Load", mock.Anything, mock.Anything).Return(decodeSample(t), nil)
+	mockStore.On("Update", mock.Anything, mock.Anything).Return(fmt.Errorf("stale version: %w", persist.ErrConflict))
+	srv := New(mockStore, nil)
+	req, err := http.NewRequest(http.MethodPut, APIBase, strings.NewReader(test.Payment))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("If-Match", `"3"`)
+
+	recorder := httptest.NewRecorder()
+	srv.updatePaymentHandler(recorder, req)
+
+	// Check the status code is what we expect.
+	if status := recorder.Code; status != http.StatusPreconditionFailed {
+		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusPreconditionFailed)
+	}
+}
+
+func TestPatchRequest(t *testing.T) {
+	payment := decodeSample(t)
+
+	// Pass a mock store to the handler, only the patched field should change
+	mockStore := &mocks.PaymentStore{}
+	mockStore.On("Load", mock.Anything, payment.ID).Return(payment, nil)
+	mockStore.On("Update", mock.Anything, mock.MatchedBy(func(patched *api.Payment) bool {
+		return patched.Reference == "Patched" && patched.BeneficiaryParty == payment.BeneficiaryParty &&
+			patched.Version == payment.Version
+	})).Return(nil)
+	srv := New(mockStore, nil)
+
+	recorder := patchRequest(t, srv, payment.ID, patch.MergePatchType, `{"attributes": {"reference": "Patched"}}`, "")
+
+	// Check the status code is what we expect.
+	if status := recorder.Code; status != http.StatusOK {
+		t.Errorf("handler returned wrong status code: got %v want %v: %s", status, http.StatusOK, recorder.Body)
+	}
+	mockStore.AssertExpectations(t)
+}
+
+func TestPatchRequestJSONPatch(t *testing.T) {
+	payment := decodeSample(t)
+
+	// Pass a mock store to the handler
+	mockStore := &mocks.PaymentStore{}
+	mockStore.On("Load", mock.Anything, payment.ID).Return(payment, nil)
+	mockStore.On("Update", mock.Anything, mock.MatchedBy(func(patched *api.Payment) bool {
+		return patched.Reference == "Patched"
+	})).Return(nil)
+	srv := New(mockStore, nil)
+
+	recorder := patchRequest(t, srv, payment.ID, patch.JSONPatchType,
+		`[{"op": "replace", "path": "/attributes/reference", "value": "Patched"}]`, "")
+
+	// Check the status code is what we expect.
+	if status := recorder.Code; status != http.StatusOK {
+		t.Errorf("handler returned wrong status code: got %v want %v: %s", status, http.StatusOK, recorder.Body)
+	}
+	mockStore.AssertExpectations(t)
+}
+
+func TestPatchRequestCanNotDelete(t *testing.T) {
+	payment := decodeSample(t)
+
+	// Pass a mock store to the handler, the deletion in the patch must not be stored
+	mockStore := &mocks.PaymentStore{}
+	mockStore.On("Load", mock.Anything, payment.ID).Return(payment, nil)
+	mockStore.On("Update", mock.Anything, mock.MatchedBy(func(patched *api.Payment) bool {
+		return patched.DeletedAt == nil && patched.DeletedBy == ""
+	})).Return(nil)
+	srv := New(mockStore, nil)
+
+	recorder := patchRequest(t, srv, payment.ID, patch.MergePatchType,
+		`{"deleted_at": "1970-01-01T00:00:00Z", "deleted_by": "nobody"}`, "")
+
+	// Check the status code is what we expect.
+	if status := recorder.Code; status != http.StatusOK {
+		t.Errorf("handler returned wrong status code: got %v want %v: %s", status, http.StatusOK, recorder.Body)
+	}
+	mockStore.AssertExpectations(t)
+}
+
+func TestPatchRequestFails(t *testing.T) {
+	payment := decodeSample(t)
+	tests := []struct {
+		name        string
+		contentType string
+		body        string
+		ifMatch     string
+		status      int
+	}{
+		{"invalid field", patch.MergePatchType, `{"attributes": {"currency": "XXX"}}`, "", http.StatusBadRequest},
+		{"changed id", patch.MergePatchType, `{"id": "` + uuid.New().String() + `"}`, "", http.StatusBadRequest},
+		{"badly formed", patch.MergePatchType, `{"attributes": `, "", http.StatusBadRequest},
+		{"test failed", patch.JSONPatchType, `[{"op": "test", "path": "/type", "value": "Refund"}]`, "",
+			http.StatusUnprocessableEntity},
+		{"unsupported media type", "text/plain", `reference=Patched`, "", http.StatusUnsupportedMediaType},
+		{"stale if-match", patch.MergePatchType, `{"attributes": {"reference": "Patched"}}`, `"7"`,
+			http.StatusPreconditionFailed},
+	}
+
+	for _, tc := range tests {
+		// Pass a mock store to the handler, only a stale version gets as far as the update
+		mockStore := &mocks.PaymentStore{}
+		mockStore.On("Load", mock.Anything, payment.ID).Return(payment, nil)
+		mockStore.On("Update", mock.Anything, mock.Anything).Return(fmt.Errorf("stale version: %w", persist.ErrConflict))
+		srv := New(mockStore, nil)
+
+		recorder := patchRequest(t, srv, payment.ID, tc.contentType, tc.body, tc.ifMatch)
+
+		// Check the status code is what we expect.
+		if status := recorder.Code; status != tc.status {
+			t.Errorf("%s: handler returned wrong status code: got %v want %v", tc.name, status, tc.status)
+		}
+	}
+}
+
+// patchRequest sends a patch for the payment through a router so that the vars will be added to the context.
+func patchRequest(t *testing.T, srv *Server, paymentID, contentType, body, ifMatch string) *httptest.ResponseRecorder {
+	req, err := http.NewRequest(http.MethodPatch, APIBase+"/"+paymentID, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", contentType)
+	if ifMatch != "" {
+		req.Header.Set("If-Match", ifMatch)
+	}
+
+	recorder := httptest.NewRecorder()
+	router := mux.NewRouter()
+	router.HandleFunc(APIBase+"/{payment-id}", srv.patchPaymentHandler)
+	router.ServeHTTP(recorder, req)
+
+	return recorder
+}
+
+func decodeSample(t *testing.T) *api.Payment {
+	payment := &api.Payment{}
+	if err := json.NewDecoder(strings.NewReader(test.Payment)).Decode(payment); err != nil {
+		t.Fatal(err)
+	}
+
+	return payment
+}
+
 func TestGetRequest(t *testing.T) {
 	dec := json.NewDecoder(strings.NewReader(test.Payment))
 	payment := &api.Payment{}
@@ -127,8 +720,8 @@ func TestGetRequest(t *testing.T) {
 
 	// Pass a mock store to the handler
 	mockStore := &mocks.PaymentStore{}
-	mockStore.On("Load", mock.Anything).Return(payment, nil)
-	handler = payment_handler.NewPaymentHandler(mockStore)
+	mockStore.On("Load", mock.Anything, mock.Anything).Return(payment, nil)
+	srv := New(mockStore, nil)
 
 	path := fmt.Sprintf("%s/%s", APIBase, uuid.New().String())
 	req, err := http.NewRequest(http.MethodGet, path, nil)
@@ -141,20 +734,317 @@ func TestGetRequest(t *testing.T) {
 	// Need to create a router that we can pass the request through so that the vars will be added to the context
 	router := mux.NewRouter()
 	pathPattern := fmt.Sprintf("%s/{payment-id}", APIBase)
-	router.HandleFunc(pathPattern, getPaymentHandler)
+	router.HandleFunc(pathPattern, srv.getPaymentHandler)
 	router.ServeHTTP(recorder, req)
 
 	// Check the status code is what we expect.
 	if status := recorder.Code; status != http.StatusOK {
 		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusOK)
 	}
+	if tag := recorder.Header().Get("ETag"); tag != `"0"` {
+		t.Errorf("handler returned wrong ETag: got %v want %v", tag, `"0"`)
+	}
+
+	// Check the payment is wrapped with a link to itself.
+	response := &api.PaymentHolder{}
+	if err := json.NewDecoder(recorder.Body).Decode(response); err != nil {
+		t.Fatal(err)
+	}
+	if response.Data.ID != payment.ID {
+		t.Errorf("handler returned wrong payment: got %v want %v", response.Data.ID, payment.ID)
+	}
+	if expected := APIBase + "/" + payment.ID; response.Links.Self != expected {
+		t.Errorf("handler returned wrong self link: got %v want %v", response.Links.Self, expected)
+	}
+}
+
+func TestOrganisationRoutes(t *testing.T) {
+	payment := decodeSample(t)
+	otherOrganisation := uuid.New().String()
+
+	// Pass a mock store to the handler, the payment belongs to the organisation of the sample
+	mockStore := &mocks.PaymentStore{}
+	mockStore.On("Load", mock.Anything, payment.ID).Return(payment, nil)
+	srv := New(mockStore, nil, WithAuthenticator(auth.Headers{}))
+
+	ownPath := "/v1/organisations/" + payment.OrganisationID + "/payments/" + payment.ID
+	tests := []struct {
+		name         string
+		path         string
+		organisation string
+		admin        bool
+		status       int
+		self         string
+	}{
+		{"own organisation", ownPath, payment.OrganisationID, false, http.StatusOK, ownPath},
+		{"other organisation", ownPath, otherOrganisation, false, http.StatusForbidden, ""},
+		{"payment of other organisation", "/v1/organisations/" + otherOrganisation + "/payments/" + payment.ID,
+			otherOrganisation, false, http.StatusNotFound, ""},
+		{"no organisation", ownPath, "", false, http.StatusForbidden, ""},
+		{"admin", ownPath, "", true, http.StatusOK, ownPath},
+		{"admin route", APIBase + "/" + payment.ID, "", true, http.StatusOK, APIBase + "/" + payment.ID},
+		{"admin route without organisation", APIBase + "/" + payment.ID, "", false, http.StatusForbidden, ""},
+		{"admin route without admin", APIBase + "/" + payment.ID, payment.OrganisationID, false,
+			http.StatusForbidden, ""},
+		{"admin list without admin", "/v1/payments", payment.OrganisationID, false, http.StatusForbidden, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodGet, tt.path, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.organisation != "" {
+				req.Header.Set("X-Organisation-ID", tt.organisation)
+			}
+			if tt.admin {
+				req.Header.Set("X-Admin", "true")
+			}
+			req.Header.Set("X-Roles", "viewer")
+
+			recorder := httptest.NewRecorder()
+			srv.ServeHTTP(recorder, req)
+
+			// Check the status code is what we expect.
+			if status := recorder.Code; status != tt.status {
+				t.Fatalf("handler returned wrong status code: got %v want %v: %s", status, tt.status, recorder.Body)
+			}
+			if tt.self == "" {
+				return
+			}
+			response := &api.PaymentHolder{}
+			if err := json.NewDecoder(recorder.Body).Decode(response); err != nil {
+				t.Fatal(err)
+			}
+			if response.Links.Self != tt.self {
+				t.Errorf("handler returned wrong self link: got %s want %s", response.Links.Self, tt.self)
+			}
+		})
+	}
+	mockStore.AssertNotCalled(t, "List", mock.Anything, mock.Anything)
+}
+
+func TestServersAreIndependent(t *testing.T) {
+	// Two servers in one process, each with its own store
+	first := New(persist.NewInMemoryStore(), nil, WithAuthenticator(auth.Headers{}))
+	second := New(persist.NewInMemoryStore(), nil, WithAuthenticator(auth.Headers{}))
+	req, err := http.NewRequest(http.MethodPost, APIBase, strings.NewReader(test.CreatePayment))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("X-Roles", "operator")
+	req.Header.Set("X-Admin", "true")
+	recorder := httptest.NewRecorder()
+	first.ServeHTTP(recorder, req)
+	if status := recorder.Code; status != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v: %s", status, http.StatusOK, recorder.Body)
+	}
+	created := &api.PaymentHolder{}
+	if err := json.NewDecoder(recorder.Body).Decode(created); err != nil {
+		t.Fatal(err)
+	}
+
+	// Check the payment can only be found through the server it was created with.
+	for _, tt := range []struct {
+		name   string
+		server *Server
+		status int
+	}{
+		{"first", first, http.StatusOK},
+		{"second", second, http.StatusNotFound},
+	} {
+		req, err := http.NewRequest(http.MethodGet, APIBase+"/"+created.Data.ID, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("X-Roles", "viewer")
+		req.Header.Set("X-Admin", "true")
+		recorder := httptest.NewRecorder()
+		tt.server.ServeHTTP(recorder, req)
+		if status := recorder.Code; status != tt.status {
+			t.Errorf("%s: handler returned wrong status code: got %v want %v", tt.name, status, tt.status)
+		}
+	}
+}
+
+func TestAuthentication(t *testing.T) {
+	payment := decodeSample(t)
+
+	// Pass a mock store to the handler and only accept an API key
+	mockStore := &mocks.PaymentStore{}
+	mockStore.On("Load", mock.Anything, payment.ID).Return(payment, nil)
+	sum := sha256.Sum256([]byte("secret-key"))
+	apiKeys, err := auth.NewAPIKeys([]auth.APIKey{
+		{KeySHA256: hex.EncodeToString(sum[:]), Subject: "alice", OrganisationID: payment.OrganisationID,
+			Roles: []string{"viewer"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := New(mockStore, nil, WithAuthenticator(apiKeys))
+
+	path := "/v1/organisations/" + payment.OrganisationID + "/payments/" + payment.ID
+	for key, want := range map[string]int{"": http.StatusUnauthorized, "wrong-key": http.StatusUnauthorized,
+		"secret-key": http.StatusOK} {
+		req, err := http.NewRequest(http.MethodGet, path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if key != "" {
+			req.Header.Set("X-API-Key", key)
+		}
+		// the headers of a gateway are not trusted once keys are in use
+		req.Header.Set("X-Actor", "mallory")
+
+		recorder := httptest.NewRecorder()
+		srv.ServeHTTP(recorder, req)
+
+		// Check the status code is what we expect.
+		if status := recorder.Code; status != want {
+			t.Errorf("handler returned wrong status code for key %q: got %v want %v", key, status, want)
+		}
+		if want == http.StatusUnauthorized && recorder.Header().Get("WWW-Authenticate") == "" {
+			t.Errorf("expected a WWW-Authenticate header for key %q", key)
+		}
+	}
+}
+
+func TestAuthenticationRequired(t *testing.T) {
+	// Without an authenticator the headers of a gateway must not be trusted
+	mockStore := &mocks.PaymentStore{}
+	srv := New(mockStore, nil)
+	req, err := http.NewRequest(http.MethodGet, "/v1/payments", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("X-Actor", "mallory")
+	req.Header.Set("X-Roles", "admin")
+	req.Header.Set("X-Admin", "true")
+
+	recorder := httptest.NewRecorder()
+	srv.ServeHTTP(recorder, req)
+
+	// Check the status code is what we expect.
+	if status := recorder.Code; status != http.StatusUnauthorized {
+		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusUnauthorized)
+	}
+	mockStore.AssertNotCalled(t, "List", mock.Anything, mock.Anything)
+}
+
+func TestAuthorization(t *testing.T) {
+	payment := decodeSample(t)
+
+	// Pass a mock store to the handler, only the allowed requests should reach it
+	mockStore := &mocks.PaymentStore{}
+	mockStore.On("Load", mock.Anything, payment.ID).Return(payment, nil)
+	mockStore.On("Update", mock.Anything, mock.Anything).Return(nil)
+	srv := New(mockStore, nil, WithAuthenticator(auth.Headers{}))
+
+	path := "/v1/organisations/" + payment.OrganisationID + "/payments/" + payment.ID
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		roles  string
+		status int
+	}{
+		{"viewer reads", http.MethodGet, path, "viewer", http.StatusOK},
+		{"viewer deletes", http.MethodDelete, path, "viewer", http.StatusForbidden},
+		{"operator transitions", http.MethodPost, path + "/validate", "operator", http.StatusForbidden},
+		{"approver transitions", http.MethodPost, path + "/validate", "approver", http.StatusOK},
+		{"approver deletes", http.MethodDelete, path, "approver", http.StatusForbidden},
+		{"admin deletes", http.MethodDelete, path, "viewer, admin", http.StatusOK},
+		{"no roles", http.MethodGet, path, "", http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequest(tt.method, tt.path, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			req.Header.Set("X-Organisation-ID", payment.OrganisationID)
+			req.Header.Set("X-Roles", tt.roles)
+
+			recorder := httptest.NewRecorder()
+			srv.ServeHTTP(recorder, req)
+
+			// Check the status code is what we expect.
+			if status := recorder.Code; status != tt.status {
+				t.Fatalf("handler returned wrong status code: got %v want %v: %s", status, tt.status, recorder.Body)
+			}
+			if tt.status != http.StatusForbidden {
+				return
+			}
+			response := &api.ErrorHolder{}
+			if err := json.NewDecoder(recorder.Body).Decode(response); err != nil {
+				t.Fatal(err)
+			}
+			if len(response.Errors) != 1 || response.Errors[0].Code != "permission_denied" ||
+				response.Errors[0].Meta == nil || len(response.Errors[0].Meta.RequiredRoles) == 0 {
+				t.Errorf("handler returned wrong errors: got %+v want a single permission_denied", response.Errors)
+			}
+		})
+	}
+}
+
+func TestApprovalRequest(t *testing.T) {
+	srv := New(persist.NewInMemoryStore(), nil, WithAuthenticator(auth.Headers{}),
+		WithApprovals(approval.NewInMemoryStore(), approval.Thresholds{"GBP": money.MustParse("100.00")}))
+	payment := decodeSample(t)
+	if err := srv.handler.Create(audit.WithActor(context.Background(), "alice"), payment); err != nil {
+		t.Fatal(err)
+	}
+
+	path := "/v1/organisations/" + payment.OrganisationID + "/payments/" + payment.ID
+	tests := []struct {
+		name   string
+		path   string
+		actor  string
+		status int
+		code   string
+	}{
+		{"validate", path + "/validate", "bob", http.StatusOK, ""},
+		{"submit before approval", path + "/submit", "bob", http.StatusConflict, "approval_required"},
+		{"creator approves", path + "/approval/approve", "alice", http.StatusForbidden, "self_approval"},
+		{"other approves", path + "/approval/approve", "bob", http.StatusOK, ""},
+		{"approve twice", path + "/approval/reject", "carol", http.StatusConflict, "approval_not_pending"},
+		{"submit after approval", path + "/submit", "bob", http.StatusOK, ""},
+	}
+	for _, tt := range tests {
+		req, err := http.NewRequest(http.MethodPost, tt.path, strings.NewReader(`{"reason": "checked"}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("X-Actor", tt.actor)
+		req.Header.Set("X-Organisation-ID", payment.OrganisationID)
+		req.Header.Set("X-Roles", "approver")
+
+		recorder := httptest.NewRecorder()
+		srv.ServeHTTP(recorder, req)
+
+		// Check the status code is what we expect.
+		if status := recorder.Code; status != tt.status {
+			t.Fatalf("%s: handler returned wrong status code: got %v want %v: %s", tt.name, status, tt.status,
+				recorder.Body)
+		}
+		if tt.code == "" {
+			continue
+		}
+		response := &api.ErrorHolder{}
+		if err := json.NewDecoder(recorder.Body).Decode(response); err != nil {
+			t.Fatal(err)
+		}
+		if len(response.Errors) != 1 || response.Errors[0].Code != tt.code {
+			t.Errorf("%s: handler returned wrong errors: got %+v want a single %s", tt.name, response.Errors, tt.code)
+		}
+	}
 }
 
 func TestGetRequestFails(t *testing.T) {
 	// Pass a mock store to the handler
 	mockStore := &mocks.PaymentStore{}
-	mockStore.On("Load", mock.Anything).Return(nil, errors.New("failed to load"))
-	handler = payment_handler.NewPaymentHandler(mockStore)
+	mockStore.On("Load", mock.Anything, mock.Anything).Return(nil, errors.New("failed to load"))
+	srv := New(mockStore, nil)
 	path := fmt.Sprintf("%s/%s", APIBase, uuid.New().String())
 	req, err := http.NewRequest(http.MethodGet, path, nil)
 	if err != nil {
@@ -166,7 +1056,7 @@ func TestGetRequestFails(t *testing.T) {
 	// Need to create a router that we can pass the request through so that the vars will be added to the context
 	router := mux.NewRouter()
 	pathPattern := fmt.Sprintf("%s/{payment-id}", APIBase)
-	router.HandleFunc(pathPattern, getPaymentHandler)
+	router.HandleFunc(pathPattern, srv.getPaymentHandler)
 	router.ServeHTTP(recorder, req)
 
 	// Check the status code is what we expect.
@@ -175,24 +1065,203 @@ func TestGetRequestFails(t *testing.T) {
 	}
 }
 
-func TestDeleteRequest(t *testing.T) {
+func TestGetRequestNotFound(t *testing.T) {
 	// Pass a mock store to the handler
 	mockStore := &mocks.PaymentStore{}
-	mockStore.On("Delete", mock.Anything).Return(nil)
-	handler = payment_handler.NewPaymentHandler(mockStore)
+	mockStore.On("Load", mock.Anything, mock.Anything).Return(nil, fmt.Errorf("payment %w", persist.ErrNotFound))
+	srv := New(mockStore, nil)
+	path := fmt.Sprintf("%s/%s", APIBase, uuid.New().String())
+	req, err := http.NewRequest(http.MethodGet, path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	recorder := httptest.NewRecorder()
+
+	// Need to create a router that we can pass the request through so that the vars will be added to the context
+	router := mux.NewRouter()
+	pathPattern := fmt.Sprintf("%s/{payment-id}", APIBase)
+	router.HandleFunc(pathPattern, srv.getPaymentHandler)
+	router.ServeHTTP(recorder, req)
+
+	// Check the status code is what we expect.
+	if status := recorder.Code; status != http.StatusNotFound {
+		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusNotFound)
+	}
+
+	// Check the error is described in the error envelope.
+	response := &api.ErrorHolder{}
+	if err := json.NewDecoder(recorder.Body).Decode(response); err != nil {
+		t.Fatal(err)
+	}
+	if len(response.Errors) != 1 || response.Errors[0].Code != "not_found" {
+		t.Errorf("handler returned wrong errors: got %+v want a single not_found", response.Errors)
+	}
+}
+
+func TestHistoryRequest(t *testing.T) {
+	// Use a real store so the handler records the changes made through it
+	srv := New(persist.NewInMemoryStore(), nil)
+	payment := decodeSample(t)
+	if err := srv.handler.Create(context.Background(), payment); err != nil {
+		t.Fatal(err)
+	}
+	update := *payment
+	update.Reference = "Changed"
+	if err := srv.handler.Update(context.Background(), &update); err != nil {
+		t.Fatal(err)
+	}
+
+	router := mux.NewRouter()
+	router.HandleFunc(APIBase+"/{payment-id}/history", srv.historyHandler)
+	router.HandleFunc(APIBase+"/{payment-id}", srv.getPaymentHandler)
+
+	req, err := http.NewRequest(http.MethodGet, APIBase+"/"+payment.ID+"/history", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, req)
+
+	// Check both changes are in the history.
+	if status := recorder.Code; status != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v", status, http.StatusOK)
+	}
+	history := &api.HistoryHolder{}
+	if err := json.NewDecoder(recorder.Body).Decode(history); err != nil {
+		t.Fatal(err)
+	}
+	if len(history.Data) != 2 || history.Data[1].Action != api.ActionUpdated {
+		t.Errorf("handler returned wrong history: %+v", history.Data)
+	}
+
+	// Check the original version can still be fetched.
+	req, err = http.NewRequest(http.MethodGet, APIBase+"/"+payment.ID+"?version=0", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	recorder = httptest.NewRecorder()
+	router.ServeHTTP(recorder, req)
+
+	response := &api.PaymentHolder{}
+	if err := json.NewDecoder(recorder.Body).Decode(response); err != nil {
+		t.Fatal(err)
+	}
+	if response.Data.Version != 0 || response.Data.Reference != payment.Reference {
+		t.Errorf("handler returned wrong version: %+v", response.Data)
+	}
+}
+
+func TestStoreErrorStatus(t *testing.T) {
+	tests := []struct {
+		err    error
+		status int
+	}{
+		{fmt.Errorf("payment %w", persist.ErrNotFound), http.StatusNotFound},
+		{fmt.Errorf("payment %w", persist.ErrConflict), http.StatusConflict},
+		{fmt.Errorf("payment %w", persist.ErrAlreadyExists), http.StatusConflict},
+		{fmt.Errorf("payment %w", persist.ErrInvalid), http.StatusUnprocessableEntity},
+		{fmt.Errorf("payment %w", payment_handler.ErrIllegalTransition), http.StatusConflict},
+		{errors.New("disk on fire"), http.StatusInternalServerError},
+	}
+
+	for _, tc := range tests {
+		if status := storeErrorStatus(tc.err); status != tc.status {
+			t.Errorf("wrong status code for %v: got %v want %v", tc.err, status, tc.status)
+		}
+	}
+}
+
+func TestTransitionRequest(t *testing.T) {
+	payment := decodeSample(t)
+	payment.Status = api.StatusValidated
+
+	// Pass a mock store to the handler, the change should be recorded against the actor
+	mockStore := &mocks.PaymentStore{}
+	mockStore.On("Load", mock.Anything, payment.ID).Return(payment, nil)
+	mockStore.On("Update", mock.Anything, mock.MatchedBy(func(changed *api.Payment) bool {
+		if changed.Status != api.StatusSubmitted || len(changed.StatusHistory) != 1 {
+			return false
+		}
+		change := changed.StatusHistory[0]
+		return change.From == api.StatusValidated && change.Actor == "alice" && change.Reason == "ready"
+	})).Return(nil)
+	srv := New(mockStore, nil)
+
+	recorder := transitionRequest(t, srv, payment.ID, "submit", api.StatusSubmitted, `{"reason": "ready"}`)
+
+	// Check the status code is what we expect.
+	if status := recorder.Code; status != http.StatusOK {
+		t.Errorf("handler returned wrong status code: got %v want %v: %s", status, http.StatusOK, recorder.Body)
+	}
+	mockStore.AssertExpectations(t)
+}
+
+func TestTransitionRequestIllegal(t *testing.T) {
+	payment := decodeSample(t)
+	payment.Status = api.StatusSettled
+
+	// Pass a mock store to the handler, a settled payment can not be cancelled
+	mockStore := &mocks.PaymentStore{}
+	mockStore.On("Load", mock.Anything, payment.ID).Return(payment, nil)
+	srv := New(mockStore, nil)
+
+	recorder := transitionRequest(t, srv, payment.ID, "cancel", api.StatusCancelled, "")
+
+	// Check the status code is what we expect.
+	if status := recorder.Code; status != http.StatusConflict {
+		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusConflict)
+	}
+	response := &api.ErrorHolder{}
+	if err := json.NewDecoder(recorder.Body).Decode(response); err != nil {
+		t.Fatal(err)
+	}
+	if len(response.Errors) != 1 || response.Errors[0].Code != "illegal_transition" {
+		t.Errorf("handler returned wrong errors: got %+v want a single illegal_transition", response.Errors)
+	}
+	mockStore.AssertNotCalled(t, "Update", mock.Anything, mock.Anything)
+}
+
+// transitionRequest sends a status change for the payment, as alice, through a router so that the vars will be added
+// to the context.
+func transitionRequest(t *testing.T, srv *Server, paymentID, action string, status api.Status,
+	body string) *httptest.ResponseRecorder {
+	req, err := http.NewRequest(http.MethodPost, APIBase+"/"+paymentID+"/"+action, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("X-Actor", "alice")
+
+	recorder := httptest.NewRecorder()
+	router := mux.NewRouter()
+	router.HandleFunc(APIBase+"/{payment-id}/"+action, srv.transitionHandler(status))
+	router.ServeHTTP(recorder, req)
+
+	return recorder
+}
+
+func TestDeleteRequest(t *testing.T) {
+	// Pass a mock store to the handler, the payment should be kept with a tombstone
+	mockStore := &mocks.PaymentStore{}
+	mockStore.On("Load", mock.Anything, mock.Anything).Return(decodeSample(t), nil)
+	mockStore.On("Update", mock.Anything, mock.MatchedBy(func(payment *api.Payment) bool {
+		return payment.DeletedAt != nil && payment.DeletedBy == "alice"
+	})).Return(nil)
+	srv := New(mockStore, nil)
 
 	path := fmt.Sprintf("%s/%s", APIBase, uuid.New().String())
 	req, err := http.NewRequest(http.MethodDelete, path, nil)
 	if err != nil {
 		t.Fatal(err)
 	}
+	req.Header.Set("X-Actor", "alice")
 
 	recorder := httptest.NewRecorder()
 
 	// Need to create a router that we can pass the request through so that the vars will be added to the context
 	router := mux.NewRouter()
 	pathPattern := fmt.Sprintf("%s/{payment-id}", APIBase)
-	router.HandleFunc(pathPattern, deletePaymentHandler)
+	router.HandleFunc(pathPattern, srv.deletePaymentHandler)
 	router.ServeHTTP(recorder, req)
 
 	// Check the status code is what we expect.
@@ -204,8 +1273,9 @@ func TestDeleteRequest(t *testing.T) {
 func TestDeleteRequestFails(t *testing.T) {
 	// Pass a mock store to the handler
 	mockStore := &mocks.PaymentStore{}
-	mockStore.On("Delete", mock.Anything).Return(errors.New("delete failed"))
-	handler = payment_handler.NewPaymentHandler(mockStore)
+	mockStore.On("Load", mock.Anything, mock.Anything).Return(decodeSample(t), nil)
+	mockStore.On("Update", mock.Anything, mock.Anything).Return(errors.New("delete failed"))
+	srv := New(mockStore, nil)
 
 	path := fmt.Sprintf("%s/%s", APIBase, uuid.New().String())
 	req, err := http.NewRequest(http.MethodDelete, path, nil)
@@ -218,7 +1288,7 @@ func TestDeleteRequestFails(t *testing.T) {
 	// Need to create a router that we can pass the request through so that the vars will be added to the context
 	router := mux.NewRouter()
 	pathPattern := fmt.Sprintf("%s/{payment-id}", APIBase)
-	router.HandleFunc(pathPattern, deletePaymentHandler)
+	router.HandleFunc(pathPattern, srv.deletePaymentHandler)
 	router.ServeHTTP(recorder, req)
 
 	// Check the status code is what we expect.
@@ -227,6 +1297,93 @@ func TestDeleteRequestFails(t *testing.T) {
 	}
 }
 
+func TestDeleteRequestNotFound(t *testing.T) {
+	// Pass a mock store to the handler
+	mockStore := &mocks.PaymentStore{}
+	mockStore.On("Load", mock.Anything, mock.Anything).Return(nil, fmt.Errorf("payment %w", persist.ErrNotFound))
+	srv := New(mockStore, nil)
+
+	path := fmt.Sprintf("%s/%s", APIBase, uuid.New().String())
+	req, err := http.NewRequest(http.MethodDelete, path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	recorder := httptest.NewRecorder()
+
+	// Need to create a router that we can pass the request through so that the vars will be added to the context
+	router := mux.NewRouter()
+	pathPattern := fmt.Sprintf("%s/{payment-id}", APIBase)
+	router.HandleFunc(pathPattern, srv.deletePaymentHandler)
+	router.ServeHTTP(recorder, req)
+
+	// Check the status code is what we expect.
+	if status := recorder.Code; status != http.StatusNotFound {
+		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusNotFound)
+	}
+}
+
+func TestRestoreRequest(t *testing.T) {
+	deleted := decodeSample(t)
+	deletedAt := time.Now()
+	deleted.DeletedAt, deleted.DeletedBy = &deletedAt, "bob"
+
+	// Pass a mock store to the handler, the tombstone should be cleared
+	mockStore := &mocks.PaymentStore{}
+	mockStore.On("Load", mock.Anything, deleted.ID).Return(deleted, nil)
+	mockStore.On("Update", mock.Anything, mock.MatchedBy(func(payment *api.Payment) bool {
+		return payment.DeletedAt == nil && payment.DeletedBy == ""
+	})).Return(nil)
+	srv := New(mockStore, nil)
+
+	recorder := restoreRequest(t, srv, deleted.ID)
+
+	// Check the status code is what we expect.
+	if status := recorder.Code; status != http.StatusOK {
+		t.Errorf("handler returned wrong status code: got %v want %v: %s", status, http.StatusOK, recorder.Body)
+	}
+	mockStore.AssertExpectations(t)
+}
+
+func TestRestoreRequestNotDeleted(t *testing.T) {
+	payment := decodeSample(t)
+
+	// Pass a mock store to the handler
+	mockStore := &mocks.PaymentStore{}
+	mockStore.On("Load", mock.Anything, payment.ID).Return(payment, nil)
+	srv := New(mockStore, nil)
+
+	recorder := restoreRequest(t, srv, payment.ID)
+
+	// Check the status code is what we expect.
+	if status := recorder.Code; status != http.StatusConflict {
+		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusConflict)
+	}
+	response := &api.ErrorHolder{}
+	if err := json.NewDecoder(recorder.Body).Decode(response); err != nil {
+		t.Fatal(err)
+	}
+	if len(response.Errors) != 1 || response.Errors[0].Code != "not_deleted" {
+		t.Errorf("handler returned wrong errors: got %+v want a single not_deleted", response.Errors)
+	}
+	mockStore.AssertNotCalled(t, "Update", mock.Anything, mock.Anything)
+}
+
+// restoreRequest sends a restore for the payment through a router so that the vars will be added to the context.
+func restoreRequest(t *testing.T, srv *Server, paymentID string) *httptest.ResponseRecorder {
+	req, err := http.NewRequest(http.MethodPost, APIBase+"/"+paymentID+"/restore", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	recorder := httptest.NewRecorder()
+	router := mux.NewRouter()
+	router.HandleFunc(APIBase+"/{payment-id}/restore", srv.restorePaymentHandler)
+	router.ServeHTTP(recorder, req)
+
+	return recorder
+}
+
 func TestListRequest(t *testing.T) {
 	dec := json.NewDecoder(strings.NewReader(test.Payment))
 	payment := &api.Payment{}
@@ -242,8 +1399,8 @@ func TestListRequest(t *testing.T) {
 
 	// Pass a mock store to the handler
 	mockStore := &mocks.PaymentStore{}
-	mockStore.On("List", mock.Anything).Return(result, nil)
-	handler = payment_handler.NewPaymentHandler(mockStore)
+	mockStore.On("List", mock.Anything, mock.Anything).Return(result, nil)
+	srv := New(mockStore, nil)
 
 	req, err := http.NewRequest(http.MethodGet, "/v1/payments", nil)
 	if err != nil {
@@ -251,7 +1408,7 @@ func TestListRequest(t *testing.T) {
 	}
 
 	recorder := httptest.NewRecorder()
-	listPaymentsHandler(recorder, req)
+	srv.listPaymentsHandler(recorder, req)
 
 	// Check the status code is what we expect.
 	if status := recorder.Code; status != http.StatusOK {
@@ -259,11 +1416,104 @@ func TestListRequest(t *testing.T) {
 	}
 }
 
+func TestListRequestPaging(t *testing.T) {
+	dec := json.NewDecoder(strings.NewReader(test.Payment))
+	payment := &api.Payment{}
+	err := dec.Decode(payment)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result := &api.ListHolder{Meta: &api.ListMeta{TotalCount: 7}}
+	for i := 0; i < 3; i++ {
+		result.Data = append(result.Data, *payment)
+	}
+
+	// Pass a mock store to the handler, the query parameters should be turned into a store query
+	expected := persist.ListQuery{
+		Filter:     persist.ListFilter{Currency: "GBP", ProcessingDateFrom: "2017-01-01"},
+		Sort:       persist.SortByAmount,
+		Descending: true,
+		Offset:     3,
+		Limit:      3,
+	}
+	mockStore := &mocks.PaymentStore{}
+	mockStore.On("List", mock.Anything, expected).Return(result, nil)
+	srv := New(mockStore, nil)
+
+	path := "/v1/payments?page[number]=1&page[size]=3&sort=-amount&filter[currency]=GBP" +
+		"&filter[processing_date_from]=2017-01-01"
+	req, err := http.NewRequest(http.MethodGet, path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	recorder := httptest.NewRecorder()
+	srv.listPaymentsHandler(recorder, req)
+
+	// Check the status code is what we expect.
+	if status := recorder.Code; status != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v", status, http.StatusOK)
+	}
+
+	response := &api.ListHolder{}
+	if err := json.NewDecoder(recorder.Body).Decode(response); err != nil {
+		t.Fatal(err)
+	}
+	for name, link := range map[string]string{
+		"first": response.Links.First,
+		"prev":  response.Links.Prev,
+		"next":  response.Links.Next,
+		"last":  response.Links.Last,
+	} {
+		if link == "" {
+			t.Errorf("expected a %s link but it was empty", name)
+		}
+	}
+	if !strings.Contains(response.Links.Last, "page%5Bnumber%5D=2") {
+		t.Errorf("expected last link to be page 2 but was %s", response.Links.Last)
+	}
+	if !strings.Contains(response.Links.Next, "filter%5Bcurrency%5D=GBP") {
+		t.Errorf("expected next link to keep the filter but was %s", response.Links.Next)
+	}
+}
+
+func TestListRequestBadQuery(t *testing.T) {
+	queries := []string{"page[size]=0", "page[number]=-1", "sort=reference", "filter[processing_date_to]=18/01/2017",
+		"filter[include_deleted]=yes"}
+	srv := New(&mocks.PaymentStore{}, nil)
+	for _, query := range queries {
+		req, err := http.NewRequest(http.MethodGet, "/v1/payments?"+query, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		recorder := httptest.NewRecorder()
+		srv.listPaymentsHandler(recorder, req)
+
+		// Check the status code is what we expect.
+		if status := recorder.Code; status != http.StatusBadRequest {
+			t.Errorf("handler returned wrong status code for %s: got %v want %v", query, status, http.StatusBadRequest)
+		}
+
+		// Check the error points at the parameter.
+		response := &api.ErrorHolder{}
+		if err := json.NewDecoder(recorder.Body).Decode(response); err != nil {
+			t.Fatal(err)
+		}
+		parameter := strings.SplitN(query, "=", 2)[0]
+		if len(response.Errors) != 1 || response.Errors[0].Source == nil ||
+			response.Errors[0].Source.Parameter != parameter {
+			t.Errorf("handler returned wrong errors for %s: got %+v", query, response.Errors)
+		}
+	}
+}
+
 func TestListRequestFails(t *testing.T) {
 	// Pass a mock store to the handler
 	mockStore := &mocks.PaymentStore{}
-	mockStore.On("List", mock.Anything).Return(nil, errors.New("list failed"))
-	handler = payment_handler.NewPaymentHandler(mockStore)
+	mockStore.On("List", mock.Anything, mock.Anything).Return(nil, errors.New("list failed"))
+	srv := New(mockStore, nil)
 
 	req, err := http.NewRequest(http.MethodGet, "/v1/payments", nil)
 	if err != nil {
@@ -271,7 +1521,7 @@ func TestListRequestFails(t *testing.T) {
 	}
 
 	recorder := httptest.NewRecorder()
-	listPaymentsHandler(recorder, req)
+	srv.listPaymentsHandler(recorder, req)
 
 	// Check the status code is what we expect.
 	if status := recorder.Code; status != http.StatusInternalServerError {
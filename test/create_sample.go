@@ -35,7 +35,7 @@ const CreatePayment = `
       "currency":"GBP",
       "debtor_party":{
          "account_name":"EJ Brown Black",
-         "account_number":"GB29XABC10161234567801",
+         "account_number":"GB83XABC10161234567801",
          "account_number_code":"IBAN",
          "address":"10 Debtor Crescent Sourcetown NE1",
          "bank_id":"203301",
@@ -38,7 +38,7 @@ const ListResponse = `
         "currency": "GBP",
         "debtor_party": {
           "account_name": "EJ Brown Black",
-          "account_number": "GB29XABC10161234567801",
+          "account_number": "GB83XABC10161234567801",
           "account_number_code": "IBAN",
           "address": "10 Debtor Crescent Sourcetown NE1",
           "bank_id": "203301",
@@ -103,7 +103,7 @@ const ListResponse = `
         "currency": "GBP",
         "debtor_party": {
           "account_name": "EJ Brown Black",
-          "account_number": "GB29XABC10161234567801",
+          "account_number": "GB83XABC10161234567801",
           "account_number_code": "IBAN",
           "address": "10 Debtor Crescent Sourcetown NE1",
           "bank_id": "203301",
@@ -168,7 +168,7 @@ const ListResponse = `
         "currency": "GBP",
         "debtor_party": {
           "account_name": "EJ Brown Black",
-          "account_number": "GB29XABC10161234567801",
+          "account_number": "GB83XABC10161234567801",
           "account_number_code": "IBAN",
           "address": "10 Debtor Crescent Sourcetown NE1",
           "bank_id": "203301",
@@ -233,7 +233,7 @@ const ListResponse = `
         "currency": "GBP",
         "debtor_party": {
           "account_name": "EJ Brown Black",
-          "account_number": "GB29XABC10161234567801",
+          "account_number": "GB83XABC10161234567801",
           "account_number_code": "IBAN",
           "address": "10 Debtor Crescent Sourcetown NE1",
           "bank_id": "203301",
@@ -298,7 +298,7 @@ const ListResponse = `
         "currency": "GBP",
         "debtor_party": {
           "account_name": "EJ Brown Black",
-          "account_number": "GB29XABC10161234567801",
+          "account_number": "GB83XABC10161234567801",
           "account_number_code": "IBAN",
           "address": "10 Debtor Crescent Sourcetown NE1",
           "bank_id": "203301",
@@ -363,7 +363,7 @@ const ListResponse = `
         "currency": "GBP",
         "debtor_party": {
           "account_name": "EJ Brown Black",
-          "account_number": "GB29XABC10161234567801",
+          "account_number": "GB83XABC10161234567801",
           "account_number_code": "IBAN",
           "address": "10 Debtor Crescent Sourcetown NE1",
           "bank_id": "203301",
@@ -428,7 +428,7 @@ const ListResponse = `
         "currency": "GBP",
         "debtor_party": {
           "account_name": "EJ Brown Black",
-          "account_number": "GB29XABC10161234567801",
+          "account_number": "GB83XABC10161234567801",
           "account_number_code": "IBAN",
           "address": "10 Debtor Crescent Sourcetown NE1",
           "bank_id": "203301",
@@ -493,7 +493,7 @@ const ListResponse = `
         "currency": "GBP",
         "debtor_party": {
           "account_name": "EJ Brown Black",
-          "account_number": "GB29XABC10161234567801",
+          "account_number": "GB83XABC10161234567801",
           "account_number_code": "IBAN",
           "address": "10 Debtor Crescent Sourcetown NE1",
           "bank_id": "203301",
@@ -558,7 +558,7 @@ const ListResponse = `
         "currency": "GBP",
         "debtor_party": {
           "account_name": "EJ Brown Black",
-          "account_number": "GB29XABC10161234567801",
+          "account_number": "GB83XABC10161234567801",
           "account_number_code": "IBAN",
           "address": "10 Debtor Crescent Sourcetown NE1",
           "bank_id": "203301",
@@ -623,7 +623,7 @@ const ListResponse = `
         "currency": "GBP",
         "debtor_party": {
           "account_name": "EJ Brown Black",
-          "account_number": "GB29XABC10161234567801",
+          "account_number": "GB83XABC10161234567801",
           "account_number_code": "IBAN",
           "address": "10 Debtor Crescent Sourcetown NE1",
           "bank_id": "203301",
@@ -688,7 +688,7 @@ const ListResponse = `
         "currency": "GBP",
         "debtor_party": {
           "account_name": "EJ Brown Black",
-          "account_number": "GB29XABC10161234567801",
+          "account_number": "GB83XABC10161234567801",
           "account_number_code": "IBAN",
           "address": "10 Debtor Crescent Sourcetown NE1",
           "bank_id": "203301",
@@ -753,7 +753,7 @@ const ListResponse = `
         "currency": "GBP",
         "debtor_party": {
           "account_name": "EJ Brown Black",
-          "account_number": "GB29XABC10161234567801",
+          "account_number": "GB83XABC10161234567801",
           "account_number_code": "IBAN",
           "address": "10 Debtor Crescent Sourcetown NE1",
           "bank_id": "203301",
@@ -818,7 +818,7 @@ const ListResponse = `
         "currency": "GBP",
         "debtor_party": {
           "account_name": "EJ Brown Black",
-          "account_number": "GB29XABC10161234567801",
+          "account_number": "GB83XABC10161234567801",
           "account_number_code": "IBAN",
           "address": "10 Debtor Crescent Sourcetown NE1",
           "bank_id": "203301",
@@ -883,7 +883,7 @@ const ListResponse = `
         "currency": "GBP",
         "debtor_party": {
           "account_name": "EJ Brown Black",
-          "account_number": "GB29XABC10161234567801",
+          "account_number": "GB83XABC10161234567801",
           "account_number_code": "IBAN",
           "address": "10 Debtor Crescent Sourcetown NE1",
           "bank_id": "203301",
@@ -0,0 +1,87 @@
+// Package tracing sets up the OpenTelemetry tracer provider that exports the spans of the server, either to an OTLP
+// collector or, for local runs, as JSON to standard output.
+package tracing
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.39.0"
+)
+
+// The exporters spans can be sent to.
+const (
+	// ExporterNone does not export spans, tracing is off.
+	ExporterNone = "none"
+	// ExporterStdout writes spans as JSON, one per line, which is useful for local runs.
+	ExporterStdout = "stdout"
+	// ExporterOTLP sends spans to an OpenTelemetry collector over OTLP/HTTP.
+	ExporterOTLP = "otlp"
+)
+
+const (
+	// ServiceName is the service the spans are reported as coming from.
+	ServiceName = "payments"
+	// shutdownTimeout is how long the spans still waiting to be exported are given to be sent on close.
+	shutdownTimeout = 5 * time.Second
+)
+
+// ErrUnknownExporter is returned when asked for an exporter other than those above.
+var ErrUnknownExporter = errors.New("unknown trace exporter")
+
+// Provider is a tracer provider that can be closed with the server, exporting the spans it still holds.
+type Provider struct {
+	*sdktrace.TracerProvider
+}
+
+// NewProvider returns a provider exporting spans with the exporter, ExporterStdout or ExporterOTLP, reporting them as
+// coming from the version of the service. OTLP spans are sent to the endpoint, a URL such as
+// http://collector:4318, or when it is empty to where the standard OTEL_EXPORTER_OTLP_ENDPOINT environment variables
+// say. Stdout spans are written to out.
+func NewProvider(ctx context.Context, exporter, endpoint, version string, out io.Writer) (*Provider, error) {
+	var spanExporter sdktrace.SpanExporter
+	var err error
+	switch exporter {
+	case ExporterStdout:
+		spanExporter, err = stdouttrace.New(stdouttrace.WithWriter(out))
+	case ExporterOTLP:
+		var options []otlptracehttp.Option
+		if endpoint != "" {
+			options = append(options, otlptracehttp.WithEndpointURL(endpoint))
+		}
+		spanExporter, err = otlptracehttp.New(ctx, options...)
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrUnknownExporter, exporter)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to create %s trace exporter: %v", exporter, err)
+	}
+
+	// schemaless so that it merges with the default resource whichever semantic conventions the SDK describes it with
+	serviceResource, err := resource.Merge(resource.Default(),
+		resource.NewSchemaless(semconv.ServiceName(ServiceName), semconv.ServiceVersion(version)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to describe the service for tracing: %v", err)
+	}
+
+	return &Provider{sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(spanExporter),
+		sdktrace.WithResource(serviceResource),
+	)}, nil
+}
+
+// Close exports the spans still waiting to be sent and stops the provider, giving up after a few seconds so that an
+// unreachable collector does not hold up shutting down.
+func (provider *Provider) Close() error {
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+
+	return provider.Shutdown(ctx)
+}
@@ -0,0 +1,52 @@
+package tracing_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/cdempsie/payments-example/tracing"
+)
+
+func TestStdoutProvider(t *testing.T) {
+	var out bytes.Buffer
+	provider, err := tracing.NewProvider(context.Background(), tracing.ExporterStdout, "", "1.2.0", &out)
+	if err != nil {
+		t.Fatalf("failed to create provider: %v", err)
+	}
+	_, span := provider.Tracer("test").Start(context.Background(), "GET /healthz")
+	span.End()
+	if err := provider.Close(); err != nil {
+		t.Fatalf("failed to close provider: %v", err)
+	}
+
+	exported := struct {
+		Name     string
+		Resource []struct {
+			Key   string
+			Value struct{ Value interface{} }
+		}
+	}{}
+	if err := json.Unmarshal(out.Bytes(), &exported); err != nil {
+		t.Fatalf("expected the span as JSON, got %s: %v", out.String(), err)
+	}
+	if exported.Name != "GET /healthz" {
+		t.Errorf("exported wrong span: %s", out.String())
+	}
+	attributes := map[string]interface{}{}
+	for _, attribute := range exported.Resource {
+		attributes[attribute.Key] = attribute.Value.Value
+	}
+	if attributes["service.name"] != tracing.ServiceName || attributes["service.version"] != "1.2.0" {
+		t.Errorf("expected the span to come from the service, got %v", attributes)
+	}
+}
+
+func TestUnknownExporter(t *testing.T) {
+	_, err := tracing.NewProvider(context.Background(), "zipkin", "", "dev", nil)
+	if !errors.Is(err, tracing.ErrUnknownExporter) {
+		t.Errorf("expected an unknown exporter error, got %v", err)
+	}
+}